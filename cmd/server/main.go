@@ -2,11 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,17 +17,43 @@ import (
 	"github.com/Piyushhbhutoria/tote-assignment/internal/plugins/customer_lookup"
 	"github.com/Piyushhbhutoria/tote-assignment/internal/plugins/employee_tracker"
 	"github.com/Piyushhbhutoria/tote-assignment/internal/plugins/purchase_recommender"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/simulator"
 	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
 	"github.com/Piyushhbhutoria/tote-assignment/pkg/kafka"
 	"github.com/gin-gonic/gin"
 )
 
 type server struct {
-	db          *database.Connection
-	pluginMgr   *plugins.Manager
-	pluginStats map[string]*models.PluginStats
-	statsMutex  sync.RWMutex
-	consumer    *kafka.Consumer
+	db        *database.Connection
+	pluginMgr *plugins.Manager
+	stats     pluginStats
+	consumer  consumerControl
+	// topics reports how the Kafka topics drifted, nil on other transports
+	topics topicStatus
+}
+
+// pluginStats returns the stats of a plugin
+type pluginStats interface {
+	Get(name string) (models.PluginStats, bool)
+}
+
+// topicStatus compares the topics on the brokers with their declarations
+type topicStatus interface {
+	Status() ([]kafka.TopicStatus, error)
+}
+
+// consumerControl reports how far the consumer is behind and lets operators
+// pause it and move its offsets
+type consumerControl interface {
+	Lag() []kafka.PartitionLag
+	TotalLag() int64
+	LagThreshold() int64
+	Healthy() bool
+	Pause(partitions ...kafka.TopicPartition)
+	Resume(partitions ...kafka.TopicPartition)
+	Paused() kafka.PauseState
+	ResetOffsets(ctx context.Context, reset kafka.OffsetReset) error
+	Dropped() int64
 }
 
 func main() {
@@ -48,10 +76,11 @@ func main() {
 	pluginMgr := plugins.NewManager(db)
 
 	// Create server instance
+	stats := plugins.NewStats()
 	srv := &server{
-		db:          db,
-		pluginMgr:   pluginMgr,
-		pluginStats: make(map[string]*models.PluginStats),
+		db:        db,
+		pluginMgr: pluginMgr,
+		stats:     stats,
 	}
 
 	// Register plugins
@@ -59,9 +88,39 @@ func main() {
 		log.Fatalf("Failed to register plugins: %v", err)
 	}
 
-	// Create Kafka consumer
+	// Skip redelivered events the plugins already processed
+	pluginCfg := plugins.NewDefaultConfig()
+	var dedup *plugins.PostgresDedupStore
+	var dedupStore plugins.DedupStore
+	if pluginCfg.Dedup.Enabled {
+		dedup = plugins.NewPostgresDedupStore(db, pluginCfg.Dedup)
+		dedupStore = dedup
+	}
+	pluginMgr.Use(middleware(stats, dedupStore, pluginCfg.Timeout)...)
+
 	kafkaCfg := kafka.NewDefaultConfig()
-	consumer, err := kafka.NewConsumer(kafkaCfg, srv.handleEvent)
+	// The postgres transport shares the server's connection and schema
+	kafkaCfg.Database = db
+
+	// Create the topics before subscribing to them, Kafka being the default
+	// transport
+	if kafkaCfg.Transport == "" || kafkaCfg.Transport == kafka.TransportKafka {
+		topics, err := kafka.NewTopicManager(kafkaCfg)
+		if err != nil {
+			log.Fatalf("Failed to create topic manager: %v", err)
+		}
+		defer topics.Close()
+
+		if kafkaCfg.ProvisionTopics {
+			if err := topics.EnsureTopics(); err != nil {
+				log.Fatalf("Failed to provision topics: %v", err)
+			}
+		}
+		srv.topics = topics
+	}
+
+	// Create Kafka consumer
+	consumer, err := kafka.NewConsumer(kafkaCfg, pluginMgr.HandleEvent)
 	if err != nil {
 		log.Fatalf("Failed to create consumer: %v", err)
 	}
@@ -90,8 +149,18 @@ func main() {
 		api.GET("/plugins", srv.handleListPlugins)
 		api.PATCH("/plugins/:name/status", srv.handleUpdatePluginStatus)
 		api.PATCH("/plugins/:name/config", srv.handleUpdatePluginConfig)
+		api.GET("/consumer", srv.handleConsumerState)
+		api.GET("/consumer/lag", srv.handleConsumerLag)
+		api.POST("/consumer/pause", srv.handlePauseConsumer)
+		api.POST("/consumer/resume", srv.handleResumeConsumer)
+		api.POST("/consumer/reset", srv.handleResetOffsets)
+		api.GET("/topics", srv.handleTopics)
 	}
 
+	// Probes and metrics
+	r.GET("/readyz", srv.handleReady)
+	r.GET("/metrics", srv.handleMetrics)
+
 	// Create HTTP server
 	httpServer := &http.Server{
 		Addr:    ":8080",
@@ -120,6 +189,31 @@ func main() {
 		}
 	}()
 
+	// Purge expired processed events
+	if dedup != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dedup.Run(ctx, pluginCfg.Dedup.PurgeInterval)
+		}()
+	}
+
+	// Without Kafka there is no external producer, so simulate one in-process
+	if kafkaCfg.Transport == kafka.TransportMemory {
+		producer, err := kafka.NewProducer(kafkaCfg)
+		if err != nil {
+			log.Fatalf("Failed to create producer: %v", err)
+		}
+		defer producer.Close()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Println("Simulating POS events on the memory transport...")
+			simulator.Run(ctx, producer)
+		}()
+	}
+
 	// Start consuming events
 	wg.Add(1)
 	go func() {
@@ -172,91 +266,72 @@ func (s *server) registerPlugins() error {
 	return nil
 }
 
-func (s *server) handleEvent(ctx context.Context, event *models.Event) error {
-	// Process event through all plugins
-	plugins := s.pluginMgr.ListPlugins()
-	for _, plugin := range plugins {
-		// Skip inactive plugins
-		if !plugin.IsActive() {
-			continue
-		}
-
-		// Process event through plugin
-		newEvents, err := plugin.ProcessEvent(ctx, event)
-
-		// Update plugin stats
-		s.statsMutex.Lock()
-		stats, ok := s.pluginStats[plugin.Name()]
-		if !ok {
-			stats = &models.PluginStats{}
-			s.pluginStats[plugin.Name()] = stats
-		}
-		stats.EventsProcessed++
-		stats.LastProcessed = &event.Timestamp
-		if err != nil {
-			stats.ErrorCount++
-		}
-		s.statsMutex.Unlock()
-
-		if err != nil {
-			log.Printf("Error processing event in plugin %s: %v", plugin.Name(), err)
-			continue
-		}
-
-		// Handle any new events generated by the plugin
-		for _, newEvent := range newEvents {
-			if err := s.handleEvent(ctx, newEvent); err != nil {
-				log.Printf("Error handling generated event: %v", err)
-			}
-		}
+// middleware returns the dispatch pipeline of the server, outermost first.
+// Duplicates are counted by the stats, and recovered panics and timeouts
+// are counted as errors.
+func middleware(stats *plugins.Stats, dedup plugins.DedupStore, timeout time.Duration) []plugins.Middleware {
+	chain := []plugins.Middleware{plugins.Logging(), stats.Middleware()}
+	if dedup != nil {
+		chain = append(chain, plugins.Dedup(dedup))
 	}
-
-	return nil
+	chain = append(chain, plugins.Recovery())
+	if timeout > 0 {
+		chain = append(chain, plugins.Timeout(timeout))
+	}
+	return chain
 }
 
 func (s *server) handleListPlugins(c *gin.Context) {
-	plugins := s.pluginMgr.ListPlugins()
+	registered := s.pluginMgr.ListPlugins()
 	response := make([]struct {
 		Name        string                 `json:"name"`
 		Description string                 `json:"description"`
 		IsActive    bool                   `json:"isActive"`
 		Config      map[string]interface{} `json:"config"`
+		EventTypes  []models.EventType     `json:"eventTypes"`
 		Stats       struct {
 			EventsProcessed int    `json:"eventsProcessed"`
 			LastProcessed   string `json:"lastProcessed,omitempty"`
 			ErrorCount      int    `json:"errorCount"`
+			RetryCount      int    `json:"retryCount"`
+			DuplicateCount  int    `json:"duplicateCount"`
 		} `json:"stats"`
-	}, len(plugins))
+	}, len(registered))
 
 	// Fill the response array in order
-	for i, p := range plugins {
+	for i, p := range registered {
 		response[i] = struct {
 			Name        string                 `json:"name"`
 			Description string                 `json:"description"`
 			IsActive    bool                   `json:"isActive"`
 			Config      map[string]interface{} `json:"config"`
+			EventTypes  []models.EventType     `json:"eventTypes"`
 			Stats       struct {
 				EventsProcessed int    `json:"eventsProcessed"`
 				LastProcessed   string `json:"lastProcessed,omitempty"`
 				ErrorCount      int    `json:"errorCount"`
+				RetryCount      int    `json:"retryCount"`
+				DuplicateCount  int    `json:"duplicateCount"`
 			} `json:"stats"`
 		}{
 			Name:        p.Name(),
 			Description: p.Description(),
 			IsActive:    p.IsActive(),
 			Config:      make(map[string]interface{}),
+			// nil when the plugin receives every event
+			EventTypes: plugins.EventTypes(p),
 		}
 
 		// Get plugin stats
-		s.statsMutex.RLock()
-		if stats, ok := s.pluginStats[p.Name()]; ok {
+		if stats, ok := s.stats.Get(p.Name()); ok {
 			response[i].Stats.EventsProcessed = stats.EventsProcessed
 			if stats.LastProcessed != nil {
 				response[i].Stats.LastProcessed = stats.LastProcessed.Format(time.RFC3339)
 			}
 			response[i].Stats.ErrorCount = stats.ErrorCount
+			response[i].Stats.RetryCount = stats.RetryCount
+			response[i].Stats.DuplicateCount = stats.DuplicateCount
 		}
-		s.statsMutex.RUnlock()
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -310,3 +385,164 @@ func (s *server) handleUpdatePluginConfig(c *gin.Context) {
 
 	c.Status(http.StatusOK)
 }
+
+func (s *server) handleConsumerLag(c *gin.Context) {
+	if s.consumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Consumer not running"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"partitions": s.consumer.Lag(),
+		"totalLag":   s.consumer.TotalLag(),
+		"threshold":  s.consumer.LagThreshold(),
+		"healthy":    s.consumer.Healthy(),
+	})
+}
+
+// handleTopics compares the Kafka topics with their declarations
+func (s *server) handleTopics(c *gin.Context) {
+	if s.topics == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Topic management not available"})
+		return
+	}
+
+	statuses, err := s.topics.Status()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	drifted := 0
+	for _, status := range statuses {
+		if len(status.Drift) > 0 {
+			drifted++
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"topics": statuses, "drifted": drifted})
+}
+
+func (s *server) handleConsumerState(c *gin.Context) {
+	if s.consumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Consumer not running"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"paused": s.consumer.Paused()})
+}
+
+// partitionsRequest selects partitions to pause or resume, all without any
+type partitionsRequest struct {
+	Partitions []kafka.TopicPartition `json:"partitions"`
+}
+
+func (s *server) handlePauseConsumer(c *gin.Context) {
+	s.updatePaused(c, func(partitions []kafka.TopicPartition) { s.consumer.Pause(partitions...) })
+}
+
+func (s *server) handleResumeConsumer(c *gin.Context) {
+	s.updatePaused(c, func(partitions []kafka.TopicPartition) { s.consumer.Resume(partitions...) })
+}
+
+// updatePaused applies a pause or resume request and responds with the new state
+func (s *server) updatePaused(c *gin.Context, apply func([]kafka.TopicPartition)) {
+	if s.consumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Consumer not running"})
+		return
+	}
+
+	// The body is optional
+	var req partitionsRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	apply(req.Partitions)
+	c.JSON(http.StatusOK, gin.H{"paused": s.consumer.Paused()})
+}
+
+func (s *server) handleResetOffsets(c *gin.Context) {
+	if s.consumer == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Consumer not running"})
+		return
+	}
+
+	var req kafka.OffsetReset
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	// The reset is applied when the consumer rejoins its group
+	if err := s.consumer.ResetOffsets(c.Request.Context(), req); err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, kafka.ErrInvalidReset):
+			status = http.StatusBadRequest
+		case errors.Is(err, kafka.ErrUnknownPartition):
+			status = http.StatusNotFound
+		case errors.Is(err, kafka.ErrResetNotApplied):
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "reset"})
+}
+
+// handleReady reports the server as not ready while the consumer lags
+// behind by more than the configured threshold
+func (s *server) handleReady(c *gin.Context) {
+	switch {
+	case s.consumer == nil:
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "starting"})
+	case !s.consumer.Healthy():
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"status":    "lagging",
+			"totalLag":  s.consumer.TotalLag(),
+			"threshold": s.consumer.LagThreshold(),
+		})
+	default:
+		c.JSON(http.StatusOK, gin.H{"status": "ready", "totalLag": s.consumer.TotalLag()})
+	}
+}
+
+// handleMetrics exposes consumer lag and dropped messages in the Prometheus
+// text format
+func (s *server) handleMetrics(c *gin.Context) {
+	var b strings.Builder
+	if s.consumer != nil {
+		lags := s.consumer.Lag()
+		writeGauge(&b, "pos_consumer_lag", "Messages the consumer is behind on a partition", lags,
+			func(p kafka.PartitionLag) int64 { return p.Lag })
+		writeGauge(&b, "pos_consumer_high_water_mark", "Offset of the next message produced to a partition", lags,
+			func(p kafka.PartitionLag) int64 { return p.HighWaterMark })
+		writeGauge(&b, "pos_consumer_committed_offset", "Offset committed by the consumer group on a partition", lags,
+			func(p kafka.PartitionLag) int64 { return p.Committed })
+		writeGauge(&b, "pos_consumer_marked_offset", "Offset of the next message the consumer will process", lags,
+			func(p kafka.PartitionLag) int64 { return p.Marked })
+
+		fmt.Fprintf(&b, "# HELP pos_consumer_lag_total Messages the consumer is behind on all partitions\n")
+		fmt.Fprintf(&b, "# TYPE pos_consumer_lag_total gauge\n")
+		fmt.Fprintf(&b, "pos_consumer_lag_total %d\n", s.consumer.TotalLag())
+
+		fmt.Fprintf(&b, "# HELP pos_consumer_dropped_total Failed messages committed without being dead-lettered\n")
+		fmt.Fprintf(&b, "# TYPE pos_consumer_dropped_total counter\n")
+		fmt.Fprintf(&b, "pos_consumer_dropped_total %d\n", s.consumer.Dropped())
+	}
+
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(b.String()))
+}
+
+// writeGauge writes a per-partition gauge in the Prometheus text format
+func writeGauge(b *strings.Builder, name, help string, lags []kafka.PartitionLag, value func(kafka.PartitionLag) int64) {
+	fmt.Fprintf(b, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(b, "# TYPE %s gauge\n", name)
+	for _, p := range lags {
+		fmt.Fprintf(b, "%s{topic=%q,partition=\"%d\"} %d\n", name, p.Topic, p.Partition, value(p))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -11,7 +12,7 @@ import (
 
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
 	"github.com/Piyushhbhutoria/tote-assignment/internal/plugins"
-	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/kafka"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -52,19 +53,16 @@ func (m *MockPlugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*
 }
 
 func setupTestServer(t *testing.T) (*server, *gin.Engine) {
-	// Initialize database
-	dbCfg := database.NewDefaultConfig()
-	db, err := database.New(context.Background(), dbCfg)
-	assert.NoError(t, err)
-
-	// Initialize plugin manager
-	pluginMgr := plugins.NewManager(db)
+	// The handlers only use the plugins, which are fakes, so the server
+	// runs without a database
+	pluginMgr := plugins.NewManager(nil)
 
 	// Create server instance
+	stats := plugins.NewStats()
+	pluginMgr.Use(middleware(stats, nil, 0)...)
 	srv := &server{
-		db:          db,
-		pluginMgr:   pluginMgr,
-		pluginStats: make(map[string]*models.PluginStats),
+		pluginMgr: pluginMgr,
+		stats:     stats,
 	}
 
 	// Initialize Gin router
@@ -77,11 +75,76 @@ func setupTestServer(t *testing.T) (*server, *gin.Engine) {
 		api.GET("/plugins", srv.handleListPlugins)
 		api.PATCH("/plugins/:name/status", srv.handleUpdatePluginStatus)
 		api.PATCH("/plugins/:name/config", srv.handleUpdatePluginConfig)
+		api.GET("/consumer", srv.handleConsumerState)
+		api.GET("/consumer/lag", srv.handleConsumerLag)
+		api.POST("/consumer/pause", srv.handlePauseConsumer)
+		api.POST("/consumer/resume", srv.handleResumeConsumer)
+		api.POST("/consumer/reset", srv.handleResetOffsets)
+		api.GET("/topics", srv.handleTopics)
 	}
+	r.GET("/readyz", srv.handleReady)
+	r.GET("/metrics", srv.handleMetrics)
 
 	return srv, r
 }
 
+// fakeConsumer reports a fixed lag and records control requests
+type fakeConsumer struct {
+	lags      []kafka.PartitionLag
+	threshold int64
+	dropped   int64
+	paused    kafka.PauseState
+	resets    []kafka.OffsetReset
+}
+
+func (f *fakeConsumer) Lag() []kafka.PartitionLag { return f.lags }
+
+func (f *fakeConsumer) TotalLag() int64 {
+	var total int64
+	for _, p := range f.lags {
+		total += p.Lag
+	}
+	return total
+}
+
+func (f *fakeConsumer) LagThreshold() int64 { return f.threshold }
+
+func (f *fakeConsumer) Dropped() int64 { return f.dropped }
+
+func (f *fakeConsumer) Healthy() bool { return f.TotalLag() <= f.threshold }
+
+func (f *fakeConsumer) Pause(partitions ...kafka.TopicPartition) {
+	if len(partitions) == 0 {
+		f.paused.All = true
+	}
+	f.paused.Partitions = append(f.paused.Partitions, partitions...)
+}
+
+func (f *fakeConsumer) Resume(partitions ...kafka.TopicPartition) {
+	f.paused = kafka.PauseState{}
+}
+
+func (f *fakeConsumer) Paused() kafka.PauseState { return f.paused }
+
+func (f *fakeConsumer) ResetOffsets(_ context.Context, reset kafka.OffsetReset) error {
+	if err := reset.Validate(); err != nil {
+		return err
+	}
+	if reset.Topic != "pos_events" {
+		return fmt.Errorf("%w: %s", kafka.ErrUnknownPartition, reset.Topic)
+	}
+	f.resets = append(f.resets, reset)
+	return nil
+}
+
+// fakeStats returns fixed plugin stats
+type fakeStats map[string]models.PluginStats
+
+func (f fakeStats) Get(name string) (models.PluginStats, bool) {
+	stats, ok := f[name]
+	return stats, ok
+}
+
 func TestHandleListPlugins(t *testing.T) {
 	srv, r := setupTestServer(t)
 
@@ -96,11 +159,12 @@ func TestHandleListPlugins(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Add some stats
-	srv.pluginStats["test_plugin"] = &models.PluginStats{
+	srv.stats = fakeStats{"test_plugin": {
 		EventsProcessed: 10,
 		LastProcessed:   &time.Time{},
 		ErrorCount:      2,
-	}
+		RetryCount:      1,
+	}}
 
 	// Create request
 	w := httptest.NewRecorder()
@@ -115,10 +179,12 @@ func TestHandleListPlugins(t *testing.T) {
 		Description string                 `json:"description"`
 		IsActive    bool                   `json:"isActive"`
 		Config      map[string]interface{} `json:"config"`
+		EventTypes  []models.EventType     `json:"eventTypes"`
 		Stats       struct {
 			EventsProcessed int    `json:"eventsProcessed"`
 			LastProcessed   string `json:"lastProcessed,omitempty"`
 			ErrorCount      int    `json:"errorCount"`
+			RetryCount      int    `json:"retryCount"`
 		} `json:"stats"`
 	}
 	err = json.Unmarshal(w.Body.Bytes(), &response)
@@ -127,12 +193,145 @@ func TestHandleListPlugins(t *testing.T) {
 	assert.Equal(t, "test_plugin", response[0].Name)
 	assert.Equal(t, "Test plugin description", response[0].Description)
 	assert.True(t, response[0].IsActive)
+	assert.Nil(t, response[0].EventTypes)
 	assert.Equal(t, 10, response[0].Stats.EventsProcessed)
 	assert.Equal(t, 2, response[0].Stats.ErrorCount)
+	assert.Equal(t, 1, response[0].Stats.RetryCount)
 
 	mockPlugin.AssertExpectations(t)
 }
 
+// subscribingPlugin is a mock plugin subscribing to some event types
+type subscribingPlugin struct {
+	MockPlugin
+	eventTypes []models.EventType
+}
+
+func (m *subscribingPlugin) EventTypes() []models.EventType {
+	return m.eventTypes
+}
+
+func TestHandleListPluginsSubscriptions(t *testing.T) {
+	srv, r := setupTestServer(t)
+
+	mockPlugin := &subscribingPlugin{eventTypes: []models.EventType{models.EventEmployeeLogin, models.EventEmployeeLogout}}
+	mockPlugin.On("Name").Return("test_plugin").Maybe()
+	mockPlugin.On("Description").Return("Test plugin description").Maybe()
+	mockPlugin.On("IsActive").Return(true).Maybe()
+	assert.NoError(t, srv.pluginMgr.RegisterPlugin(mockPlugin))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/plugins", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response []struct {
+		Name       string             `json:"name"`
+		EventTypes []models.EventType `json:"eventTypes"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response, 1)
+	assert.Equal(t, []models.EventType{models.EventEmployeeLogin, models.EventEmployeeLogout}, response[0].EventTypes)
+}
+
+// processedDedup is a dedup store that already saw the events in processed
+type processedDedup struct {
+	processed map[string]bool
+}
+
+func (d *processedDedup) Claim(_ context.Context, plugin, eventID, _ string) (plugins.ClaimStatus, error) {
+	if d.processed[plugin+"/"+eventID] {
+		return plugins.ClaimProcessed, nil
+	}
+	return plugins.ClaimAcquired, nil
+}
+
+func (d *processedDedup) Record(_ context.Context, plugin, eventID string) error {
+	d.processed[plugin+"/"+eventID] = true
+	return nil
+}
+
+func (d *processedDedup) Release(context.Context, string, string, string) error {
+	return nil
+}
+
+// newMiddlewareTest returns a manager running a mock plugin through the
+// middleware of the server
+func newMiddlewareTest(t *testing.T, dedup plugins.DedupStore, timeout time.Duration) (*plugins.Manager, *plugins.Stats, *MockPlugin) {
+	stats := plugins.NewStats()
+	mgr := plugins.NewManager(nil)
+	mgr.Use(middleware(stats, dedup, timeout)...)
+
+	mockPlugin := new(MockPlugin)
+	mockPlugin.On("Name").Return("test_plugin").Maybe()
+	mockPlugin.On("IsActive").Return(true).Maybe()
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+	return mgr, stats, mockPlugin
+}
+
+func newMiddlewareEvent() *models.Event {
+	return &models.Event{ID: "test_event", Type: "test_type", Timestamp: time.Now()}
+}
+
+func TestMiddlewareCountsPanics(t *testing.T) {
+	event := newMiddlewareEvent()
+	mgr, stats, mockPlugin := newMiddlewareTest(t, nil, 0)
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Run(func(mock.Arguments) {
+		panic("boom")
+	}).Once()
+
+	// A panicking plugin fails the delivery and counts as an error
+	assert.ErrorContains(t, mgr.HandleEvent(context.Background(), event), "panic: boom")
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 1, got.EventsProcessed)
+	assert.Equal(t, 1, got.ErrorCount)
+}
+
+func TestMiddlewareSkipsDuplicates(t *testing.T) {
+	event := newMiddlewareEvent()
+	dedup := &processedDedup{processed: map[string]bool{"test_plugin/test_event": true}}
+	mgr, stats, mockPlugin := newMiddlewareTest(t, dedup, time.Second)
+
+	// An event the plugin already processed is skipped without running it
+	assert.NoError(t, mgr.HandleEvent(context.Background(), event))
+	mockPlugin.AssertNotCalled(t, "ProcessEvent", mock.Anything, mock.Anything)
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 1, got.DuplicateCount)
+	assert.Zero(t, got.EventsProcessed)
+	assert.Zero(t, got.ErrorCount)
+}
+
+func TestMiddlewareTimeout(t *testing.T) {
+	event := newMiddlewareEvent()
+	mgr, stats, mockPlugin := newMiddlewareTest(t, nil, 10*time.Millisecond)
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Return([]*models.Event{}, context.DeadlineExceeded).Once()
+
+	// A slow plugin is cancelled once the timeout expires
+	assert.ErrorIs(t, mgr.HandleEvent(context.Background(), event), context.DeadlineExceeded)
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 1, got.ErrorCount)
+}
+
+func TestMiddlewareOptional(t *testing.T) {
+	event := newMiddlewareEvent()
+	mgr, stats, mockPlugin := newMiddlewareTest(t, nil, 0)
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Run(func(args mock.Arguments) {
+		_, ok := args.Get(0).(context.Context).Deadline()
+		assert.False(t, ok)
+	}).Return([]*models.Event{}, nil).Twice()
+
+	// Without dedup and timeout, redeliveries run the plugin without a
+	// deadline
+	assert.NoError(t, mgr.HandleEvent(context.Background(), event))
+	assert.NoError(t, mgr.HandleEvent(context.Background(), event))
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 2, got.EventsProcessed)
+	assert.Zero(t, got.DuplicateCount)
+	mockPlugin.AssertExpectations(t)
+}
+
 func TestHandleUpdatePluginStatus(t *testing.T) {
 	srv, r := setupTestServer(t)
 
@@ -193,107 +392,166 @@ func TestHandleUpdatePluginConfig(t *testing.T) {
 	mockPlugin.AssertExpectations(t)
 }
 
-func TestHandleEvent(t *testing.T) {
-	srv, _ := setupTestServer(t)
-
-	// Create mock plugin
-	mockPlugin := new(MockPlugin)
-	mockPlugin.On("Name").Return("test_plugin").Maybe()
-	mockPlugin.On("Description").Return("Test plugin description").Maybe()
-	mockPlugin.On("IsActive").Return(true).Maybe()
+func TestHandleConsumerLag(t *testing.T) {
+	srv, r := setupTestServer(t)
 
-	// Setup ProcessEvent expectations
-	ctx := context.Background()
-	event := &models.Event{
-		ID:        "test_event",
-		Type:      "test_type",
-		Payload:   map[string]interface{}{"key": "value"},
-		Timestamp: time.Now(),
+	// Not ready until the consumer is running
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	consumer := &fakeConsumer{
+		lags: []kafka.PartitionLag{
+			{Topic: "pos_events", Partition: 0, HighWaterMark: 120, Committed: 90, Marked: 100, Lag: 20},
+			{Topic: "pos_events", Partition: 1, HighWaterMark: 50, Committed: 45, Marked: 45, Lag: 5},
+		},
+		threshold: 100,
+		dropped:   3,
 	}
-	mockPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{}, nil).Once()
+	srv.consumer = consumer
 
-	// Register plugin
-	err := srv.pluginMgr.RegisterPlugin(mockPlugin)
-	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/consumer/lag", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
 
-	// Test handling event
-	err = srv.handleEvent(ctx, event)
+	var response struct {
+		Partitions []kafka.PartitionLag `json:"partitions"`
+		TotalLag   int64                `json:"totalLag"`
+		Threshold  int64                `json:"threshold"`
+		Healthy    bool                 `json:"healthy"`
+	}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
+	assert.Equal(t, consumer.lags, response.Partitions)
+	assert.Equal(t, int64(25), response.TotalLag)
+	assert.True(t, response.Healthy)
 
-	// Verify stats were updated
-	stats := srv.pluginStats["test_plugin"]
-	assert.NotNil(t, stats)
-	assert.Equal(t, 1, stats.EventsProcessed)
-	assert.Equal(t, 0, stats.ErrorCount)
-	assert.Equal(t, &event.Timestamp, stats.LastProcessed)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `pos_consumer_lag{topic="pos_events",partition="0"} 20`)
+	assert.Contains(t, w.Body.String(), `pos_consumer_committed_offset{topic="pos_events",partition="0"} 90`)
+	assert.Contains(t, w.Body.String(), `pos_consumer_marked_offset{topic="pos_events",partition="0"} 100`)
+	assert.Contains(t, w.Body.String(), "pos_consumer_lag_total 25")
+	assert.Contains(t, w.Body.String(), "pos_consumer_dropped_total 3")
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
 
-	mockPlugin.AssertExpectations(t)
+	// Readiness fails once the lag exceeds the threshold
+	consumer.threshold = 10
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/readyz", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "lagging")
 }
 
-func TestHandleEventWithError(t *testing.T) {
-	srv, _ := setupTestServer(t)
+func TestHandleConsumerControl(t *testing.T) {
+	srv, r := setupTestServer(t)
 
-	// Create mock plugin
-	mockPlugin := new(MockPlugin)
-	mockPlugin.On("Name").Return("test_plugin").Maybe()
-	mockPlugin.On("Description").Return("Test plugin description").Maybe()
-	mockPlugin.On("IsActive").Return(true).Maybe()
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/consumer/pause", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	consumer := &fakeConsumer{}
+	srv.consumer = consumer
 
-	// Setup ProcessEvent expectations with error
-	ctx := context.Background()
-	event := &models.Event{
-		ID:        "test_event",
-		Type:      "test_type",
-		Payload:   map[string]interface{}{"key": "value"},
-		Timestamp: time.Now(),
+	// Pause a single partition
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/consumer/pause", bytes.NewBufferString(`{"partitions":[{"topic":"pos_events","partition":2}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []kafka.TopicPartition{{Topic: "pos_events", Partition: 2}}, consumer.paused.Partitions)
+
+	// Pause everything without a body
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/consumer/pause", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/consumer", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var state struct {
+		Paused kafka.PauseState `json:"paused"`
 	}
-	mockPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{}, assert.AnError).Once()
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &state))
+	assert.True(t, state.Paused.All)
 
-	// Register plugin
-	err := srv.pluginMgr.RegisterPlugin(mockPlugin)
-	assert.NoError(t, err)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/consumer/resume", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.False(t, consumer.paused.All)
 
-	// Test handling event
-	err = srv.handleEvent(ctx, event)
-	assert.NoError(t, err) // Main handler doesn't return error, just logs it
+	// Rewind to a point in time
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/consumer/reset", bytes.NewBufferString(`{"topic":"pos_events","timestamp":"2024-05-01T10:00:00Z"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, consumer.resets, 1) {
+		assert.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), *consumer.resets[0].Timestamp)
+	}
 
-	// Verify stats were updated
-	stats := srv.pluginStats["test_plugin"]
-	assert.NotNil(t, stats)
-	assert.Equal(t, 1, stats.EventsProcessed)
-	assert.Equal(t, 1, stats.ErrorCount)
-	assert.Equal(t, &event.Timestamp, stats.LastProcessed)
+	// A reset needs an offset or a timestamp
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/consumer/reset", bytes.NewBufferString(`{"topic":"pos_events"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
 
-	mockPlugin.AssertExpectations(t)
+	// Only consumed topics can be reset
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/consumer/reset", bytes.NewBufferString(`{"topic":"pos_other","offset":0}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Len(t, consumer.resets, 1)
 }
 
-func TestHandleEventWithInactivePlugin(t *testing.T) {
-	srv, _ := setupTestServer(t)
+// fakeTopics reports fixed topic statuses
+type fakeTopics struct {
+	statuses []kafka.TopicStatus
+}
 
-	// Create mock plugin
-	mockPlugin := new(MockPlugin)
-	mockPlugin.On("Name").Return("test_plugin").Maybe()
-	mockPlugin.On("Description").Return("Test plugin description").Maybe()
-	mockPlugin.On("IsActive").Return(false).Maybe() // Plugin is inactive
+func (f *fakeTopics) Status() ([]kafka.TopicStatus, error) {
+	return f.statuses, nil
+}
 
-	// Register plugin
-	err := srv.pluginMgr.RegisterPlugin(mockPlugin)
-	assert.NoError(t, err)
+func TestHandleTopics(t *testing.T) {
+	srv, r := setupTestServer(t)
 
-	// Test handling event
-	ctx := context.Background()
-	event := &models.Event{
-		ID:        "test_event",
-		Type:      "test_type",
-		Payload:   map[string]interface{}{"key": "value"},
-		Timestamp: time.Now(),
-	}
-	err = srv.handleEvent(ctx, event)
-	assert.NoError(t, err)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/topics", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
 
-	// Verify no stats were updated
-	stats := srv.pluginStats["test_plugin"]
-	assert.Nil(t, stats)
+	srv.topics = &fakeTopics{statuses: []kafka.TopicStatus{
+		{Declared: kafka.TopicSpec{Name: "pos_events", Partitions: 3}, Exists: true, Partitions: 1, Drift: []string{"1 partitions instead of 3"}},
+		{Declared: kafka.TopicSpec{Name: "pos_events_dlq", Partitions: 3}, Exists: true, Partitions: 3},
+	}}
 
-	mockPlugin.AssertExpectations(t)
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/topics", nil)
+	r.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response struct {
+		Topics  []kafka.TopicStatus `json:"topics"`
+		Drifted int                 `json:"drifted"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Len(t, response.Topics, 2)
+	assert.Equal(t, 1, response.Drifted)
+	assert.Equal(t, []string{"1 partitions instead of 3"}, response.Topics[0].Drift)
 }
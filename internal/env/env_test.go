@@ -0,0 +1,41 @@
+package env
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaults(t *testing.T) {
+	assert.Equal(t, "default", String("ENV_TEST_STRING", "default"))
+	assert.Equal(t, 3, Int("ENV_TEST_INT", 3))
+	assert.True(t, Bool("ENV_TEST_BOOL", true))
+	assert.Equal(t, time.Second, Duration("ENV_TEST_DURATION", time.Second))
+	assert.Equal(t, []string{"a"}, List("ENV_TEST_LIST", []string{"a"}))
+}
+
+func TestValues(t *testing.T) {
+	t.Setenv("ENV_TEST_STRING", "value")
+	t.Setenv("ENV_TEST_INT", "7")
+	t.Setenv("ENV_TEST_BOOL", "false")
+	t.Setenv("ENV_TEST_DURATION", "2m")
+	t.Setenv("ENV_TEST_LIST", " a, ,b ")
+
+	assert.Equal(t, "value", String("ENV_TEST_STRING", "default"))
+	assert.Equal(t, 7, Int("ENV_TEST_INT", 3))
+	assert.False(t, Bool("ENV_TEST_BOOL", true))
+	assert.Equal(t, 2*time.Minute, Duration("ENV_TEST_DURATION", time.Second))
+	assert.Equal(t, []string{"a", "b"}, List("ENV_TEST_LIST", nil))
+}
+
+func TestInvalidValues(t *testing.T) {
+	t.Setenv("ENV_TEST_INT", "seven")
+	t.Setenv("ENV_TEST_BOOL", "maybe")
+	t.Setenv("ENV_TEST_DURATION", "soon")
+
+	// Invalid values fall back to the default
+	assert.Equal(t, 3, Int("ENV_TEST_INT", 3))
+	assert.True(t, Bool("ENV_TEST_BOOL", true))
+	assert.Equal(t, time.Second, Duration("ENV_TEST_DURATION", time.Second))
+}
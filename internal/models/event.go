@@ -15,12 +15,37 @@ const (
 	EventPaymentComplete  EventType = "PAYMENT_COMPLETE"
 )
 
-// Event represents a base POS event
+// Event represents a base POS event. Events derived by plugins carry the ID
+// of the event that caused them and the ID of the root event of the chain.
+//
+// Timestamp is the event time, when the event happened at the terminal.
+// IngestedAt is when the event was appended to the stream and ProcessedAt is
+// when the consumer handed it to the plugins. Late is set when the event
+// arrived further behind its event time than the configured threshold.
 type Event struct {
-	ID        string    `json:"id"`
-	Type      EventType `json:"type"`
-	Timestamp time.Time `json:"timestamp"`
-	Payload   any       `json:"payload"`
+	ID            string    `json:"id"`
+	Type          EventType `json:"type"`
+	Version       int       `json:"version"`
+	Timestamp     time.Time `json:"timestamp"`
+	IngestedAt    time.Time `json:"ingested_at,omitzero"`
+	ProcessedAt   time.Time `json:"processed_at,omitzero"`
+	Late          bool      `json:"late,omitempty"`
+	CorrelationID string    `json:"correlation_id,omitempty"`
+	CausationID   string    `json:"causation_id,omitempty"`
+	Payload       any       `json:"payload"`
+}
+
+// EventTime returns when the event happened
+func (e *Event) EventTime() time.Time {
+	return e.Timestamp
+}
+
+// Lateness returns how far behind its event time the event was ingested
+func (e *Event) Lateness() time.Duration {
+	if e.IngestedAt.IsZero() || e.Timestamp.IsZero() {
+		return 0
+	}
+	return e.IngestedAt.Sub(e.Timestamp)
 }
 
 // BasePayload contains common fields for all event payloads
@@ -0,0 +1,178 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is assumed for amounts that carry no currency, such as
+// legacy float prices and DECIMAL columns
+const DefaultCurrency = "USD"
+
+// minorUnitDigits is the number of decimal places of a minor unit. All
+// supported currencies use cents, matching the DECIMAL(10,2) columns.
+const minorUnitDigits = 2
+
+// Money is an amount in integer minor units (cents) of a currency.
+// It implements sql.Scanner and driver.Valuer, which pgx uses to read and
+// write DECIMAL columns without going through float64.
+type Money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// NewMoney returns an amount in minor units of the given currency
+func NewMoney(amount int64, currency string) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+// ParseMoney parses a decimal string such as "10.99" into minor units
+func ParseMoney(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	// strconv would accept a second sign
+	if strings.ContainsAny(s, "+-") {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	whole, frac, _ := strings.Cut(s, ".")
+	if whole == "" && frac == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	// Trailing zeros beyond the minor unit carry no value
+	if len(frac) > minorUnitDigits {
+		if strings.TrimRight(frac[minorUnitDigits:], "0") != "" {
+			return Money{}, fmt.Errorf("amount %q has more than %d decimal places", s, minorUnitDigits)
+		}
+		frac = frac[:minorUnitDigits]
+	}
+	frac += strings.Repeat("0", minorUnitDigits-len(frac))
+
+	if whole == "" {
+		whole = "0"
+	}
+	units, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return Money{}, fmt.Errorf("invalid amount %q: %v", s, err)
+	}
+
+	if negative {
+		units = -units
+	}
+	return Money{Amount: units, Currency: currency}, nil
+}
+
+// MoneyFromFloat converts a float amount, rounding to the nearest minor unit
+func MoneyFromFloat(f float64, currency string) Money {
+	return Money{Amount: int64(math.Round(f * math.Pow10(minorUnitDigits))), Currency: currency}
+}
+
+// String formats the amount as a decimal, e.g. "10.99"
+func (m Money) String() string {
+	amount := m.Amount
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+
+	scale := int64(math.Pow10(minorUnitDigits))
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/scale, minorUnitDigits, amount%scale)
+}
+
+// Add returns the sum of two amounts of the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", other.Currency, m.Currency)
+	}
+	return Money{Amount: m.Amount + other.Amount, Currency: m.Currency}, nil
+}
+
+// Multiply returns the amount multiplied by a quantity
+func (m Money) Multiply(quantity int) Money {
+	return Money{Amount: m.Amount * int64(quantity), Currency: m.Currency}
+}
+
+// Scan implements sql.Scanner for DECIMAL columns
+func (m *Money) Scan(src any) error {
+	currency := m.Currency
+	if currency == "" {
+		currency = DefaultCurrency
+	}
+
+	var (
+		parsed Money
+		err    error
+	)
+	switch v := src.(type) {
+	case nil:
+		*m = Money{}
+		return nil
+	case string:
+		parsed, err = parseDecimal(v, currency)
+	case []byte:
+		parsed, err = parseDecimal(string(v), currency)
+	case int64:
+		parsed = Money{Amount: v * int64(math.Pow10(minorUnitDigits)), Currency: currency}
+	case float64:
+		parsed = MoneyFromFloat(v, currency)
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	if err != nil {
+		return err
+	}
+
+	*m = parsed
+	return nil
+}
+
+// maxDecimalExponent bounds the exponent of scanned decimals, DECIMAL(10,2)
+// columns never come close to it
+const maxDecimalExponent = 64
+
+// parseDecimal parses a decimal read from the database. pgx passes numeric
+// values to scanners in exponent form, such as "1099e-2" for 10.99, which
+// is moved back into plain decimal form before parsing.
+func parseDecimal(s, currency string) (Money, error) {
+	s = strings.TrimSpace(s)
+	mantissa, exp, ok := strings.Cut(strings.ToLower(s), "e")
+	if !ok {
+		return ParseMoney(s, currency)
+	}
+
+	shift, err := strconv.Atoi(exp)
+	if err != nil || shift > maxDecimalExponent || shift < -maxDecimalExponent {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+	sign := ""
+	if strings.HasPrefix(mantissa, "-") {
+		sign, mantissa = "-", mantissa[1:]
+	}
+	whole, frac, _ := strings.Cut(mantissa, ".")
+	digits := whole + frac
+	if digits == "" {
+		return Money{}, fmt.Errorf("invalid amount %q", s)
+	}
+
+	point := len(whole) + shift
+	switch {
+	case point <= 0:
+		mantissa = "0." + strings.Repeat("0", -point) + digits
+	case point >= len(digits):
+		mantissa = digits + strings.Repeat("0", point-len(digits))
+	default:
+		mantissa = digits[:point] + "." + digits[point:]
+	}
+	return ParseMoney(sign+mantissa, currency)
+}
+
+// Value implements driver.Valuer, writing the amount as a decimal string
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMoney(t *testing.T) {
+	cases := map[string]int64{
+		"10.99":  1099,
+		"10.9":   1090,
+		"10":     1000,
+		".5":     50,
+		"-1.25":  -125,
+		"0.10":   10,
+		"5.9900": 599,
+	}
+	for in, want := range cases {
+		m, err := ParseMoney(in, "USD")
+		assert.NoError(t, err, in)
+		assert.Equal(t, NewMoney(want, "USD"), m, in)
+	}
+
+	for _, in := range []string{"", "abc", "1.999", "1.2.3", "--5", "-+5", "+-5", "+5"} {
+		_, err := ParseMoney(in, "USD")
+		assert.Error(t, err, in)
+	}
+}
+
+func TestMoneyString(t *testing.T) {
+	assert.Equal(t, "10.99", NewMoney(1099, "USD").String())
+	assert.Equal(t, "0.05", NewMoney(5, "USD").String())
+	assert.Equal(t, "-1.25", NewMoney(-125, "USD").String())
+}
+
+func TestMoneyArithmetic(t *testing.T) {
+	// 0.1 + 0.2 drifts with float64 but not with minor units
+	sum, err := NewMoney(10, "USD").Add(NewMoney(20, "USD"))
+	assert.NoError(t, err)
+	assert.Equal(t, "0.30", sum.String())
+
+	assert.Equal(t, NewMoney(3297, "USD"), NewMoney(1099, "USD").Multiply(3))
+
+	_, err = NewMoney(10, "USD").Add(NewMoney(10, "EUR"))
+	assert.Error(t, err)
+}
+
+func TestMoneyScanAndValue(t *testing.T) {
+	var m Money
+	assert.NoError(t, m.Scan("15.99"))
+	assert.Equal(t, NewMoney(1599, DefaultCurrency), m)
+
+	assert.NoError(t, m.Scan([]byte("5.99")))
+	assert.Equal(t, NewMoney(599, DefaultCurrency), m)
+
+	// pgx passes DECIMAL columns in exponent form
+	for in, want := range map[string]int64{
+		"1099e-2":  1099,
+		"-1099e-2": -1099,
+		"15e0":     1500,
+		"2e1":      2000,
+		"5e-2":     5,
+		"1.5e1":    1500,
+		"10990e-3": 1099,
+	} {
+		assert.NoError(t, m.Scan(in), in)
+		assert.Equal(t, NewMoney(want, DefaultCurrency), m, in)
+	}
+	assert.Error(t, m.Scan("1e-3"))
+	assert.Error(t, m.Scan("1e"))
+	assert.Error(t, m.Scan("e2"))
+
+	assert.Error(t, m.Scan(true))
+
+	v, err := NewMoney(2099, "USD").Value()
+	assert.NoError(t, err)
+	assert.Equal(t, "20.99", v)
+}
+
+func TestMoneyJSON(t *testing.T) {
+	data, err := json.Marshal(NewMoney(1099, "USD"))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"amount":1099,"currency":"USD"}`, string(data))
+}
@@ -0,0 +1,410 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Derived event types produced by plugins
+const (
+	EventCustomerData            EventType = "CUSTOMER_DATA"
+	EventPurchaseRecommendations EventType = "PURCHASE_RECOMMENDATIONS"
+)
+
+// EventPayload is implemented by every typed event payload
+type EventPayload interface {
+	// Validate checks that all required fields are present
+	Validate() error
+}
+
+// ValidationError describes why an event was rejected
+type ValidationError struct {
+	EventType EventType `json:"event_type"`
+	Field     string    `json:"field,omitempty"`
+	Reason    string    `json:"reason"`
+}
+
+func (e *ValidationError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("invalid %s event: %s: %s", e.EventType, e.Field, e.Reason)
+	}
+	return fmt.Sprintf("invalid %s event: %s", e.EventType, e.Reason)
+}
+
+// BasketPayload contains the fields shared by all basket events
+type BasketPayload struct {
+	BasePayload
+	EmployeeID string `json:"employee_id"`
+	BasketID   string `json:"basket_id"`
+}
+
+// EmployeeLoginPayload is the payload of an EMPLOYEE_LOGIN event
+type EmployeeLoginPayload struct {
+	BasePayload
+	EmployeeID string `json:"employee_id"`
+}
+
+// EmployeeLogoutPayload is the payload of an EMPLOYEE_LOGOUT event
+type EmployeeLogoutPayload struct {
+	BasePayload
+	EmployeeID string `json:"employee_id"`
+	AutoLogout bool   `json:"auto_logout,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// StartBasketPayload is the payload of a START_BASKET event
+type StartBasketPayload struct {
+	BasketPayload
+}
+
+// CustomerIdentifyPayload is the payload of a CUSTOMER_IDENTIFY event
+type CustomerIdentifyPayload struct {
+	BasketPayload
+	CustomerID string `json:"customer_id"`
+}
+
+// AddItemPayload is the payload of an ADD_ITEM event
+type AddItemPayload struct {
+	BasketPayload
+	ItemID   string `json:"item_id"`
+	Price    Money  `json:"price"`
+	Quantity int    `json:"quantity"`
+}
+
+// FinalizeSubtotalPayload is the payload of a FINALIZE_SUBTOTAL event
+type FinalizeSubtotalPayload struct {
+	BasketPayload
+}
+
+// PaymentCompletePayload is the payload of a PAYMENT_COMPLETE event
+type PaymentCompletePayload struct {
+	BasketPayload
+	PaymentMethod string `json:"payment_method"`
+}
+
+// CustomerDataPayload is the payload of a CUSTOMER_DATA event
+type CustomerDataPayload struct {
+	BasePayload
+	BasketID   string         `json:"basket_id"`
+	CustomerID string         `json:"customer_id"`
+	Data       map[string]any `json:"data"`
+}
+
+// Recommendation is a single recommended item
+type Recommendation struct {
+	ItemID          string  `json:"item_id"`
+	Name            string  `json:"name"`
+	Price           Money   `json:"price"`
+	ConfidenceScore float64 `json:"confidence_score"`
+}
+
+// PurchaseRecommendationsPayload is the payload of a PURCHASE_RECOMMENDATIONS event
+type PurchaseRecommendationsPayload struct {
+	BasePayload
+	BasketID        string           `json:"basket_id"`
+	SourceItemID    string           `json:"source_item_id"`
+	Recommendations []Recommendation `json:"recommendations"`
+}
+
+// Validate checks the common payload fields
+func (p *BasePayload) Validate() error {
+	if p.TerminalID == "" {
+		return &ValidationError{Field: "terminal_id", Reason: "is required"}
+	}
+	if p.StoreID == "" {
+		return &ValidationError{Field: "store_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the common basket fields
+func (p *BasketPayload) Validate() error {
+	if err := p.BasePayload.Validate(); err != nil {
+		return err
+	}
+	if p.EmployeeID == "" {
+		return &ValidationError{Field: "employee_id", Reason: "is required"}
+	}
+	if p.BasketID == "" {
+		return &ValidationError{Field: "basket_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the login payload
+func (p *EmployeeLoginPayload) Validate() error {
+	if err := p.BasePayload.Validate(); err != nil {
+		return err
+	}
+	if p.EmployeeID == "" {
+		return &ValidationError{Field: "employee_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the logout payload
+func (p *EmployeeLogoutPayload) Validate() error {
+	if err := p.BasePayload.Validate(); err != nil {
+		return err
+	}
+	if p.EmployeeID == "" {
+		return &ValidationError{Field: "employee_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the customer identification payload
+func (p *CustomerIdentifyPayload) Validate() error {
+	if err := p.BasketPayload.Validate(); err != nil {
+		return err
+	}
+	if p.CustomerID == "" {
+		return &ValidationError{Field: "customer_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the add item payload
+func (p *AddItemPayload) Validate() error {
+	if err := p.BasketPayload.Validate(); err != nil {
+		return err
+	}
+	if p.ItemID == "" {
+		return &ValidationError{Field: "item_id", Reason: "is required"}
+	}
+	if p.Price.Amount < 0 {
+		return &ValidationError{Field: "price", Reason: "must not be negative"}
+	}
+	if p.Price.Currency == "" {
+		return &ValidationError{Field: "price.currency", Reason: "is required"}
+	}
+	if p.Quantity <= 0 {
+		return &ValidationError{Field: "quantity", Reason: "must be positive"}
+	}
+	return nil
+}
+
+// Validate checks the payment payload
+func (p *PaymentCompletePayload) Validate() error {
+	if err := p.BasketPayload.Validate(); err != nil {
+		return err
+	}
+	if p.PaymentMethod == "" {
+		return &ValidationError{Field: "payment_method", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the customer data payload
+func (p *CustomerDataPayload) Validate() error {
+	if err := p.BasePayload.Validate(); err != nil {
+		return err
+	}
+	if p.CustomerID == "" {
+		return &ValidationError{Field: "customer_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Validate checks the recommendations payload
+func (p *PurchaseRecommendationsPayload) Validate() error {
+	if err := p.BasePayload.Validate(); err != nil {
+		return err
+	}
+	if p.SourceItemID == "" {
+		return &ValidationError{Field: "source_item_id", Reason: "is required"}
+	}
+	return nil
+}
+
+// Scope identifies the store, terminal and basket an event belongs to
+type Scope struct {
+	StoreID    string
+	TerminalID string
+	BasketID   string
+}
+
+// Scoped is implemented by payloads that belong to a store and terminal
+type Scoped interface {
+	Scope() Scope
+}
+
+// Scope returns the store and terminal of the payload
+func (p *BasePayload) Scope() Scope {
+	return Scope{StoreID: p.StoreID, TerminalID: p.TerminalID}
+}
+
+// Scope returns the store, terminal and basket of the payload
+func (p *BasketPayload) Scope() Scope {
+	scope := p.BasePayload.Scope()
+	scope.BasketID = p.BasketID
+	return scope
+}
+
+// Scope returns the store, terminal and basket of the payload
+func (p *CustomerDataPayload) Scope() Scope {
+	scope := p.BasePayload.Scope()
+	scope.BasketID = p.BasketID
+	return scope
+}
+
+// Scope returns the store, terminal and basket of the payload
+func (p *PurchaseRecommendationsPayload) Scope() Scope {
+	scope := p.BasePayload.Scope()
+	scope.BasketID = p.BasketID
+	return scope
+}
+
+// Scope returns the store, terminal and basket the event belongs to
+func (e *Event) Scope() Scope {
+	if p, ok := e.Payload.(Scoped); ok {
+		return p.Scope()
+	}
+
+	// Untyped payloads built in-process
+	m, _ := e.Payload.(map[string]interface{})
+	str := func(key string) string {
+		v, _ := m[key].(string)
+		return v
+	}
+	return Scope{
+		StoreID:    str("store_id"),
+		TerminalID: str("terminal_id"),
+		BasketID:   str("basket_id"),
+	}
+}
+
+// payloadRegistry maps event types to their payload constructors
+var (
+	payloadMu       sync.RWMutex
+	payloadRegistry = map[EventType]func() EventPayload{
+		EventEmployeeLogin:           func() EventPayload { return &EmployeeLoginPayload{} },
+		EventEmployeeLogout:          func() EventPayload { return &EmployeeLogoutPayload{} },
+		EventStartBasket:             func() EventPayload { return &StartBasketPayload{} },
+		EventCustomerIdentify:        func() EventPayload { return &CustomerIdentifyPayload{} },
+		EventAddItem:                 func() EventPayload { return &AddItemPayload{} },
+		EventFinalizeSubtotal:        func() EventPayload { return &FinalizeSubtotalPayload{} },
+		EventPaymentComplete:         func() EventPayload { return &PaymentCompletePayload{} },
+		EventCustomerData:            func() EventPayload { return &CustomerDataPayload{} },
+		EventPurchaseRecommendations: func() EventPayload { return &PurchaseRecommendationsPayload{} },
+	}
+)
+
+// RegisterPayload registers the payload constructor for an event type
+func RegisterPayload(eventType EventType, factory func() EventPayload) {
+	payloadMu.Lock()
+	defer payloadMu.Unlock()
+	payloadRegistry[eventType] = factory
+}
+
+// NewPayload returns an empty payload for the given event type
+func NewPayload(eventType EventType) (EventPayload, bool) {
+	payloadMu.RLock()
+	defer payloadMu.RUnlock()
+
+	factory, ok := payloadRegistry[eventType]
+	if !ok {
+		return nil, false
+	}
+	return factory(), true
+}
+
+// DecodePayload decodes and validates a raw payload for the given event type
+func DecodePayload(eventType EventType, raw json.RawMessage) (EventPayload, error) {
+	payload, ok := NewPayload(eventType)
+	if !ok {
+		return nil, &ValidationError{EventType: eventType, Reason: "unknown event type"}
+	}
+
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, &ValidationError{EventType: eventType, Field: "payload", Reason: "is required"}
+	}
+
+	if err := json.Unmarshal(raw, payload); err != nil {
+		return nil, &ValidationError{EventType: eventType, Field: "payload", Reason: err.Error()}
+	}
+
+	if err := ValidatePayload(eventType, payload); err != nil {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// DecodeEvent decodes a JSON encoded event into an event with a typed payload,
+// upcasting payloads written with an older schema version first
+func DecodeEvent(data []byte) (*Event, error) {
+	var envelope struct {
+		Event
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, &ValidationError{Reason: fmt.Sprintf("malformed envelope: %v", err)}
+	}
+
+	event := envelope.Event
+	if event.ID == "" {
+		return nil, &ValidationError{EventType: event.Type, Field: "id", Reason: "is required"}
+	}
+	if event.Type == "" {
+		return nil, &ValidationError{Field: "type", Reason: "is required"}
+	}
+
+	raw, err := UpcastPayload(event.Type, event.Version, envelope.Payload)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := DecodePayload(event.Type, raw)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = payload
+	event.Version = CurrentVersion(event.Type)
+
+	return &event, nil
+}
+
+// PayloadAs returns the event payload as the requested payload type.
+// Payloads that were not decoded through the registry, such as maps built
+// in-process, are converted and validated on the fly.
+func PayloadAs[T any](event *Event) (*T, error) {
+	switch p := event.Payload.(type) {
+	case *T:
+		return p, nil
+	case T:
+		return &p, nil
+	}
+
+	data, err := json.Marshal(event.Payload)
+	if err != nil {
+		return nil, &ValidationError{EventType: event.Type, Field: "payload", Reason: err.Error()}
+	}
+
+	payload := new(T)
+	if err := json.Unmarshal(data, payload); err != nil {
+		return nil, &ValidationError{EventType: event.Type, Field: "payload", Reason: err.Error()}
+	}
+
+	if v, ok := any(payload).(EventPayload); ok {
+		if err := ValidatePayload(event.Type, v); err != nil {
+			return nil, err
+		}
+	}
+
+	return payload, nil
+}
+
+// ValidatePayload validates a payload and tags any failure with the event type
+func ValidatePayload(eventType EventType, payload EventPayload) error {
+	if err := payload.Validate(); err != nil {
+		if verr, ok := err.(*ValidationError); ok {
+			verr.EventType = eventType
+			return verr
+		}
+		return &ValidationError{EventType: eventType, Reason: err.Error()}
+	}
+	return nil
+}
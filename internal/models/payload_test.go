@@ -0,0 +1,109 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodeEvent(t *testing.T) {
+	data := []byte(`{
+		"id": "evt-1",
+		"type": "ADD_ITEM",
+		"timestamp": "2024-01-01T10:00:00Z",
+		"payload": {
+			"terminal_id": "POS001",
+			"store_id": "STORE001",
+			"employee_id": "EMP001",
+			"basket_id": "BASKET001",
+			"item_id": "ITEM001",
+			"price": 10.99,
+			"quantity": 1
+		}
+	}`)
+
+	event, err := DecodeEvent(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "evt-1", event.ID)
+	assert.Equal(t, EventAddItem, event.Type)
+
+	payload, ok := event.Payload.(*AddItemPayload)
+	assert.True(t, ok)
+	assert.Equal(t, "POS001", payload.TerminalID)
+	assert.Equal(t, "BASKET001", payload.BasketID)
+	assert.Equal(t, "ITEM001", payload.ItemID)
+	assert.Equal(t, 1, payload.Quantity)
+
+	// Unversioned ADD_ITEM events carry float prices and are upcast to Money
+	assert.Equal(t, 2, event.Version)
+	assert.Equal(t, NewMoney(1099, DefaultCurrency), payload.Price)
+}
+
+func TestDecodeEventMissingField(t *testing.T) {
+	data := []byte(`{
+		"id": "evt-1",
+		"type": "START_BASKET",
+		"payload": {
+			"terminal_id": "POS001",
+			"store_id": "STORE001",
+			"employee_id": "EMP001",
+			"basket_id": ""
+		}
+	}`)
+
+	event, err := DecodeEvent(data)
+	assert.Nil(t, event)
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, EventStartBasket, verr.EventType)
+	assert.Equal(t, "basket_id", verr.Field)
+}
+
+func TestDecodeEventUnknownType(t *testing.T) {
+	event, err := DecodeEvent([]byte(`{"id": "evt-1", "type": "UNKNOWN", "payload": {}}`))
+	assert.Nil(t, event)
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "unknown event type", verr.Reason)
+}
+
+func TestDecodeEventMalformed(t *testing.T) {
+	event, err := DecodeEvent([]byte(`{"id": `))
+	assert.Nil(t, event)
+
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+}
+
+func TestPayloadAs(t *testing.T) {
+	// Typed payloads are returned as is
+	typed := &EmployeeLoginPayload{
+		BasePayload: BasePayload{TerminalID: "POS001", StoreID: "STORE001"},
+		EmployeeID:  "EMP001",
+	}
+	payload, err := PayloadAs[EmployeeLoginPayload](&Event{Type: EventEmployeeLogin, Payload: typed})
+	assert.NoError(t, err)
+	assert.Same(t, typed, payload)
+
+	// Map payloads are converted and validated
+	payload, err = PayloadAs[EmployeeLoginPayload](&Event{
+		Type: EventEmployeeLogin,
+		Payload: map[string]interface{}{
+			"terminal_id": "POS001",
+			"store_id":    "STORE001",
+			"employee_id": "EMP001",
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "EMP001", payload.EmployeeID)
+
+	_, err = PayloadAs[EmployeeLoginPayload](&Event{
+		Type:    EventEmployeeLogin,
+		Payload: map[string]interface{}{"terminal_id": "POS001", "store_id": "STORE001"},
+	})
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "employee_id", verr.Field)
+}
@@ -20,4 +20,9 @@ type PluginStats struct {
 	EventsProcessed int        `json:"eventsProcessed"`
 	LastProcessed   *time.Time `json:"lastProcessed,omitempty"`
 	ErrorCount      int        `json:"errorCount"`
+	// RetryCount counts the events the plugin processed again after a failure
+	RetryCount int `json:"retryCount"`
+	// DuplicateCount counts the redelivered events the plugin skipped because
+	// it already processed them
+	DuplicateCount int `json:"duplicateCount"`
 }
@@ -95,8 +95,73 @@ CREATE TABLE IF NOT EXISTS item_recommendations (
     UNIQUE(source_item_id, recommended_item_id)
 );
 
+-- Messages of the postgres event queue transport
+CREATE TABLE IF NOT EXISTS event_queue (
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    message_offset BIGINT NOT NULL,
+    key BYTEA,
+    value BYTEA,
+    headers JSONB DEFAULT '[]'::jsonb,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (topic, partition, message_offset)
+);
+
+-- Next offset of each event queue partition
+CREATE TABLE IF NOT EXISTS event_queue_partitions (
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    next_offset BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (topic, partition)
+);
+
+-- Delivery state of queued messages per consumer group
+CREATE TABLE IF NOT EXISTS event_queue_deliveries (
+    group_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    message_offset BIGINT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    locked_until TIMESTAMP WITH TIME ZONE,
+    acked_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (group_id, topic, partition, message_offset),
+    FOREIGN KEY (topic, partition, message_offset)
+        REFERENCES event_queue (topic, partition, message_offset) ON DELETE CASCADE
+);
+
+-- Members of the consumer groups of the event queue
+CREATE TABLE IF NOT EXISTS event_queue_members (
+    group_id VARCHAR(255) NOT NULL,
+    member_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (group_id, member_id)
+);
+
+-- Event queue partitions leased by a consumer group member
+CREATE TABLE IF NOT EXISTS event_queue_leases (
+    group_id VARCHAR(255) NOT NULL,
+    topic VARCHAR(255) NOT NULL,
+    partition INTEGER NOT NULL,
+    member_id VARCHAR(255) NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (group_id, topic, partition)
+);
+
+-- Events each plugin claimed or processed, to skip redelivered events.
+-- processed_at is null while the delivery in claimed_by holds the claim.
+CREATE TABLE IF NOT EXISTS processed_events (
+    plugin VARCHAR(100) NOT NULL,
+    event_id VARCHAR(100) NOT NULL,
+    claimed_by VARCHAR(255) NOT NULL DEFAULT '',
+    claimed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP WITH TIME ZONE,
+    PRIMARY KEY (plugin, event_id)
+);
+
 -- Create indexes
 CREATE INDEX IF NOT EXISTS idx_employee_sessions_employee_id ON employee_sessions(employee_id);
 CREATE INDEX IF NOT EXISTS idx_basket_items_basket_id ON basket_items(basket_id);
 CREATE INDEX IF NOT EXISTS idx_fraud_alerts_basket_id ON fraud_alerts(basket_id);
 CREATE INDEX IF NOT EXISTS idx_item_recommendations_source_item ON item_recommendations(source_item_id); 
+CREATE INDEX IF NOT EXISTS idx_event_queue_created_at ON event_queue(created_at);
+CREATE INDEX IF NOT EXISTS idx_processed_events_processed_at ON processed_events(processed_at);
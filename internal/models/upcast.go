@@ -0,0 +1,126 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Upcaster transforms a payload from one schema version into the next one
+type Upcaster func(payload map[string]any) (map[string]any, error)
+
+// Events written before envelopes were versioned are treated as version 1
+const legacyVersion = 1
+
+var (
+	upcastMu sync.RWMutex
+	// upcasters maps an event type and source version to its upcaster
+	upcasters = make(map[EventType]map[int]Upcaster)
+	// payloadVersions holds the current schema version of each event type
+	payloadVersions = make(map[EventType]int)
+)
+
+// RegisterUpcaster registers an upcaster that transforms payloads of the given
+// event type from version `from` to version `from+1`. The current version of
+// the event type is raised accordingly.
+func RegisterUpcaster(eventType EventType, from int, upcaster Upcaster) {
+	upcastMu.Lock()
+	defer upcastMu.Unlock()
+
+	if upcasters[eventType] == nil {
+		upcasters[eventType] = make(map[int]Upcaster)
+	}
+	upcasters[eventType][from] = upcaster
+
+	if payloadVersions[eventType] < from+1 {
+		payloadVersions[eventType] = from + 1
+	}
+}
+
+// CurrentVersion returns the payload schema version expected by plugins
+func CurrentVersion(eventType EventType) int {
+	upcastMu.RLock()
+	defer upcastMu.RUnlock()
+
+	if v, ok := payloadVersions[eventType]; ok {
+		return v
+	}
+	return legacyVersion
+}
+
+// IsCurrentVersion reports whether a payload of the given version is already
+// in the current shape of its event type and needs no upcasting
+func IsCurrentVersion(eventType EventType, version int) bool {
+	if version == 0 {
+		version = legacyVersion
+	}
+	return version == CurrentVersion(eventType)
+}
+
+// UpcastPayload runs the upcaster chain to bring a raw payload of the given
+// version up to the current version of its event type
+func UpcastPayload(eventType EventType, version int, raw json.RawMessage) (json.RawMessage, error) {
+	if version == 0 {
+		version = legacyVersion
+	}
+
+	current := CurrentVersion(eventType)
+	if version > current {
+		return nil, &ValidationError{
+			EventType: eventType,
+			Field:     "version",
+			Reason:    fmt.Sprintf("unsupported version %d, latest is %d", version, current),
+		}
+	}
+	if version == current {
+		return raw, nil
+	}
+
+	var payload map[string]any
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, &ValidationError{EventType: eventType, Field: "payload", Reason: err.Error()}
+	}
+
+	upcastMu.RLock()
+	chain := upcasters[eventType]
+	upcastMu.RUnlock()
+
+	for v := version; v < current; v++ {
+		upcaster, ok := chain[v]
+		if !ok {
+			return nil, &ValidationError{
+				EventType: eventType,
+				Field:     "version",
+				Reason:    fmt.Sprintf("no upcaster from version %d", v),
+			}
+		}
+
+		var err error
+		if payload, err = upcaster(payload); err != nil {
+			return nil, &ValidationError{
+				EventType: eventType,
+				Field:     "payload",
+				Reason:    fmt.Sprintf("upcasting from version %d failed: %v", v, err),
+			}
+		}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal upcasted payload: %v", err)
+	}
+	return data, nil
+}
+
+func init() {
+	// ADD_ITEM v2 replaced the float price with integer minor units
+	RegisterUpcaster(EventAddItem, 1, func(p map[string]any) (map[string]any, error) {
+		price, ok := p["price"].(float64)
+		if !ok {
+			return nil, fmt.Errorf("price must be a number, got %T", p["price"])
+		}
+		money := MoneyFromFloat(price, DefaultCurrency)
+		p["price"] = map[string]any{"amount": money.Amount, "currency": money.Currency}
+		return p, nil
+	})
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpcastPayload(t *testing.T) {
+	const eventType EventType = "TEST_UPCAST"
+	RegisterPayload(eventType, func() EventPayload { return &EmployeeLoginPayload{} })
+
+	// v1 used "employee" instead of "employee_id"
+	RegisterUpcaster(eventType, 1, func(p map[string]any) (map[string]any, error) {
+		p["employee_id"] = p["employee"]
+		delete(p, "employee")
+		return p, nil
+	})
+	// v2 stored the store as a number
+	RegisterUpcaster(eventType, 2, func(p map[string]any) (map[string]any, error) {
+		if id, ok := p["store_id"].(float64); ok {
+			p["store_id"] = fmt.Sprintf("STORE%03d", int(id))
+		}
+		return p, nil
+	})
+	assert.Equal(t, 3, CurrentVersion(eventType))
+
+	raw, err := UpcastPayload(eventType, 1, json.RawMessage(`{"terminal_id":"POS001","store_id":1,"employee":"EMP001"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"terminal_id":"POS001","store_id":"STORE001","employee_id":"EMP001"}`, string(raw))
+
+	// Unversioned events are treated as version 1
+	event, err := DecodeEvent([]byte(`{"id":"evt-1","type":"TEST_UPCAST","payload":{"terminal_id":"POS001","store_id":1,"employee":"EMP001"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, event.Version)
+	assert.Equal(t, "STORE001", event.Payload.(*EmployeeLoginPayload).StoreID)
+	assert.Equal(t, "EMP001", event.Payload.(*EmployeeLoginPayload).EmployeeID)
+
+	// Current payloads pass through untouched
+	raw, err = UpcastPayload(eventType, 3, json.RawMessage(`{"employee_id":"EMP001"}`))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"employee_id":"EMP001"}`, string(raw))
+
+	// Versions from the future are rejected
+	_, err = UpcastPayload(eventType, 4, json.RawMessage(`{}`))
+	var verr *ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "version", verr.Field)
+}
@@ -0,0 +1,45 @@
+package plugins
+
+import (
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/env"
+)
+
+// Config holds the configuration of the plugin dispatch pipeline
+type Config struct {
+	// Timeout bounds how long a plugin may take to process an event,
+	// including the events it derives, zero disables it
+	Timeout time.Duration
+	// Dedup configures skipping redelivered events the plugins already processed
+	Dedup DedupConfig
+}
+
+// DedupConfig holds the configuration of the event deduplication store
+type DedupConfig struct {
+	// Enabled skips events a plugin already processed
+	Enabled bool
+	// TTL is how long processed events are remembered, it should cover the
+	// longest time an event may be redelivered after
+	TTL time.Duration
+	// ClaimTimeout is how long an event claimed by a delivery that neither
+	// recorded nor released it is held back from other messages carrying
+	// it. Redeliveries of the claiming message take the claim over right
+	// away, for example after a crash.
+	ClaimTimeout time.Duration
+	// PurgeInterval is how often expired events are deleted
+	PurgeInterval time.Duration
+}
+
+// NewDefaultConfig returns a default configuration
+func NewDefaultConfig() *Config {
+	return &Config{
+		Timeout: env.Duration("PLUGIN_TIMEOUT", time.Second*30),
+		Dedup: DedupConfig{
+			Enabled:       env.Bool("DEDUP_ENABLED", true),
+			TTL:           env.Duration("DEDUP_TTL", time.Hour*24),
+			ClaimTimeout:  env.Duration("DEDUP_CLAIM_TIMEOUT", time.Minute*5),
+			PurgeInterval: env.Duration("DEDUP_PURGE_INTERVAL", time.Minute*10),
+		},
+	}
+}
@@ -0,0 +1,29 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewDefaultConfig(t *testing.T) {
+	cfg := NewDefaultConfig()
+	assert.Equal(t, 30*time.Second, cfg.Timeout)
+	assert.Equal(t, DedupConfig{
+		Enabled:       true,
+		TTL:           24 * time.Hour,
+		ClaimTimeout:  5 * time.Minute,
+		PurgeInterval: 10 * time.Minute,
+	}, cfg.Dedup)
+
+	t.Setenv("PLUGIN_TIMEOUT", "0")
+	t.Setenv("DEDUP_ENABLED", "false")
+	t.Setenv("DEDUP_TTL", "2h")
+	t.Setenv("DEDUP_PURGE_INTERVAL", "invalid")
+	cfg = NewDefaultConfig()
+	assert.Zero(t, cfg.Timeout)
+	assert.False(t, cfg.Dedup.Enabled)
+	assert.Equal(t, 2*time.Hour, cfg.Dedup.TTL)
+	assert.Equal(t, 10*time.Minute, cfg.Dedup.PurgeInterval)
+}
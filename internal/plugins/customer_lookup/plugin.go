@@ -52,6 +52,11 @@ func (p *Plugin) Configure(config map[string]interface{}) error {
 	return nil
 }
 
+// EventTypes returns the event types the plugin subscribes to
+func (p *Plugin) EventTypes() []models.EventType {
+	return []models.EventType{models.EventCustomerIdentify}
+}
+
 // ProcessEvent handles customer identification events
 func (p *Plugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*models.Event, error) {
 	if !p.active {
@@ -66,20 +71,9 @@ func (p *Plugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*mode
 }
 
 func (p *Plugin) handleCustomerIdentified(ctx context.Context, event *models.Event) ([]*models.Event, error) {
-	var payload struct {
-		CustomerID string `json:"customer_id"`
-		BasketID   string `json:"basket_id"`
-		TerminalID string `json:"terminal_id"`
-		StoreID    string `json:"store_id"`
-	}
-
-	data, err := json.Marshal(event.Payload)
+	payload, err := models.PayloadAs[models.CustomerIdentifyPayload](event)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %v", err)
-	}
-
-	if err := json.Unmarshal(data, &payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %v", err)
+		return nil, err
 	}
 
 	// Fetch customer data
@@ -89,20 +83,19 @@ func (p *Plugin) handleCustomerIdentified(ctx context.Context, event *models.Eve
 	}
 
 	// Update last seen timestamp
-	if err := p.updateLastSeen(ctx, payload.CustomerID); err != nil {
+	if err := p.updateLastSeen(ctx, payload.CustomerID, event.Timestamp); err != nil {
 		return nil, fmt.Errorf("failed to update last seen: %v", err)
 	}
 
 	// Create customer data event
 	customerEvent := &models.Event{
-		Type:      "CUSTOMER_DATA",
-		Timestamp: time.Now(),
-		Payload: map[string]interface{}{
-			"customer_id": payload.CustomerID,
-			"basket_id":   payload.BasketID,
-			"terminal_id": payload.TerminalID,
-			"store_id":    payload.StoreID,
-			"data":        customerData,
+		Type:      models.EventCustomerData,
+		Timestamp: event.Timestamp,
+		Payload: &models.CustomerDataPayload{
+			BasePayload: payload.BasePayload,
+			BasketID:    payload.BasketID,
+			CustomerID:  payload.CustomerID,
+			Data:        customerData,
 		},
 	}
 
@@ -145,12 +138,13 @@ func (p *Plugin) getCustomerData(ctx context.Context, customerID string) (map[st
 	return result, nil
 }
 
-func (p *Plugin) updateLastSeen(ctx context.Context, customerID string) error {
+// updateLastSeen records when the customer was seen at the terminal
+func (p *Plugin) updateLastSeen(ctx context.Context, customerID string, seenAt time.Time) error {
 	_, err := p.db.Pool().Exec(ctx, `
 		UPDATE customers
-		SET last_seen = CURRENT_TIMESTAMP
+		SET last_seen = $2
 		WHERE customer_id = $1
-	`, customerID)
+	`, customerID, seenAt)
 	return err
 }
 
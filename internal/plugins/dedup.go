@@ -0,0 +1,153 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
+	"github.com/jackc/pgx/v4"
+)
+
+// ClaimStatus is the outcome of claiming an event for a plugin
+type ClaimStatus int
+
+const (
+	// ClaimAcquired means the delivery holds the claim and processes the event
+	ClaimAcquired ClaimStatus = iota
+	// ClaimProcessed means the plugin already processed the event
+	ClaimProcessed
+	// ClaimInFlight means another delivery holds the claim and did not
+	// record the event yet, it may still fail or never finish
+	ClaimInFlight
+)
+
+// DedupStore remembers which events each plugin processed, so events
+// redelivered by the consumer are not processed twice
+type DedupStore interface {
+	// Claim reserves the event for the plugin on behalf of the delivery,
+	// unless the plugin already processed it or another delivery of the
+	// event holds the claim. A claim left by an earlier delivery of the same
+	// message is taken over, that delivery was abandoned.
+	Claim(ctx context.Context, plugin, eventID, delivery string) (ClaimStatus, error)
+	// Record remembers that the plugin processed the claimed event
+	Record(ctx context.Context, plugin, eventID string) error
+	// Release gives up the claim of the delivery, so the event is processed
+	// when redelivered
+	Release(ctx context.Context, plugin, eventID, delivery string) error
+}
+
+// PostgresDedupStore keeps the processed events in the processed_events table
+type PostgresDedupStore struct {
+	db           *database.Connection
+	ttl          time.Duration
+	claimTimeout time.Duration
+}
+
+// NewPostgresDedupStore creates a dedup store remembering events for the
+// configured TTL
+func NewPostgresDedupStore(db *database.Connection, cfg DedupConfig) *PostgresDedupStore {
+	return &PostgresDedupStore{db: db, ttl: cfg.TTL, claimTimeout: cfg.ClaimTimeout}
+}
+
+// Claim inserts the event as claimed by the plugin. A single statement
+// decides between concurrent deliveries, only one of them inserts the row.
+// Records older than the TTL, claims older than the claim timeout and
+// claims of an earlier delivery of the same message are taken over. An empty
+// delivery, outside a consumer, never matches another one. Otherwise the existing row tells whether the event was
+// processed or is still claimed.
+func (s *PostgresDedupStore) Claim(ctx context.Context, plugin, eventID, delivery string) (ClaimStatus, error) {
+	tag, err := s.db.Pool().Exec(ctx, `
+		INSERT INTO processed_events (plugin, event_id, claimed_by, claimed_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (plugin, event_id) DO UPDATE
+		SET claimed_by = $3, claimed_at = now(), processed_at = NULL
+		WHERE processed_events.processed_at < now() - make_interval(secs => $4)
+			OR (processed_events.processed_at IS NULL
+				AND (processed_events.claimed_at < now() - make_interval(secs => $5)
+					OR ($3 <> '' AND processed_events.claimed_by = $3)))
+	`, plugin, eventID, delivery, s.ttl.Seconds(), s.claimTimeout.Seconds())
+	if err != nil {
+		return ClaimInFlight, fmt.Errorf("failed to claim event: %v", err)
+	}
+	if tag.RowsAffected() == 1 {
+		return ClaimAcquired, nil
+	}
+
+	var processed bool
+	err = s.db.Pool().QueryRow(ctx, `
+		SELECT processed_at IS NOT NULL FROM processed_events
+		WHERE plugin = $1 AND event_id = $2
+	`, plugin, eventID).Scan(&processed)
+	switch {
+	case errors.Is(err, pgx.ErrNoRows):
+		// The claim was released in between, the redelivery claims it
+		return ClaimInFlight, nil
+	case err != nil:
+		return ClaimInFlight, fmt.Errorf("failed to read claimed event: %v", err)
+	case processed:
+		return ClaimProcessed, nil
+	default:
+		return ClaimInFlight, nil
+	}
+}
+
+// Record marks the event as processed by the plugin, starting its TTL
+func (s *PostgresDedupStore) Record(ctx context.Context, plugin, eventID string) error {
+	_, err := s.db.Pool().Exec(ctx, `
+		INSERT INTO processed_events (plugin, event_id, claimed_at, processed_at)
+		VALUES ($1, $2, now(), now())
+		ON CONFLICT (plugin, event_id)
+		DO UPDATE SET processed_at = now()
+	`, plugin, eventID)
+	if err != nil {
+		return fmt.Errorf("failed to record processed event: %v", err)
+	}
+	return nil
+}
+
+// Release deletes the claim of an event the plugin did not process, unless
+// another delivery took the claim over
+func (s *PostgresDedupStore) Release(ctx context.Context, plugin, eventID, delivery string) error {
+	_, err := s.db.Pool().Exec(ctx, `
+		DELETE FROM processed_events
+		WHERE plugin = $1 AND event_id = $2 AND claimed_by = $3 AND processed_at IS NULL
+	`, plugin, eventID, delivery)
+	if err != nil {
+		return fmt.Errorf("failed to release event: %v", err)
+	}
+	return nil
+}
+
+// Purge deletes the events processed before the TTL and the abandoned
+// claims, and returns their number
+func (s *PostgresDedupStore) Purge(ctx context.Context) (int64, error) {
+	tag, err := s.db.Pool().Exec(ctx, `
+		DELETE FROM processed_events
+		WHERE processed_at < now() - make_interval(secs => $1)
+			OR (processed_at IS NULL AND claimed_at < now() - make_interval(secs => $2))
+	`, s.ttl.Seconds(), s.claimTimeout.Seconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge processed events: %v", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// Run purges expired events on the interval until the context is done
+func (s *PostgresDedupStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if _, err := s.Purge(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error purging processed events: %v", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
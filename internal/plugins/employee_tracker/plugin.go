@@ -2,7 +2,6 @@ package employee_tracker
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
@@ -52,6 +51,11 @@ func (p *Plugin) Configure(config map[string]interface{}) error {
 	return nil
 }
 
+// EventTypes returns the event types the plugin subscribes to
+func (p *Plugin) EventTypes() []models.EventType {
+	return []models.EventType{models.EventEmployeeLogin, models.EventEmployeeLogout}
+}
+
 // ProcessEvent handles employee login/logout events
 func (p *Plugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*models.Event, error) {
 	if !p.active {
@@ -69,19 +73,9 @@ func (p *Plugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*mode
 }
 
 func (p *Plugin) handleLogin(ctx context.Context, event *models.Event) ([]*models.Event, error) {
-	var payload struct {
-		EmployeeID string `json:"employee_id"`
-		TerminalID string `json:"terminal_id"`
-		StoreID    string `json:"store_id"`
-	}
-
-	data, err := json.Marshal(event.Payload)
+	payload, err := models.PayloadAs[models.EmployeeLoginPayload](event)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %v", err)
-	}
-
-	if err := json.Unmarshal(data, &payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %v", err)
+		return nil, err
 	}
 
 	// Check if employee is already logged in somewhere
@@ -102,12 +96,14 @@ func (p *Plugin) handleLogin(ctx context.Context, event *models.Event) ([]*model
 		autoLogout := &models.Event{
 			Type:      models.EventEmployeeLogout,
 			Timestamp: event.Timestamp,
-			Payload: map[string]interface{}{
-				"employee_id": payload.EmployeeID,
-				"terminal_id": currentTerminal,
-				"store_id":    payload.StoreID,
-				"auto_logout": true,
-				"reason":      "Login detected at different terminal",
+			Payload: &models.EmployeeLogoutPayload{
+				BasePayload: models.BasePayload{
+					TerminalID: currentTerminal,
+					StoreID:    payload.StoreID,
+				},
+				EmployeeID: payload.EmployeeID,
+				AutoLogout: true,
+				Reason:     "Login detected at different terminal",
 			},
 		}
 		events = append(events, autoLogout)
@@ -144,18 +140,9 @@ func (p *Plugin) handleLogin(ctx context.Context, event *models.Event) ([]*model
 }
 
 func (p *Plugin) handleLogout(ctx context.Context, event *models.Event) ([]*models.Event, error) {
-	var payload struct {
-		EmployeeID string `json:"employee_id"`
-		TerminalID string `json:"terminal_id"`
-	}
-
-	data, err := json.Marshal(event.Payload)
+	payload, err := models.PayloadAs[models.EmployeeLogoutPayload](event)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %v", err)
-	}
-
-	if err := json.Unmarshal(data, &payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %v", err)
+		return nil, err
 	}
 
 	batch := &pgx.Batch{}
@@ -2,12 +2,16 @@ package plugins
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"sync"
 
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
 	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+	"github.com/google/uuid"
 )
 
 // Manager handles plugin registration and event routing
@@ -16,7 +20,13 @@ type Manager struct {
 	plugins map[string]Plugin
 	// Keep track of plugin order
 	pluginOrder []string
-	mu          sync.RWMutex
+	// routes indexes the plugins subscribed to each event type, catchAll
+	// the plugins receiving every event, both in registration order
+	routes   map[models.EventType][]Plugin
+	catchAll []Plugin
+	// middleware wraps every delivery of an event to a plugin
+	middleware []Middleware
+	mu         sync.RWMutex
 }
 
 // NewManager creates a new plugin manager
@@ -25,6 +35,7 @@ func NewManager(db *database.Connection) *Manager {
 		db:          db,
 		plugins:     make(map[string]Plugin),
 		pluginOrder: make([]string, 0),
+		routes:      make(map[models.EventType][]Plugin),
 	}
 }
 
@@ -39,7 +50,17 @@ func (m *Manager) RegisterPlugin(p Plugin) error {
 
 	m.plugins[p.Name()] = p
 	m.pluginOrder = append(m.pluginOrder, p.Name())
-	log.Printf("Registered plugin: %s", p.Name())
+
+	eventTypes := EventTypes(p)
+	if eventTypes == nil {
+		m.catchAll = append(m.catchAll, p)
+		log.Printf("Registered plugin: %s", p.Name())
+		return nil
+	}
+	for _, eventType := range slices.Compact(slices.Sorted(slices.Values(eventTypes))) {
+		m.routes[eventType] = append(m.routes[eventType], p)
+	}
+	log.Printf("Registered plugin: %s for %v", p.Name(), eventTypes)
 	return nil
 }
 
@@ -64,49 +85,154 @@ func (m *Manager) ListPlugins() []Plugin {
 	return plugins
 }
 
-// HandleEvent processes an event through all active plugins
-func (m *Manager) HandleEvent(ctx context.Context, event *models.Event) error {
+// Use adds middleware to the dispatch pipeline, the first one added being
+// outermost
+func (m *Manager) Use(middleware ...Middleware) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.middleware = append(m.middleware, middleware...)
+}
+
+// Subscribers returns the plugins subscribed to an event type in
+// registration order
+func (m *Manager) Subscribers(eventType models.EventType) []Plugin {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	var wg sync.WaitGroup
-	errCh := make(chan error, len(m.plugins))
+	routed := m.routes[eventType]
+	if len(routed) == 0 {
+		return slices.Clone(m.catchAll)
+	}
+	if len(m.catchAll) == 0 {
+		return slices.Clone(routed)
+	}
 
-	for _, p := range m.plugins {
-		wg.Add(1)
-		go func(p Plugin) {
-			defer wg.Done()
+	subscribed := make(map[string]bool, len(routed)+len(m.catchAll))
+	for _, p := range routed {
+		subscribed[p.Name()] = true
+	}
+	for _, p := range m.catchAll {
+		subscribed[p.Name()] = true
+	}
+	plugins := make([]Plugin, 0, len(subscribed))
+	for _, name := range m.pluginOrder {
+		if subscribed[name] {
+			plugins = append(plugins, m.plugins[name])
+		}
+	}
+	return plugins
+}
 
-			// Process event through plugin
-			newEvents, err := p.ProcessEvent(ctx, event)
-			if err != nil {
-				errCh <- fmt.Errorf("plugin %s error: %v", p.Name(), err)
-				return
-			}
+// HandleEvent runs an event and the events derived from it through the
+// active plugins subscribed to its type, one after another in registration
+// order. Every subscriber gets to see the event, failures are joined and
+// returned so the consumer can retry or dead-letter it. When the consumer
+// retries the event, only the plugins that failed are run again.
+func (m *Manager) HandleEvent(ctx context.Context, event *models.Event) error {
+	m.mu.RLock()
+	handler := chain(m.deliver, m.middleware)
+	m.mu.RUnlock()
 
-			// Process any new events generated by the plugin
-			for _, newEvent := range newEvents {
-				if err := m.HandleEvent(ctx, newEvent); err != nil {
-					errCh <- fmt.Errorf("error processing generated event: %v", err)
-					return
-				}
-			}
-		}(p)
+	progress := eventctx.ProgressFromContext(ctx)
+	var errs []error
+	for _, p := range m.Subscribers(event.Type) {
+		if !p.IsActive() {
+			continue
+		}
+
+		key := p.Name() + "/" + event.ID
+		if progress.Done(key) {
+			continue
+		}
+		if err := handler(ctx, p, event); err != nil && !errors.Is(err, ErrDuplicate) {
+			errs = append(errs, err)
+			continue
+		}
+		progress.Complete(key)
 	}
 
-	// Wait for all plugins to finish
-	wg.Wait()
-	close(errCh)
+	if len(errs) > 0 {
+		return fmt.Errorf("plugin errors: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// deliver runs a plugin on an event and handles the events it derives,
+// publishing them when the consumer provides an emitter. On a retry, a
+// plugin that already processed the event is not run again, only the
+// handling of its derived events that failed is redone.
+func (m *Manager) deliver(ctx context.Context, p Plugin, event *models.Event) error {
+	progress := eventctx.ProgressFromContext(ctx)
+	newEvents, err := m.processOnce(ctx, progress, p, event)
+	if err != nil {
+		return err
+	}
 
-	// Collect any errors
 	var errs []error
-	for err := range errCh {
-		errs = append(errs, err)
+	for _, newEvent := range newEvents {
+		if emitter := eventctx.EmitterFromContext(ctx); emitter != nil && !progress.Done("publish/"+newEvent.ID) {
+			if err := emitter.SendEvent(ctx, newEvent); err != nil {
+				errs = append(errs, &DerivedError{EventID: newEvent.ID, Err: fmt.Errorf("failed to publish: %w", err)})
+				continue
+			}
+			progress.Complete("publish/" + newEvent.ID)
+		}
+
+		if err := m.HandleEvent(ctx, newEvent); err != nil {
+			errs = append(errs, &DerivedError{EventID: newEvent.ID, Err: err})
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("plugin errors: %v", errs)
+// processOnce runs a plugin on an event, unless it processed the event on an
+// earlier attempt, in which case the events it derived then are returned
+func (m *Manager) processOnce(ctx context.Context, progress eventctx.Progress, p Plugin, event *models.Event) ([]*models.Event, error) {
+	key := p.Name() + "/" + event.ID
+	if newEvents, ok := progress.Load(key); ok {
+		return newEvents.([]*models.Event), nil
 	}
 
-	return nil
+	newEvents, err := m.ProcessEvent(ctx, p, event)
+	if err != nil {
+		return nil, err
+	}
+	progress.Store(key, newEvents)
+	return newEvents, nil
+}
+
+// ProcessEvent runs a single plugin on an event and stamps the lineage of
+// every event it derives, so plugins never have to manage IDs themselves.
+// Errors are returned as a *PluginError naming the plugin.
+func (m *Manager) ProcessEvent(ctx context.Context, p Plugin, event *models.Event) ([]*models.Event, error) {
+	newEvents, err := p.ProcessEvent(ctx, event)
+	if err != nil {
+		return nil, &PluginError{Plugin: p.Name(), Err: err}
+	}
+
+	for _, newEvent := range newEvents {
+		derive(event, newEvent)
+	}
+	return newEvents, nil
+}
+
+// derive links a derived event to the event that caused it
+func derive(parent, child *models.Event) {
+	child.ID = uuid.New().String()
+	child.CausationID = parent.ID
+	child.CorrelationID = parent.CorrelationID
+	if child.CorrelationID == "" {
+		child.CorrelationID = parent.ID
+	}
+	if child.Version == 0 {
+		child.Version = models.CurrentVersion(child.Type)
+	}
+
+	// Derived events happen at the event time of their cause
+	if child.Timestamp.IsZero() {
+		child.Timestamp = parent.Timestamp
+	}
+	child.IngestedAt = parent.IngestedAt
+	child.ProcessedAt = parent.ProcessedAt
+	child.Late = parent.Late
 }
@@ -3,6 +3,7 @@ package plugins
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
 	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
@@ -44,17 +45,34 @@ func (m *MockPlugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*
 	return args.Get(0).([]*models.Event), args.Error(1)
 }
 
-func setupTestManager(t *testing.T) *Manager {
-	dbCfg := database.NewDefaultConfig()
-	db, err := database.New(context.Background(), dbCfg)
-	assert.NoError(t, err)
-	return NewManager(db)
+// SubscribingPlugin is a mock plugin subscribing to some event types
+type SubscribingPlugin struct {
+	MockPlugin
+	eventTypes []models.EventType
+}
+
+func (m *SubscribingPlugin) EventTypes() []models.EventType {
+	return m.eventTypes
+}
+
+func newSubscribingPlugin(name string, eventTypes ...models.EventType) *SubscribingPlugin {
+	p := &SubscribingPlugin{eventTypes: eventTypes}
+	p.On("Name").Return(name).Maybe()
+	p.On("IsActive").Return(true).Maybe()
+	return p
+}
+
+// setupTestManager returns a manager without a database, the tests only
+// register fake plugins
+func setupTestManager(_ *testing.T) *Manager {
+	return NewManager(nil)
 }
 
 func TestNewManager(t *testing.T) {
-	mgr := setupTestManager(t)
+	db := &database.Connection{}
+	mgr := NewManager(db)
 	assert.NotNil(t, mgr)
-	assert.NotNil(t, mgr.db)
+	assert.Same(t, db, mgr.db)
 	assert.NotNil(t, mgr.plugins)
 	assert.NotNil(t, mgr.pluginOrder)
 	assert.Empty(t, mgr.plugins)
@@ -234,3 +252,81 @@ func TestManagerHandleEventWithGeneratedEvents(t *testing.T) {
 
 	mockPlugin.AssertExpectations(t)
 }
+
+func TestManagerProcessEventStampsLineage(t *testing.T) {
+	mgr := setupTestManager(t)
+
+	// Create mock plugin
+	mockPlugin := new(MockPlugin)
+	mockPlugin.On("Name").Return("test_plugin").Maybe()
+
+	ctx := context.Background()
+	eventTime := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	rootEvent := &models.Event{
+		ID:         "root_event",
+		Type:       models.EventCustomerIdentify,
+		Timestamp:  eventTime,
+		IngestedAt: eventTime.Add(time.Hour),
+		Late:       true,
+	}
+	derivedEvent := &models.Event{Type: models.EventCustomerData}
+	nestedEvent := &models.Event{ID: "plugin_chosen_id", Type: models.EventPurchaseRecommendations}
+	mockPlugin.On("ProcessEvent", ctx, rootEvent).Return([]*models.Event{derivedEvent}, nil).Once()
+	mockPlugin.On("ProcessEvent", ctx, derivedEvent).Return([]*models.Event{nestedEvent}, nil).Once()
+
+	// Derived events get a fresh ID and point to their parent and root
+	newEvents, err := mgr.ProcessEvent(ctx, mockPlugin, rootEvent)
+	assert.NoError(t, err)
+	assert.Len(t, newEvents, 1)
+	assert.NotEmpty(t, derivedEvent.ID)
+	assert.Equal(t, "root_event", derivedEvent.CausationID)
+	assert.Equal(t, "root_event", derivedEvent.CorrelationID)
+	assert.Equal(t, models.CurrentVersion(models.EventCustomerData), derivedEvent.Version)
+
+	// Derived events inherit the times of their cause
+	assert.Equal(t, eventTime, derivedEvent.Timestamp)
+	assert.Equal(t, rootEvent.IngestedAt, derivedEvent.IngestedAt)
+	assert.True(t, derivedEvent.Late)
+
+	// The correlation ID is inherited down the chain
+	_, err = mgr.ProcessEvent(ctx, mockPlugin, derivedEvent)
+	assert.NoError(t, err)
+	assert.NotEqual(t, "plugin_chosen_id", nestedEvent.ID)
+	assert.Equal(t, derivedEvent.ID, nestedEvent.CausationID)
+	assert.Equal(t, "root_event", nestedEvent.CorrelationID)
+
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestManagerRoutesEventsToSubscribers(t *testing.T) {
+	mgr := setupTestManager(t)
+
+	loginPlugin := newSubscribingPlugin("login_plugin", models.EventEmployeeLogin, models.EventEmployeeLogout)
+	itemPlugin := newSubscribingPlugin("item_plugin", models.EventAddItem)
+	catchAllPlugin := new(MockPlugin)
+	catchAllPlugin.On("Name").Return("catch_all_plugin").Maybe()
+	catchAllPlugin.On("IsActive").Return(true).Maybe()
+	assert.NoError(t, mgr.RegisterPlugin(itemPlugin))
+	assert.NoError(t, mgr.RegisterPlugin(catchAllPlugin))
+	assert.NoError(t, mgr.RegisterPlugin(loginPlugin))
+
+	// Subscribers keep the registration order, plugins without
+	// subscriptions receive every event
+	assert.Equal(t, []Plugin{itemPlugin, catchAllPlugin}, mgr.Subscribers(models.EventAddItem))
+	assert.Equal(t, []Plugin{catchAllPlugin, loginPlugin}, mgr.Subscribers(models.EventEmployeeLogin))
+	assert.Equal(t, []Plugin{catchAllPlugin}, mgr.Subscribers(models.EventPaymentComplete))
+
+	assert.Equal(t, []models.EventType{models.EventAddItem}, EventTypes(itemPlugin))
+	assert.Nil(t, EventTypes(catchAllPlugin))
+
+	// Events only reach the plugins subscribed to them
+	ctx := context.Background()
+	event := &models.Event{ID: "test_event", Type: models.EventEmployeeLogin}
+	loginPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{}, nil).Once()
+	catchAllPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{}, nil).Once()
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+
+	loginPlugin.AssertExpectations(t)
+	catchAllPlugin.AssertExpectations(t)
+	itemPlugin.AssertNotCalled(t, "ProcessEvent", mock.Anything, mock.Anything)
+}
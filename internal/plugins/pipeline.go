@@ -0,0 +1,208 @@
+package plugins
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+)
+
+// Handler delivers an event to a plugin, along with the events the plugin
+// derives from it
+type Handler func(ctx context.Context, p Plugin, event *models.Event) error
+
+// Middleware wraps the delivery of events to plugins
+type Middleware func(next Handler) Handler
+
+// ErrDuplicate is returned by the dedup middleware for events the plugin
+// already processed. The pipeline does not treat it as a failure.
+var ErrDuplicate = errors.New("event already processed")
+
+// ErrClaimed is returned by the dedup middleware for events another delivery
+// claimed and did not record yet. It fails the delivery, so the event is
+// retried instead of committed in case the other delivery never finishes.
+var ErrClaimed = errors.New("event claimed by another delivery")
+
+// DerivedError records that an event derived by a plugin could not be handled
+type DerivedError struct {
+	EventID string
+	Err     error
+}
+
+func (e *DerivedError) Error() string {
+	return fmt.Sprintf("derived event %s: %v", e.EventID, e.Err)
+}
+
+func (e *DerivedError) Unwrap() error {
+	return e.Err
+}
+
+// Logging logs failed and skipped deliveries
+func Logging() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, p Plugin, event *models.Event) error {
+			err := next(ctx, p, event)
+			switch {
+			case errors.Is(err, ErrDuplicate):
+				log.Printf("Skipping event %s already processed by plugin %s", event.ID, p.Name())
+			case err != nil:
+				log.Printf("Error processing event %s in plugin %s: %v", event.ID, p.Name(), err)
+			}
+			return err
+		}
+	}
+}
+
+// Recovery turns a panicking plugin into a failed delivery, so it cannot
+// take down the consumer
+func Recovery() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, p Plugin, event *models.Event) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("Plugin %s panicked on event %s: %v\n%s", p.Name(), event.ID, r, debug.Stack())
+					err = &PluginError{Plugin: p.Name(), Err: fmt.Errorf("panic: %v", r)}
+				}
+			}()
+			return next(ctx, p, event)
+		}
+	}
+}
+
+// Timeout cancels deliveries that take longer than the timeout
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, p Plugin, event *models.Event) error {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next(ctx, p, event)
+		}
+	}
+}
+
+// Dedup skips events the plugin already processed with ErrDuplicate. The
+// event is claimed before the plugin runs, so concurrent deliveries of it
+// do not both process it, the delivery that did not get the claim fails
+// with ErrClaimed. A redelivery of the same message takes the claim over
+// instead, the earlier delivery having been abandoned, for example by a
+// crash. The claim is released if the plugin or one of its derived events
+// fails, so the event is processed again when it is retried. Otherwise the
+// event is recorded once the consumer committed the work, in transactional
+// mode after the transaction holding the derived events committed.
+func Dedup(store DedupStore) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, p Plugin, event *models.Event) error {
+			if event.ID == "" {
+				return next(ctx, p, event)
+			}
+
+			delivery := eventctx.DeliveryFromContext(ctx)
+			status, err := store.Claim(ctx, p.Name(), event.ID, delivery)
+			if err != nil {
+				return &PluginError{Plugin: p.Name(), Err: err}
+			}
+			switch status {
+			case ClaimProcessed:
+				return ErrDuplicate
+			case ClaimInFlight:
+				return &PluginError{Plugin: p.Name(), Err: ErrClaimed}
+			}
+
+			// The claim is settled even if the delivery timed out
+			settleCtx := context.WithoutCancel(ctx)
+			if err := next(ctx, p, event); err != nil {
+				release(settleCtx, store, p, event, delivery)
+				return err
+			}
+			eventctx.ProgressFromContext(ctx).AfterCommit(func(committed bool) {
+				if !committed {
+					release(settleCtx, store, p, event, delivery)
+					return
+				}
+				if err := store.Record(settleCtx, p.Name(), event.ID); err != nil {
+					log.Printf("Error recording event %s as processed by plugin %s: %v", event.ID, p.Name(), err)
+				}
+			})
+			return nil
+		}
+	}
+}
+
+// release gives up the claim of an event, logging failures as the claim
+// expires on its own
+func release(ctx context.Context, store DedupStore, p Plugin, event *models.Event, delivery string) {
+	if err := store.Release(ctx, p.Name(), event.ID, delivery); err != nil {
+		log.Printf("Error releasing event %s of plugin %s: %v", event.ID, p.Name(), err)
+	}
+}
+
+// Stats counts the events each plugin processed
+type Stats struct {
+	mu      sync.RWMutex
+	plugins map[string]*models.PluginStats
+}
+
+// NewStats creates empty plugin stats
+func NewStats() *Stats {
+	return &Stats{plugins: make(map[string]*models.PluginStats)}
+}
+
+// Get returns the stats of a plugin, false when it did not see any event yet
+func (s *Stats) Get(name string) (models.PluginStats, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	stats, ok := s.plugins[name]
+	if !ok {
+		return models.PluginStats{}, false
+	}
+	return *stats, true
+}
+
+// Middleware returns the middleware counting deliveries. Only failures of
+// the plugin itself count as errors, not failures of the events it derived.
+func (s *Stats) Middleware() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, p Plugin, event *models.Event) error {
+			err := next(ctx, p, event)
+
+			s.mu.Lock()
+			defer s.mu.Unlock()
+
+			stats, ok := s.plugins[p.Name()]
+			if !ok {
+				stats = &models.PluginStats{}
+				s.plugins[p.Name()] = stats
+			}
+			if errors.Is(err, ErrDuplicate) {
+				stats.DuplicateCount++
+				return err
+			}
+
+			stats.EventsProcessed++
+			stats.LastProcessed = &event.Timestamp
+			if eventctx.AttemptFromContext(ctx) > 1 {
+				stats.RetryCount++
+			}
+			var derived *DerivedError
+			if err != nil && !errors.As(err, &derived) {
+				stats.ErrorCount++
+			}
+			return err
+		}
+	}
+}
+
+// chain wraps a handler in the middleware, the first one being outermost
+func chain(handler Handler, middleware []Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,418 @@
+package plugins
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// setupTestPipeline returns a manager counting stats, deduplicating events
+// when a dedup store is given
+func setupTestPipeline(dedup DedupStore) (*Manager, *Stats) {
+	stats := NewStats()
+	mgr := NewManager(nil)
+	mgr.Use(Logging(), stats.Middleware())
+	if dedup != nil {
+		mgr.Use(Dedup(dedup))
+	}
+	mgr.Use(Recovery())
+	return mgr, stats
+}
+
+func newTestPlugin(active bool) *MockPlugin {
+	mockPlugin := new(MockPlugin)
+	mockPlugin.On("Name").Return("test_plugin").Maybe()
+	mockPlugin.On("Description").Return("Test plugin description").Maybe()
+	mockPlugin.On("IsActive").Return(active).Maybe()
+	return mockPlugin
+}
+
+func newTestEvent() *models.Event {
+	return &models.Event{
+		ID:        "test_event",
+		Type:      "test_type",
+		Payload:   map[string]interface{}{"key": "value"},
+		Timestamp: time.Now(),
+	}
+}
+
+func TestPipelineCountsStats(t *testing.T) {
+	mgr, stats := setupTestPipeline(nil)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	ctx := context.Background()
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{}, nil).Once()
+	mockPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{}, assert.AnError).Once()
+
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+
+	// The failing plugin is named so the event can be dead-lettered
+	err := mgr.HandleEvent(ctx, event)
+	assert.ErrorIs(t, err, assert.AnError)
+	var pluginErr *PluginError
+	if assert.ErrorAs(t, err, &pluginErr) {
+		assert.Equal(t, "test_plugin", pluginErr.Plugin)
+	}
+
+	got, ok := stats.Get("test_plugin")
+	assert.True(t, ok)
+	assert.Equal(t, 2, got.EventsProcessed)
+	assert.Equal(t, 1, got.ErrorCount)
+	assert.Equal(t, &event.Timestamp, got.LastProcessed)
+
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineCountsRetries(t *testing.T) {
+	mgr, stats := setupTestPipeline(nil)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, assert.AnError).Once()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, nil).Once()
+
+	// First delivery fails, the retry succeeds
+	ctx := context.Background()
+	assert.Error(t, mgr.HandleEvent(eventctx.ContextWithAttempt(ctx, 1), event))
+	assert.NoError(t, mgr.HandleEvent(eventctx.ContextWithAttempt(ctx, 2), event))
+
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 2, got.EventsProcessed)
+	assert.Equal(t, 1, got.ErrorCount)
+	assert.Equal(t, 1, got.RetryCount)
+
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineSkipsInactivePlugins(t *testing.T) {
+	mgr, stats := setupTestPipeline(nil)
+	mockPlugin := newTestPlugin(false)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	assert.NoError(t, mgr.HandleEvent(context.Background(), newTestEvent()))
+
+	_, ok := stats.Get("test_plugin")
+	assert.False(t, ok)
+	mockPlugin.AssertNotCalled(t, "ProcessEvent", mock.Anything, mock.Anything)
+}
+
+func TestPipelineRecoversPanics(t *testing.T) {
+	mgr, stats := setupTestPipeline(nil)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Run(func(mock.Arguments) {
+		panic("boom")
+	}).Once()
+
+	err := mgr.HandleEvent(context.Background(), event)
+	var pluginErr *PluginError
+	if assert.ErrorAs(t, err, &pluginErr) {
+		assert.Equal(t, "test_plugin", pluginErr.Plugin)
+		assert.Contains(t, pluginErr.Error(), "boom")
+	}
+
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 1, got.ErrorCount)
+}
+
+func TestPipelineTimeout(t *testing.T) {
+	mgr := NewManager(nil)
+	mgr.Use(Timeout(time.Millisecond))
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, context.DeadlineExceeded).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	}).Once()
+
+	assert.ErrorIs(t, mgr.HandleEvent(context.Background(), event), context.DeadlineExceeded)
+	mockPlugin.AssertExpectations(t)
+}
+
+// memoryDedup is a dedup store keeping the events in a map, claimed events
+// are false until they are recorded. claimedBy holds the delivery of each
+// claim.
+type memoryDedup struct {
+	processed map[string]bool
+	claimedBy map[string]string
+	err       error
+}
+
+func (d *memoryDedup) Claim(_ context.Context, plugin, eventID, delivery string) (ClaimStatus, error) {
+	if d.err != nil {
+		return ClaimInFlight, d.err
+	}
+	key := plugin + "/" + eventID
+	processed, ok := d.processed[key]
+	switch {
+	case processed:
+		return ClaimProcessed, nil
+	case ok && (delivery == "" || d.claimedBy[key] != delivery):
+		return ClaimInFlight, nil
+	}
+	if d.claimedBy == nil {
+		d.claimedBy = make(map[string]string)
+	}
+	d.processed[key] = false
+	d.claimedBy[key] = delivery
+	return ClaimAcquired, nil
+}
+
+func (d *memoryDedup) Record(_ context.Context, plugin, eventID string) error {
+	d.processed[plugin+"/"+eventID] = true
+	return nil
+}
+
+func (d *memoryDedup) Release(_ context.Context, plugin, eventID, delivery string) error {
+	key := plugin + "/" + eventID
+	if !d.processed[key] && d.claimedBy[key] == delivery {
+		delete(d.processed, key)
+	}
+	return nil
+}
+
+func TestPipelineSkipsDuplicates(t *testing.T) {
+	dedup := &memoryDedup{processed: make(map[string]bool)}
+	mgr, stats := setupTestPipeline(dedup)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, assert.AnError).Once()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, nil).Once()
+
+	// A failed event is not recorded, so its retry is processed
+	ctx := context.Background()
+	assert.Error(t, mgr.HandleEvent(ctx, event))
+	assert.False(t, dedup.processed["test_plugin/test_event"])
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+	assert.True(t, dedup.processed["test_plugin/test_event"])
+
+	// A redelivery of the processed event is skipped
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 2, got.EventsProcessed)
+	assert.Equal(t, 1, got.DuplicateCount)
+
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineDedupInTransaction(t *testing.T) {
+	dedup := &memoryDedup{processed: make(map[string]bool)}
+	mgr, stats := setupTestPipeline(dedup)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, nil).Once()
+
+	// The event stays claimed until the transaction commits, so a
+	// concurrent delivery of it fails
+	progress := kafka.NewTransactionalProgress()
+	ctx := eventctx.ContextWithProgress(context.Background(), progress)
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+	assert.ErrorIs(t, mgr.HandleEvent(context.Background(), event), ErrClaimed)
+	_, claimed := dedup.processed["test_plugin/test_event"]
+	assert.True(t, claimed)
+	assert.False(t, dedup.processed["test_plugin/test_event"])
+
+	// An aborted transaction releases the claim, the retry claims it again
+	progress.Abort()
+	_, claimed = dedup.processed["test_plugin/test_event"]
+	assert.False(t, claimed)
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+
+	progress.Commit()
+	assert.True(t, dedup.processed["test_plugin/test_event"])
+
+	// Once committed, a redelivery is skipped
+	assert.NoError(t, mgr.HandleEvent(context.Background(), event))
+
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 1, got.DuplicateCount)
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineDedupClaimedEvent(t *testing.T) {
+	dedup := &memoryDedup{processed: map[string]bool{"test_plugin/test_event": false}}
+	mgr, stats := setupTestPipeline(dedup)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	// Another delivery claimed the event and did not record it, it may have
+	// crashed, so the redelivery fails instead of being skipped
+	err := mgr.HandleEvent(context.Background(), newTestEvent())
+	assert.ErrorIs(t, err, ErrClaimed)
+	assert.NotErrorIs(t, err, ErrDuplicate)
+	var pluginErr *PluginError
+	if assert.ErrorAs(t, err, &pluginErr) {
+		assert.Equal(t, "test_plugin", pluginErr.Plugin)
+	}
+	mockPlugin.AssertNotCalled(t, "ProcessEvent", mock.Anything, mock.Anything)
+
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 0, got.DuplicateCount)
+
+	// Once the claim is released, the event is processed
+	assert.NoError(t, dedup.Release(context.Background(), "test_plugin", "test_event", ""))
+	mockPlugin.On("ProcessEvent", mock.Anything, mock.Anything).Return([]*models.Event{}, nil).Once()
+	assert.NoError(t, mgr.HandleEvent(context.Background(), newTestEvent()))
+	assert.True(t, dedup.processed["test_plugin/test_event"])
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineDedupRedeliveredClaim(t *testing.T) {
+	dedup := &memoryDedup{processed: make(map[string]bool)}
+	mgr, stats := setupTestPipeline(dedup)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	// The server crashed after claiming the event, before recording or
+	// releasing it
+	delivery := "group/pos_events/0/42"
+	status, err := dedup.Claim(context.Background(), "test_plugin", "test_event", delivery)
+	assert.NoError(t, err)
+	assert.Equal(t, ClaimAcquired, status)
+
+	// Another message carrying the event is held back by the claim
+	other := eventctx.ContextWithDelivery(context.Background(), "group/pos_events/1/7")
+	assert.ErrorIs(t, mgr.HandleEvent(other, newTestEvent()), ErrClaimed)
+
+	// The redelivered message takes the claim over on its first attempt
+	// instead of retrying until it is dead-lettered
+	mockPlugin.On("ProcessEvent", mock.Anything, mock.Anything).Return([]*models.Event{}, nil).Once()
+	ctx := eventctx.ContextWithDelivery(context.Background(), delivery)
+	assert.NoError(t, mgr.HandleEvent(ctx, newTestEvent()))
+	assert.True(t, dedup.processed["test_plugin/test_event"])
+
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 2, got.EventsProcessed)
+	assert.Equal(t, 1, got.ErrorCount)
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineDedupFailure(t *testing.T) {
+	mgr, _ := setupTestPipeline(&memoryDedup{err: assert.AnError})
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	// The plugin is not run when it cannot be told whether it saw the event
+	err := mgr.HandleEvent(context.Background(), newTestEvent())
+	assert.ErrorIs(t, err, assert.AnError)
+
+	var pluginErr *PluginError
+	if assert.ErrorAs(t, err, &pluginErr) {
+		assert.Equal(t, "test_plugin", pluginErr.Plugin)
+	}
+	mockPlugin.AssertNotCalled(t, "ProcessEvent", mock.Anything, mock.Anything)
+}
+
+// recordingEmitter collects the derived events published by the pipeline
+type recordingEmitter struct {
+	events []*models.Event
+	err    error
+}
+
+func (e *recordingEmitter) SendEvent(ctx context.Context, event *models.Event) error {
+	e.events = append(e.events, event)
+	return e.err
+}
+
+func TestPipelinePublishesDerivedEvents(t *testing.T) {
+	mgr, _ := setupTestPipeline(nil)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	emitter := &recordingEmitter{}
+	ctx := eventctx.ContextWithEmitter(context.Background(), emitter)
+	event := newTestEvent()
+	generatedEvent := &models.Event{
+		Type:    "generated_type",
+		Payload: map[string]interface{}{"generated": "value"},
+	}
+	mockPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{generatedEvent}, nil).Once()
+	mockPlugin.On("ProcessEvent", ctx, generatedEvent).Return([]*models.Event{}, nil).Once()
+
+	// Derived events are published and still processed in-process
+	assert.NoError(t, mgr.HandleEvent(ctx, event))
+	assert.Equal(t, []*models.Event{generatedEvent}, emitter.events)
+	assert.Equal(t, "test_event", generatedEvent.CausationID)
+
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineDerivedEventFailure(t *testing.T) {
+	dedup := &memoryDedup{processed: make(map[string]bool)}
+	mgr, stats := setupTestPipeline(dedup)
+	mockPlugin := newTestPlugin(true)
+	assert.NoError(t, mgr.RegisterPlugin(mockPlugin))
+
+	emitter := &recordingEmitter{err: assert.AnError}
+	ctx := eventctx.ContextWithEmitter(context.Background(), emitter)
+	event := newTestEvent()
+	mockPlugin.On("ProcessEvent", ctx, event).Return([]*models.Event{{Type: "generated_type"}}, nil).Once()
+
+	// The event fails and is not recorded, so its derived event is not lost
+	err := mgr.HandleEvent(ctx, event)
+	var derivedErr *DerivedError
+	assert.ErrorAs(t, err, &derivedErr)
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.False(t, dedup.processed["test_plugin/test_event"])
+
+	// The plugin itself did not fail
+	got, _ := stats.Get("test_plugin")
+	assert.Equal(t, 1, got.EventsProcessed)
+	assert.Equal(t, 0, got.ErrorCount)
+
+	mockPlugin.AssertExpectations(t)
+}
+
+func TestPipelineRetriesOnlyFailedPlugins(t *testing.T) {
+	mgr, stats := setupTestPipeline(nil)
+	succeeding := newSubscribingPlugin("succeeding_plugin", "test_type")
+	failing := newSubscribingPlugin("failing_plugin", "test_type")
+	assert.NoError(t, mgr.RegisterPlugin(succeeding))
+	assert.NoError(t, mgr.RegisterPlugin(failing))
+
+	emitter := &recordingEmitter{}
+	progress := kafka.NewProgress()
+	ctx := eventctx.ContextWithProgress(eventctx.ContextWithEmitter(context.Background(), emitter), progress)
+	event := newTestEvent()
+	generatedEvent := &models.Event{Type: "generated_type"}
+	succeeding.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{generatedEvent}, nil).Once()
+	failing.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, assert.AnError).Once()
+	failing.On("ProcessEvent", mock.Anything, event).Return([]*models.Event{}, nil).Once()
+
+	// The retry only runs the plugin that failed, and does not publish the
+	// derived event again
+	assert.Error(t, mgr.HandleEvent(eventctx.ContextWithAttempt(ctx, 1), event))
+	assert.NoError(t, mgr.HandleEvent(eventctx.ContextWithAttempt(ctx, 2), event))
+	assert.Equal(t, []*models.Event{generatedEvent}, emitter.events)
+
+	got, _ := stats.Get("succeeding_plugin")
+	assert.Equal(t, 0, got.RetryCount)
+	got, _ = stats.Get("failing_plugin")
+	assert.Equal(t, 1, got.RetryCount)
+
+	// An aborted transaction discarded the derived event, so it is published
+	// again without running the plugin
+	progress.Abort()
+	assert.NoError(t, mgr.HandleEvent(eventctx.ContextWithAttempt(ctx, 3), event))
+	assert.Equal(t, []*models.Event{generatedEvent, generatedEvent}, emitter.events)
+
+	succeeding.AssertExpectations(t)
+	failing.AssertExpectations(t)
+}
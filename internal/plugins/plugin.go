@@ -2,6 +2,7 @@ package plugins
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
 )
@@ -27,6 +28,41 @@ type Plugin interface {
 	ProcessEvent(ctx context.Context, event *models.Event) ([]*models.Event, error)
 }
 
+// Subscriber is implemented by plugins that only process some event types.
+// Plugins that do not implement it receive every event.
+type Subscriber interface {
+	// EventTypes returns the event types the plugin subscribes to
+	EventTypes() []models.EventType
+}
+
+// EventTypes returns the event types a plugin subscribes to, nil when it
+// receives every event
+func EventTypes(p Plugin) []models.EventType {
+	if s, ok := p.(Subscriber); ok {
+		return s.EventTypes()
+	}
+	return nil
+}
+
+// PluginError records which plugin failed to process an event
+type PluginError struct {
+	Plugin string
+	Err    error
+}
+
+func (e *PluginError) Error() string {
+	return fmt.Sprintf("plugin %s: %v", e.Plugin, e.Err)
+}
+
+func (e *PluginError) Unwrap() error {
+	return e.Err
+}
+
+// PluginName returns the name of the failed plugin
+func (e *PluginError) PluginName() string {
+	return e.Plugin
+}
+
 // BasePlugin provides a basic implementation of the Plugin interface
 type BasePlugin struct {
 	name        string
@@ -2,9 +2,7 @@ package purchase_recommender
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"time"
 
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
 	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
@@ -52,6 +50,11 @@ func (p *Plugin) Configure(config map[string]interface{}) error {
 	return nil
 }
 
+// EventTypes returns the event types the plugin subscribes to
+func (p *Plugin) EventTypes() []models.EventType {
+	return []models.EventType{models.EventAddItem}
+}
+
 // ProcessEvent handles item addition events
 func (p *Plugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*models.Event, error) {
 	if !p.active {
@@ -66,21 +69,9 @@ func (p *Plugin) ProcessEvent(ctx context.Context, event *models.Event) ([]*mode
 }
 
 func (p *Plugin) handleItemAdded(ctx context.Context, event *models.Event) ([]*models.Event, error) {
-	var payload struct {
-		BasketID   string  `json:"basket_id"`
-		ItemID     string  `json:"item_id"`
-		TerminalID string  `json:"terminal_id"`
-		StoreID    string  `json:"store_id"`
-		Price      float64 `json:"price"`
-	}
-
-	data, err := json.Marshal(event.Payload)
+	payload, err := models.PayloadAs[models.AddItemPayload](event)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal payload: %v", err)
-	}
-
-	if err := json.Unmarshal(data, &payload); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal payload: %v", err)
+		return nil, err
 	}
 
 	// Get recommendations for the added item
@@ -95,21 +86,20 @@ func (p *Plugin) handleItemAdded(ctx context.Context, event *models.Event) ([]*m
 
 	// Create recommendation event
 	recommendEvent := &models.Event{
-		Type:      "PURCHASE_RECOMMENDATIONS",
-		Timestamp: time.Now(),
-		Payload: map[string]interface{}{
-			"basket_id":       payload.BasketID,
-			"terminal_id":     payload.TerminalID,
-			"store_id":        payload.StoreID,
-			"source_item_id":  payload.ItemID,
-			"recommendations": recommendations,
+		Type:      models.EventPurchaseRecommendations,
+		Timestamp: event.Timestamp,
+		Payload: &models.PurchaseRecommendationsPayload{
+			BasePayload:     payload.BasePayload,
+			BasketID:        payload.BasketID,
+			SourceItemID:    payload.ItemID,
+			Recommendations: recommendations,
 		},
 	}
 
 	return []*models.Event{recommendEvent}, nil
 }
 
-func (p *Plugin) getRecommendations(ctx context.Context, itemID string) ([]map[string]interface{}, error) {
+func (p *Plugin) getRecommendations(ctx context.Context, itemID string) ([]models.Recommendation, error) {
 	rows, err := p.db.Pool().Query(ctx, `
 		SELECT i.item_id, i.name, i.price, r.confidence_score
 		FROM item_recommendations r
@@ -123,25 +113,14 @@ func (p *Plugin) getRecommendations(ctx context.Context, itemID string) ([]map[s
 	}
 	defer rows.Close()
 
-	var recommendations []map[string]interface{}
+	var recommendations []models.Recommendation
 	for rows.Next() {
-		var rec struct {
-			ItemID          string  `json:"item_id"`
-			Name            string  `json:"name"`
-			Price           float64 `json:"price"`
-			ConfidenceScore float64 `json:"confidence_score"`
-		}
-
+		var rec models.Recommendation
 		if err := rows.Scan(&rec.ItemID, &rec.Name, &rec.Price, &rec.ConfidenceScore); err != nil {
 			return nil, fmt.Errorf("failed to scan recommendation: %v", err)
 		}
 
-		recommendations = append(recommendations, map[string]interface{}{
-			"item_id":          rec.ItemID,
-			"name":             rec.Name,
-			"price":            rec.Price,
-			"confidence_score": rec.ConfidenceScore,
-		})
+		recommendations = append(recommendations, rec)
 	}
 
 	if err := rows.Err(); err != nil {
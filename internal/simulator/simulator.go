@@ -0,0 +1,127 @@
+// Package simulator generates realistic POS event streams for development
+package simulator
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/google/uuid"
+)
+
+// Producer sends simulated events
+type Producer interface {
+	SendEvent(ctx context.Context, event *models.Event) error
+}
+
+// Run sends simulated shopping sessions until the context is done
+func Run(ctx context.Context, producer Producer) {
+	terminals := []string{"POS001", "POS002", "POS003"}
+	employees := []string{"EMP001", "EMP002", "EMP003"}
+	customers := []string{"CUST001", "CUST002", "CUST003"}
+	items := []struct {
+		id    string
+		price models.Money
+	}{
+		{"ITEM001", models.NewMoney(1099, "USD")},
+		{"ITEM002", models.NewMoney(1599, "USD")},
+		{"ITEM003", models.NewMoney(599, "USD")},
+		{"ITEM004", models.NewMoney(2099, "USD")},
+		{"ITEM005", models.NewMoney(899, "USD")},
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			// Simulate a complete shopping session
+			terminalID := terminals[randInt(0, len(terminals))]
+			employeeID := employees[randInt(0, len(employees))]
+			customerID := customers[randInt(0, len(customers))]
+			basketID := uuid.New().String()
+
+			base := models.BasePayload{
+				TerminalID: terminalID,
+				StoreID:    "STORE001",
+			}
+			basket := models.BasketPayload{
+				BasePayload: base,
+				EmployeeID:  employeeID,
+				BasketID:    basketID,
+			}
+
+			// Employee Login
+			sendEvent(ctx, producer, models.EventEmployeeLogin, &models.EmployeeLoginPayload{
+				BasePayload: base,
+				EmployeeID:  employeeID,
+			})
+
+			// Start Basket
+			sendEvent(ctx, producer, models.EventStartBasket, &models.StartBasketPayload{
+				BasketPayload: basket,
+			})
+
+			// Customer Identification
+			sendEvent(ctx, producer, models.EventCustomerIdentify, &models.CustomerIdentifyPayload{
+				BasketPayload: basket,
+				CustomerID:    customerID,
+			})
+
+			// Add 2-4 items
+			numItems := randInt(2, 5)
+			for i := 0; i < numItems; i++ {
+				item := items[randInt(0, len(items))]
+				sendEvent(ctx, producer, models.EventAddItem, &models.AddItemPayload{
+					BasketPayload: basket,
+					ItemID:        item.id,
+					Price:         item.price,
+					Quantity:      1,
+				})
+				time.Sleep(time.Millisecond * 500) // Simulate realistic timing
+			}
+
+			// Finalize Subtotal
+			sendEvent(ctx, producer, models.EventFinalizeSubtotal, &models.FinalizeSubtotalPayload{
+				BasketPayload: basket,
+			})
+
+			// Payment Complete
+			sendEvent(ctx, producer, models.EventPaymentComplete, &models.PaymentCompletePayload{
+				BasketPayload: basket,
+				PaymentMethod: "CARD",
+			})
+
+			// Employee Logout
+			sendEvent(ctx, producer, models.EventEmployeeLogout, &models.EmployeeLogoutPayload{
+				BasePayload: base,
+				EmployeeID:  employeeID,
+			})
+
+			// Wait before starting next session
+			time.Sleep(time.Second * 5)
+		}
+	}
+}
+
+func sendEvent(ctx context.Context, producer Producer, eventType models.EventType, payload interface{}) {
+	event := &models.Event{
+		ID:        uuid.New().String(),
+		Type:      eventType,
+		Version:   models.CurrentVersion(eventType),
+		Timestamp: time.Now(),
+		Payload:   payload,
+	}
+
+	if err := producer.SendEvent(ctx, event); err != nil {
+		log.Printf("Failed to send event: %v", err)
+		return
+	}
+
+	log.Printf("Sent event: %s", eventType)
+}
+
+func randInt(min, max int) int {
+	return min + time.Now().Nanosecond()%(max-min)
+}
@@ -1,4 +1,4 @@
-package main
+package simulator
 
 import (
 	"context"
@@ -10,7 +10,7 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-// MockProducer is a mock implementation of the kafka.Producer
+// MockProducer is a mock implementation of the Producer
 type MockProducer struct {
 	mock.Mock
 }
@@ -91,7 +91,7 @@ func TestGenerateEvents(t *testing.T) {
 	})).Return(nil).Maybe()
 
 	// Run event generation
-	generateEvents(ctx, mockProducer)
+	Run(ctx, mockProducer)
 
 	// Assert expectations
 	mockProducer.AssertExpectations(t)
@@ -109,7 +109,7 @@ func TestGenerateEventsWithError(t *testing.T) {
 	mockProducer.On("SendEvent", mock.Anything, mock.Anything).Return(assert.AnError).Maybe()
 
 	// Run event generation
-	generateEvents(ctx, mockProducer)
+	Run(ctx, mockProducer)
 
 	// Assert expectations
 	mockProducer.AssertExpectations(t)
@@ -182,7 +182,7 @@ func TestGenerateEventsWithCancelledContext(t *testing.T) {
 	cancel()
 
 	// Run event generation
-	generateEvents(ctx, mockProducer)
+	Run(ctx, mockProducer)
 
 	// Assert that no events were sent
 	mockProducer.AssertNotCalled(t, "SendEvent")
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/Piyushhbhutoria/tote-assignment/internal/env"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
@@ -20,11 +21,11 @@ type Config struct {
 // NewDefaultConfig returns a default configuration
 func NewDefaultConfig() *Config {
 	return &Config{
-		Host:     getEnvOrDefault("DB_HOST", "localhost"),
+		Host:     env.String("DB_HOST", "localhost"),
 		Port:     5432,
-		User:     getEnvOrDefault("DB_USER", "pos"),
-		Password: getEnvOrDefault("DB_PASSWORD", "pos123"),
-		Database: getEnvOrDefault("DB_NAME", "pos_system"),
+		User:     env.String("DB_USER", "pos"),
+		Password: env.String("DB_PASSWORD", "pos123"),
+		Database: env.String("DB_NAME", "pos_system"),
 	}
 }
 
@@ -78,10 +79,3 @@ func (c *Connection) InitSchema(ctx context.Context) error {
 
 	return nil
 }
-
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
-	}
-	return defaultValue
-}
@@ -0,0 +1,100 @@
+// Package eventctx carries the state of the event being handled in its
+// context, so event handlers do not depend on the transport delivering it.
+package eventctx
+
+import (
+	"context"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+)
+
+// Progress records the work completed by earlier attempts of an event, so a
+// handler retrying it only redoes the work that failed
+type Progress interface {
+	// Done reports whether the work identified by key was completed
+	Done(key string) bool
+	// Complete records that the work identified by key was completed
+	Complete(key string)
+	// Load returns the result stored under key by an earlier attempt
+	Load(key string) (any, bool)
+	// Store keeps a result for the following attempts
+	Store(key string, value any)
+	// AfterCommit runs fn once the work done so far is durable, with false
+	// when it was discarded instead
+	AfterCommit(fn func(committed bool))
+}
+
+// Emitter publishes the events derived while handling an event
+type Emitter interface {
+	SendEvent(ctx context.Context, event *models.Event) error
+}
+
+type (
+	progressKey struct{}
+	emitterKey  struct{}
+	attemptKey  struct{}
+	deliveryKey struct{}
+)
+
+// ContextWithProgress returns a context carrying the progress of an event
+func ContextWithProgress(ctx context.Context, progress Progress) context.Context {
+	return context.WithValue(ctx, progressKey{}, progress)
+}
+
+// ProgressFromContext returns the progress of the event being handled.
+// Outside a consumer it returns a progress that remembers nothing and runs
+// AfterCommit functions right away.
+func ProgressFromContext(ctx context.Context) Progress {
+	if progress, ok := ctx.Value(progressKey{}).(Progress); ok && progress != nil {
+		return progress
+	}
+	return noProgress{}
+}
+
+// ContextWithEmitter returns a context carrying the emitter for derived events
+func ContextWithEmitter(ctx context.Context, emitter Emitter) context.Context {
+	return context.WithValue(ctx, emitterKey{}, emitter)
+}
+
+// EmitterFromContext returns the emitter for derived events, or nil when
+// derived events are not published
+func EmitterFromContext(ctx context.Context) Emitter {
+	emitter, _ := ctx.Value(emitterKey{}).(Emitter)
+	return emitter
+}
+
+// ContextWithAttempt returns a context carrying the handler attempt number
+func ContextWithAttempt(ctx context.Context, attempt int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, attempt)
+}
+
+// AttemptFromContext returns the attempt number of the handler call, starting
+// at 1. Handlers use it to tell retries apart from first deliveries.
+func AttemptFromContext(ctx context.Context) int {
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		return attempt
+	}
+	return 1
+}
+
+// ContextWithDelivery returns a context carrying the delivery of an event
+func ContextWithDelivery(ctx context.Context, delivery string) context.Context {
+	return context.WithValue(ctx, deliveryKey{}, delivery)
+}
+
+// DeliveryFromContext identifies the message that delivered the event to its
+// consumer group, every redelivery of the message having the same delivery.
+// Outside a consumer it returns an empty string.
+func DeliveryFromContext(ctx context.Context) string {
+	delivery, _ := ctx.Value(deliveryKey{}).(string)
+	return delivery
+}
+
+// noProgress is the progress of an event handled outside a consumer
+type noProgress struct{}
+
+func (noProgress) Done(string) bool                    { return false }
+func (noProgress) Complete(string)                     {}
+func (noProgress) Load(string) (any, bool)             { return nil, false }
+func (noProgress) Store(string, any)                   {}
+func (noProgress) AfterCommit(fn func(committed bool)) { fn(true) }
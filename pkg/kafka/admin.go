@@ -0,0 +1,247 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// TopicSpec declares how a topic is configured on the brokers
+type TopicSpec struct {
+	Name              string `json:"name"`
+	Partitions        int32  `json:"partitions"`
+	ReplicationFactor int16  `json:"replicationFactor"`
+	// RetentionMs is how long the topic keeps messages, -1 keeps them
+	// forever and zero leaves the broker default
+	RetentionMs int64 `json:"retentionMs"`
+}
+
+// TopicStatus compares a topic on the brokers with its declaration
+type TopicStatus struct {
+	Declared          TopicSpec `json:"declared"`
+	Exists            bool      `json:"exists"`
+	Partitions        int32     `json:"partitions"`
+	ReplicationFactor int16     `json:"replicationFactor"`
+	// RetentionMs is the retention set on the topic, zero when it uses the
+	// broker default
+	RetentionMs int64 `json:"retentionMs"`
+	// Drift describes every setting that differs from the declaration
+	Drift []string `json:"drift,omitempty"`
+}
+
+// TopicManager provisions the topics the service reads and writes and
+// reports how they drifted from their declarations
+type TopicManager struct {
+	admin sarama.ClusterAdmin
+	specs []TopicSpec
+	// pattern matches further topics the consumer reads, which are declared
+	// with the defaults of cfg once they exist
+	pattern *regexp.Regexp
+	cfg     *Config
+}
+
+// NewTopicManager creates a topic manager for the topics of the configuration
+func NewTopicManager(cfg *Config) (*TopicManager, error) {
+	config, err := newConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var pattern *regexp.Regexp
+	if cfg.TopicPattern != "" {
+		if pattern, err = regexp.Compile(cfg.TopicPattern); err != nil {
+			return nil, fmt.Errorf("invalid topic pattern: %v", err)
+		}
+	}
+
+	admin, err := sarama.NewClusterAdmin(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cluster admin: %v", err)
+	}
+	return &TopicManager{admin: admin, specs: declaredTopics(cfg), pattern: pattern, cfg: cfg}, nil
+}
+
+// declaredTopics returns the input, output, late and dead-letter topics of
+// the configuration ordered by name
+func declaredTopics(cfg *Config) []TopicSpec {
+	names := subscribedTopics(cfg)
+	if cfg.PublishDerived || cfg.Transactional {
+		names = append(names, cfg.OutputTopic)
+		for _, routed := range cfg.OutputRoutes {
+			names = append(names, routed...)
+		}
+	}
+	if cfg.LatePolicy == LatePolicyRoute {
+		names = append(names, cfg.LateTopic)
+	}
+	if cfg.FailurePolicy != FailurePolicyDrop {
+		names = append(names, cfg.DeadLetterTopic)
+	}
+
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	specs := make([]TopicSpec, 0, len(names))
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		specs = append(specs, topicSpec(cfg, name))
+	}
+	return specs
+}
+
+// topicSpec returns the declaration of a topic, the defaults of the
+// configuration with the settings of its KAFKA_TOPIC_SPECS entry
+func topicSpec(cfg *Config, name string) TopicSpec {
+	spec := TopicSpec{
+		Name:              name,
+		Partitions:        int32(cfg.TopicPartitions),
+		ReplicationFactor: int16(cfg.TopicReplicationFactor),
+		RetentionMs:       retentionMs(cfg.TopicRetention),
+	}
+	if override, ok := cfg.TopicSpecs[name]; ok {
+		if override.Partitions > 0 {
+			spec.Partitions = override.Partitions
+		}
+		if override.ReplicationFactor > 0 {
+			spec.ReplicationFactor = override.ReplicationFactor
+		}
+		if override.RetentionMs != 0 {
+			spec.RetentionMs = override.RetentionMs
+		}
+	}
+	return spec
+}
+
+// retentionMs converts a retention to the retention.ms topic setting
+func retentionMs(retention time.Duration) int64 {
+	if retention < 0 {
+		return -1
+	}
+	return retention.Milliseconds()
+}
+
+// EnsureTopics creates the declared topics that do not exist. Existing
+// topics are not changed, how they drifted is logged and reported by Status.
+func (m *TopicManager) EnsureTopics() error {
+	statuses, err := m.Status()
+	if err != nil {
+		return err
+	}
+
+	for _, status := range statuses {
+		if !status.Exists {
+			if err := m.create(status.Declared); err != nil {
+				return err
+			}
+			log.Printf("Created topic %s with %d partitions", status.Declared.Name, status.Declared.Partitions)
+			continue
+		}
+		if len(status.Drift) > 0 {
+			log.Printf("Topic %s differs from its declaration: %s", status.Declared.Name, strings.Join(status.Drift, ", "))
+		}
+	}
+	return nil
+}
+
+func (m *TopicManager) create(spec TopicSpec) error {
+	detail := &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+	}
+	if spec.RetentionMs != 0 {
+		retention := strconv.FormatInt(spec.RetentionMs, 10)
+		detail.ConfigEntries = map[string]*string{"retention.ms": &retention}
+	}
+
+	// Another instance may have created the topic in the meantime
+	err := m.admin.CreateTopic(spec.Name, detail, false)
+	if err != nil && !errors.Is(err, sarama.ErrTopicAlreadyExists) {
+		return fmt.Errorf("failed to create topic %s: %v", spec.Name, err)
+	}
+	return nil
+}
+
+// Status compares the declared topics with the topics on the brokers. With
+// a topic pattern, the existing topics it matches are declared as well.
+func (m *TopicManager) Status() ([]TopicStatus, error) {
+	topics, err := m.admin.ListTopics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %v", err)
+	}
+
+	specs := m.specs
+	if m.pattern != nil {
+		specs = append(slices.Clip(specs), m.matchingSpecs(topics)...)
+		slices.SortFunc(specs, func(a, b TopicSpec) int { return strings.Compare(a.Name, b.Name) })
+	}
+
+	statuses := make([]TopicStatus, 0, len(specs))
+	for _, spec := range specs {
+		detail, ok := topics[spec.Name]
+		statuses = append(statuses, compareTopic(spec, detail, ok))
+	}
+	return statuses, nil
+}
+
+// matchingSpecs declares the topics matching the pattern that are not
+// declared otherwise, skipping the ones the consumer never subscribes to
+func (m *TopicManager) matchingSpecs(topics map[string]sarama.TopicDetail) []TopicSpec {
+	excluded := forwardingTopics(m.cfg)
+	for _, spec := range m.specs {
+		excluded[spec.Name] = true
+	}
+
+	var specs []TopicSpec
+	for name := range topics {
+		if strings.HasPrefix(name, "__") || excluded[name] || !m.pattern.MatchString(name) {
+			continue
+		}
+		specs = append(specs, topicSpec(m.cfg, name))
+	}
+	return specs
+}
+
+// Close closes the connection to the brokers
+func (m *TopicManager) Close() error {
+	return m.admin.Close()
+}
+
+// compareTopic compares a topic on the brokers with its declaration
+func compareTopic(spec TopicSpec, detail sarama.TopicDetail, exists bool) TopicStatus {
+	status := TopicStatus{Declared: spec, Exists: exists}
+	if !exists {
+		status.Drift = []string{"missing"}
+		return status
+	}
+
+	status.Partitions = detail.NumPartitions
+	status.ReplicationFactor = detail.ReplicationFactor
+	// Only settings overriding the broker default are listed
+	if value := detail.ConfigEntries["retention.ms"]; value != nil {
+		status.RetentionMs, _ = strconv.ParseInt(*value, 10, 64)
+	}
+
+	if spec.Partitions > 0 && status.Partitions != spec.Partitions {
+		status.Drift = append(status.Drift, fmt.Sprintf("%d partitions instead of %d", status.Partitions, spec.Partitions))
+	}
+	if spec.ReplicationFactor > 0 && status.ReplicationFactor != spec.ReplicationFactor {
+		status.Drift = append(status.Drift, fmt.Sprintf("replication factor %d instead of %d", status.ReplicationFactor, spec.ReplicationFactor))
+	}
+	if spec.RetentionMs != 0 && status.RetentionMs != spec.RetentionMs {
+		actual := "the broker default"
+		if status.RetentionMs != 0 {
+			actual = fmt.Sprintf("%dms", status.RetentionMs)
+		}
+		status.Drift = append(status.Drift, fmt.Sprintf("retention %s instead of %dms", actual, spec.RetentionMs))
+	}
+	return status
+}
@@ -0,0 +1,135 @@
+package kafka
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAdmin is a cluster admin holding its topics in a map
+type fakeAdmin struct {
+	sarama.ClusterAdmin
+	topics  map[string]sarama.TopicDetail
+	created []string
+}
+
+func (a *fakeAdmin) ListTopics() (map[string]sarama.TopicDetail, error) {
+	return a.topics, nil
+}
+
+func (a *fakeAdmin) CreateTopic(topic string, detail *sarama.TopicDetail, _ bool) error {
+	if _, ok := a.topics[topic]; ok {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	a.topics[topic] = *detail
+	a.created = append(a.created, topic)
+	return nil
+}
+
+func topicConfig() *Config {
+	return &Config{
+		Topic:                  "pos_events",
+		OutputTopic:            "pos_derived_events",
+		OutputRoutes:           map[models.EventType][]string{models.EventCustomerData: {"pos_customers"}},
+		PublishDerived:         true,
+		LatePolicy:             LatePolicyFlag,
+		LateTopic:              "pos_events_late",
+		DeadLetterTopic:        "pos_events_dlq",
+		TopicPartitions:        3,
+		TopicReplicationFactor: 1,
+		TopicRetention:         time.Hour,
+		TopicSpecs:             map[string]TopicSpec{"pos_events_dlq": {RetentionMs: -1}},
+	}
+}
+
+func TestDeclaredTopics(t *testing.T) {
+	specs := declaredTopics(topicConfig())
+
+	assert.Equal(t, []TopicSpec{
+		{Name: "pos_customers", Partitions: 3, ReplicationFactor: 1, RetentionMs: 3600000},
+		{Name: "pos_derived_events", Partitions: 3, ReplicationFactor: 1, RetentionMs: 3600000},
+		{Name: "pos_events", Partitions: 3, ReplicationFactor: 1, RetentionMs: 3600000},
+		{Name: "pos_events_dlq", Partitions: 3, ReplicationFactor: 1, RetentionMs: -1},
+	}, specs)
+}
+
+func TestEnsureTopics(t *testing.T) {
+	retention := "3600000"
+	admin := &fakeAdmin{topics: map[string]sarama.TopicDetail{
+		"pos_events": {NumPartitions: 1, ReplicationFactor: 1, ConfigEntries: map[string]*string{"retention.ms": &retention}},
+	}}
+	m := &TopicManager{admin: admin, specs: declaredTopics(topicConfig())}
+
+	assert.NoError(t, m.EnsureTopics())
+	assert.Equal(t, []string{"pos_customers", "pos_derived_events", "pos_events_dlq"}, admin.created)
+	assert.Equal(t, int32(3), admin.topics["pos_events_dlq"].NumPartitions)
+	assert.Equal(t, "-1", *admin.topics["pos_events_dlq"].ConfigEntries["retention.ms"])
+
+	// Existing topics are left as they are and reported as drifted
+	assert.Equal(t, int32(1), admin.topics["pos_events"].NumPartitions)
+	statuses, err := m.Status()
+	assert.NoError(t, err)
+	for _, status := range statuses {
+		if status.Declared.Name == "pos_events" {
+			assert.True(t, status.Exists)
+			assert.Equal(t, []string{"1 partitions instead of 3"}, status.Drift)
+		} else {
+			assert.Empty(t, status.Drift, status.Declared.Name)
+		}
+	}
+
+	// A topic created by another instance in the meantime is not an error
+	assert.NoError(t, m.create(TopicSpec{Name: "pos_events", Partitions: 3}))
+}
+
+func TestTopicStatusMatchesPattern(t *testing.T) {
+	cfg := topicConfig()
+	cfg.TopicPattern = "^pos_"
+	admin := &fakeAdmin{topics: map[string]sarama.TopicDetail{
+		"pos_events":         {NumPartitions: 3, ReplicationFactor: 1},
+		"pos_events_eu":      {NumPartitions: 6, ReplicationFactor: 1},
+		"pos_events_dlq":     {NumPartitions: 3, ReplicationFactor: 1},
+		"inventory":          {NumPartitions: 3, ReplicationFactor: 1},
+		"__consumer_offsets": {NumPartitions: 50, ReplicationFactor: 1},
+	}}
+	m := &TopicManager{admin: admin, specs: declaredTopics(cfg), pattern: regexp.MustCompile(cfg.TopicPattern), cfg: cfg}
+
+	// Matching topics are reported with the default declaration, topics
+	// declared otherwise only once
+	statuses, err := m.Status()
+	assert.NoError(t, err)
+	var names []string
+	for _, status := range statuses {
+		names = append(names, status.Declared.Name)
+	}
+	assert.Equal(t, []string{"pos_customers", "pos_derived_events", "pos_events", "pos_events_dlq", "pos_events_eu"}, names)
+	assert.Contains(t, statuses[4].Drift, "6 partitions instead of 3")
+}
+
+func TestCompareTopic(t *testing.T) {
+	spec := TopicSpec{Name: "pos_events", Partitions: 3, ReplicationFactor: 3, RetentionMs: 3600000}
+
+	status := compareTopic(spec, sarama.TopicDetail{}, false)
+	assert.False(t, status.Exists)
+	assert.Equal(t, []string{"missing"}, status.Drift)
+
+	status = compareTopic(spec, sarama.TopicDetail{NumPartitions: 3, ReplicationFactor: 1}, true)
+	assert.Equal(t, []string{
+		"replication factor 1 instead of 3",
+		"retention the broker default instead of 3600000ms",
+	}, status.Drift)
+}
+
+func TestParseTopicSpecs(t *testing.T) {
+	specs := parseTopicSpecs("pos_events=6:3:168h, pos_events_dlq=::-1ms,pos_late=2,bad=x,=1")
+
+	assert.Equal(t, map[string]TopicSpec{
+		"pos_events":     {Name: "pos_events", Partitions: 6, ReplicationFactor: 3, RetentionMs: 604800000},
+		"pos_events_dlq": {Name: "pos_events_dlq", RetentionMs: -1},
+		"pos_late":       {Name: "pos_late", Partitions: 2},
+	}, specs)
+}
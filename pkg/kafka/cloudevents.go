@@ -0,0 +1,252 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+)
+
+// Envelope modes supported by the producer
+const (
+	EnvelopeNative                = "native"
+	EnvelopeCloudEventsBinary     = "cloudevents-binary"
+	EnvelopeCloudEventsStructured = "cloudevents-structured"
+)
+
+// CloudEvents constants from the Kafka protocol binding
+const (
+	CloudEventsSpecVersion     = "1.0"
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+	cloudEventsHeaderPrefix    = "ce_"
+)
+
+// CloudEvent is a CloudEvents 1.0 event carrying a POS event. The payload
+// schema version, the event lineage and the ingestion and processing times
+// travel as extension attributes.
+type CloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time,omitzero"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	EventVersion    int             `json:"eventversion,omitempty"`
+	CorrelationID   string          `json:"correlationid,omitempty"`
+	CausationID     string          `json:"causationid,omitempty"`
+	IngestTime      time.Time       `json:"ingesttime,omitzero"`
+	ProcessTime     time.Time       `json:"processtime,omitzero"`
+	Late            bool            `json:"late,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// ToCloudEvent converts an event into a CloudEvent whose data is encoded with the given codec
+func ToCloudEvent(event *models.Event, codec Codec) (*CloudEvent, error) {
+	data, err := codec.EncodePayload(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode payload: %v", err)
+	}
+
+	scope := event.Scope()
+	ce := &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          cloudEventSource(scope),
+		Type:            string(event.Type),
+		Subject:         scope.BasketID,
+		Time:            event.Timestamp,
+		DataContentType: codec.ContentType(),
+		EventVersion:    event.Version,
+		CorrelationID:   event.CorrelationID,
+		CausationID:     event.CausationID,
+		IngestTime:      event.IngestedAt,
+		ProcessTime:     event.ProcessedAt,
+		Late:            event.Late,
+	}
+
+	if codec.ContentType() == ContentTypeJSON {
+		ce.Data = data
+	} else {
+		ce.DataBase64 = data
+	}
+
+	return ce, nil
+}
+
+// Event converts the CloudEvent back into an event with a typed payload
+func (ce *CloudEvent) Event() (*models.Event, error) {
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		return nil, &models.ValidationError{
+			EventType: models.EventType(ce.Type),
+			Field:     "specversion",
+			Reason:    fmt.Sprintf("unsupported CloudEvents version %q", ce.SpecVersion),
+		}
+	}
+	if ce.ID == "" {
+		return nil, &models.ValidationError{EventType: models.EventType(ce.Type), Field: "id", Reason: "is required"}
+	}
+	if ce.Type == "" {
+		return nil, &models.ValidationError{Field: "type", Reason: "is required"}
+	}
+
+	codec, err := CodecFor(ce.DataContentType)
+	if err != nil {
+		return nil, err
+	}
+
+	data := []byte(ce.Data)
+	if len(ce.DataBase64) > 0 {
+		data = ce.DataBase64
+	}
+
+	eventType := models.EventType(ce.Type)
+	payload, err := codec.DecodePayload(eventType, ce.EventVersion, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.Event{
+		ID:            ce.ID,
+		Type:          eventType,
+		Version:       models.CurrentVersion(eventType),
+		Timestamp:     ce.Time,
+		CorrelationID: ce.CorrelationID,
+		CausationID:   ce.CausationID,
+		IngestedAt:    ce.IngestTime,
+		ProcessedAt:   ce.ProcessTime,
+		Late:          ce.Late,
+		Payload:       payload,
+	}, nil
+}
+
+// cloudEventSource identifies the terminal that produced an event
+func cloudEventSource(scope models.Scope) string {
+	source := "/pos"
+	if scope.StoreID != "" {
+		source += "/stores/" + scope.StoreID
+	}
+	if scope.TerminalID != "" {
+		source += "/terminals/" + scope.TerminalID
+	}
+	return source
+}
+
+// binaryHeaders returns the Kafka headers of a binary mode CloudEvent
+func (ce *CloudEvent) binaryHeaders() []sarama.RecordHeader {
+	attrs := [][2]string{
+		{"specversion", ce.SpecVersion},
+		{"id", ce.ID},
+		{"source", ce.Source},
+		{"type", ce.Type},
+	}
+	if ce.Subject != "" {
+		attrs = append(attrs, [2]string{"subject", ce.Subject})
+	}
+	if !ce.Time.IsZero() {
+		attrs = append(attrs, [2]string{"time", ce.Time.Format(time.RFC3339Nano)})
+	}
+	if ce.EventVersion != 0 {
+		attrs = append(attrs, [2]string{"eventversion", strconv.Itoa(ce.EventVersion)})
+	}
+	if ce.CorrelationID != "" {
+		attrs = append(attrs, [2]string{"correlationid", ce.CorrelationID})
+	}
+	if ce.CausationID != "" {
+		attrs = append(attrs, [2]string{"causationid", ce.CausationID})
+	}
+	if !ce.IngestTime.IsZero() {
+		attrs = append(attrs, [2]string{"ingesttime", ce.IngestTime.Format(time.RFC3339Nano)})
+	}
+	if !ce.ProcessTime.IsZero() {
+		attrs = append(attrs, [2]string{"processtime", ce.ProcessTime.Format(time.RFC3339Nano)})
+	}
+	if ce.Late {
+		attrs = append(attrs, [2]string{"late", "true"})
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(attrs)+1)
+	for _, attr := range attrs {
+		headers = append(headers, sarama.RecordHeader{
+			Key:   []byte(cloudEventsHeaderPrefix + attr[0]),
+			Value: []byte(attr[1]),
+		})
+	}
+	headers = append(headers, sarama.RecordHeader{
+		Key:   []byte(HeaderContentType),
+		Value: []byte(ce.DataContentType),
+	})
+	return headers
+}
+
+// cloudEventFromBinary reads a binary mode CloudEvent from Kafka headers and value
+func cloudEventFromBinary(headers []*sarama.RecordHeader, value []byte) (*CloudEvent, error) {
+	ce := &CloudEvent{DataContentType: headerValue(headers, HeaderContentType)}
+
+	for _, h := range headers {
+		if h == nil {
+			continue
+		}
+		key := strings.ToLower(string(h.Key))
+		if !strings.HasPrefix(key, cloudEventsHeaderPrefix) {
+			continue
+		}
+
+		v := string(h.Value)
+		switch strings.TrimPrefix(key, cloudEventsHeaderPrefix) {
+		case "specversion":
+			ce.SpecVersion = v
+		case "id":
+			ce.ID = v
+		case "source":
+			ce.Source = v
+		case "type":
+			ce.Type = v
+		case "subject":
+			ce.Subject = v
+		case "time", "ingesttime", "processtime":
+			attr := strings.TrimPrefix(key, cloudEventsHeaderPrefix)
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, &models.ValidationError{Field: attr, Reason: err.Error()}
+			}
+			switch attr {
+			case "time":
+				ce.Time = t
+			case "ingesttime":
+				ce.IngestTime = t
+			default:
+				ce.ProcessTime = t
+			}
+		case "eventversion":
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, &models.ValidationError{Field: "eventversion", Reason: err.Error()}
+			}
+			ce.EventVersion = version
+		case "correlationid":
+			ce.CorrelationID = v
+		case "causationid":
+			ce.CausationID = v
+		case "late":
+			late, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, &models.ValidationError{Field: "late", Reason: err.Error()}
+			}
+			ce.Late = late
+		}
+	}
+
+	if ce.DataContentType == ContentTypeJSON || ce.DataContentType == "" {
+		ce.Data = value
+	} else {
+		ce.DataBase64 = value
+	}
+
+	return ce, nil
+}
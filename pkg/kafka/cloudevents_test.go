@@ -0,0 +1,98 @@
+package kafka
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// consumerMessage turns produced headers and value into a consumed message
+func consumerMessage(value []byte, headers []sarama.RecordHeader) *sarama.ConsumerMessage {
+	msg := &sarama.ConsumerMessage{Value: value}
+	for i := range headers {
+		msg.Headers = append(msg.Headers, &headers[i])
+	}
+	return msg
+}
+
+func TestCloudEventsRoundTrip(t *testing.T) {
+	for _, mode := range []string{EnvelopeNative, EnvelopeCloudEventsBinary, EnvelopeCloudEventsStructured} {
+		for _, contentType := range []string{ContentTypeJSON, ContentTypeProtobuf} {
+			enc, err := newEncoder(&Config{ContentType: contentType, EnvelopeMode: mode})
+			assert.NoError(t, err)
+
+			for _, event := range testEvents() {
+				value, headers, err := enc.encode(event)
+				assert.NoError(t, err, "%s %s %s", mode, contentType, event.Type)
+
+				decoded, err := decodeMessage(consumerMessage(value, headers))
+				assert.NoError(t, err, "%s %s %s", mode, contentType, event.Type)
+				assert.Equal(t, event, decoded, "%s %s %s", mode, contentType, event.Type)
+			}
+		}
+	}
+}
+
+func TestCloudEventsBinaryHeaders(t *testing.T) {
+	enc, err := newEncoder(&Config{ContentType: ContentTypeJSON, EnvelopeMode: EnvelopeCloudEventsBinary})
+	assert.NoError(t, err)
+
+	event := &models.Event{
+		ID:      "evt-1",
+		Type:    models.EventStartBasket,
+		Version: 1,
+		Payload: &models.StartBasketPayload{BasketPayload: models.BasketPayload{
+			BasePayload: models.BasePayload{TerminalID: "POS001", StoreID: "STORE001"},
+			EmployeeID:  "EMP001",
+			BasketID:    "BASKET001",
+		}},
+	}
+
+	value, headers, err := enc.encode(event)
+	assert.NoError(t, err)
+
+	msg := consumerMessage(value, headers)
+	assert.Equal(t, "1.0", headerValue(msg.Headers, "ce_specversion"))
+	assert.Equal(t, "evt-1", headerValue(msg.Headers, "ce_id"))
+	assert.Equal(t, "START_BASKET", headerValue(msg.Headers, "ce_type"))
+	assert.Equal(t, "/pos/stores/STORE001/terminals/POS001", headerValue(msg.Headers, "ce_source"))
+	assert.Equal(t, "BASKET001", headerValue(msg.Headers, "ce_subject"))
+	assert.Equal(t, ContentTypeJSON, headerValue(msg.Headers, HeaderContentType))
+	assert.JSONEq(t, `{"terminal_id":"POS001","store_id":"STORE001","employee_id":"EMP001","basket_id":"BASKET001"}`, string(value))
+}
+
+func TestCloudEventsStructuredFromSDK(t *testing.T) {
+	// Structured events written by CloudEvents SDKs may omit datacontenttype
+	value := []byte(`{
+		"specversion": "1.0",
+		"id": "evt-1",
+		"source": "/pos/stores/STORE001/terminals/POS001",
+		"type": "EMPLOYEE_LOGIN",
+		"time": "2024-01-01T10:00:00Z",
+		"data": {"terminal_id": "POS001", "store_id": "STORE001", "employee_id": "EMP001"}
+	}`)
+
+	event, err := decodeMessage(&sarama.ConsumerMessage{
+		Value: value,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(HeaderContentType), Value: []byte(ContentTypeCloudEventsJSON + "; charset=UTF-8")},
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, models.EventEmployeeLogin, event.Type)
+	assert.Equal(t, "EMP001", event.Payload.(*models.EmployeeLoginPayload).EmployeeID)
+
+	var ce CloudEvent
+	assert.NoError(t, json.Unmarshal(value, &ce))
+	ce.SpecVersion = "0.3"
+	_, err = ce.Event()
+	assert.Error(t, err)
+}
+
+func TestNewEncoderRejectsUnknownMode(t *testing.T) {
+	_, err := newEncoder(&Config{ContentType: ContentTypeJSON, EnvelopeMode: "xml"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+)
+
+// HeaderContentType is the Kafka header describing how a message is encoded
+const HeaderContentType = "content-type"
+
+// Supported content types
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes and decodes events for the wire
+type Codec interface {
+	// ContentType returns the value written to the content-type header
+	ContentType() string
+
+	// Encode serializes an event
+	Encode(event *models.Event) ([]byte, error)
+
+	// Decode deserializes an event and validates its payload
+	Decode(data []byte) (*models.Event, error)
+
+	// EncodePayload serializes only the payload of an event
+	EncodePayload(event *models.Event) ([]byte, error)
+
+	// DecodePayload deserializes and validates a payload of the given
+	// event type written with the given schema version
+	DecodePayload(eventType models.EventType, version int, data []byte) (models.EventPayload, error)
+}
+
+var codecs = map[string]Codec{
+	ContentTypeJSON:     JSONCodec{},
+	ContentTypeProtobuf: ProtobufCodec{},
+}
+
+// CodecFor returns the codec for a content type. Messages without a content
+// type predate the header and are decoded as JSON.
+func CodecFor(contentType string) (Codec, error) {
+	if contentType == "" {
+		return codecs[ContentTypeJSON], nil
+	}
+
+	// Ignore parameters such as "; charset=utf-8"
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	codec, ok := codecs[strings.TrimSpace(strings.ToLower(mediaType))]
+	if !ok {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return codec, nil
+}
+
+// JSONCodec encodes events as JSON
+type JSONCodec struct{}
+
+// ContentType returns the JSON content type
+func (JSONCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+// Encode serializes an event as JSON
+func (JSONCodec) Encode(event *models.Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+// Decode deserializes a JSON event
+func (JSONCodec) Decode(data []byte) (*models.Event, error) {
+	return models.DecodeEvent(data)
+}
+
+// EncodePayload serializes the event payload as JSON
+func (JSONCodec) EncodePayload(event *models.Event) ([]byte, error) {
+	return json.Marshal(event.Payload)
+}
+
+// DecodePayload upcasts and deserializes a JSON payload
+func (JSONCodec) DecodePayload(eventType models.EventType, version int, data []byte) (models.EventPayload, error) {
+	raw, err := models.UpcastPayload(eventType, version, data)
+	if err != nil {
+		return nil, err
+	}
+	return models.DecodePayload(eventType, raw)
+}
+
+// headerValue returns the value of a message header
+func headerValue(headers []*sarama.RecordHeader, key string) string {
+	for _, h := range headers {
+		if h != nil && strings.EqualFold(string(h.Key), key) {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
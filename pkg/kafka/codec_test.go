@@ -0,0 +1,169 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/kafka/pospb"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func testEvents() []*models.Event {
+	base := models.BasePayload{TerminalID: "POS001", StoreID: "STORE001"}
+	basket := models.BasketPayload{BasePayload: base, EmployeeID: "EMP001", BasketID: "BASKET001"}
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 123, time.UTC)
+
+	payloads := map[models.EventType]models.EventPayload{
+		models.EventEmployeeLogin:    &models.EmployeeLoginPayload{BasePayload: base, EmployeeID: "EMP001"},
+		models.EventEmployeeLogout:   &models.EmployeeLogoutPayload{BasePayload: base, EmployeeID: "EMP001", AutoLogout: true, Reason: "moved"},
+		models.EventStartBasket:      &models.StartBasketPayload{BasketPayload: basket},
+		models.EventCustomerIdentify: &models.CustomerIdentifyPayload{BasketPayload: basket, CustomerID: "CUST001"},
+		models.EventAddItem:          &models.AddItemPayload{BasketPayload: basket, ItemID: "ITEM001", Price: models.NewMoney(1099, "USD"), Quantity: 2},
+		models.EventFinalizeSubtotal: &models.FinalizeSubtotalPayload{BasketPayload: basket},
+		models.EventPaymentComplete:  &models.PaymentCompletePayload{BasketPayload: basket, PaymentMethod: "CARD"},
+		models.EventCustomerData: &models.CustomerDataPayload{
+			BasePayload: base,
+			BasketID:    "BASKET001",
+			CustomerID:  "CUST001",
+			Data:        map[string]any{"tier": "regular", "total_purchases": float64(5)},
+		},
+		models.EventPurchaseRecommendations: &models.PurchaseRecommendationsPayload{
+			BasePayload:  base,
+			BasketID:     "BASKET001",
+			SourceItemID: "ITEM001",
+			Recommendations: []models.Recommendation{
+				{ItemID: "ITEM002", Name: "Item 2", Price: models.NewMoney(1599, "USD"), ConfidenceScore: 0.5},
+				{ItemID: "ITEM003", Name: "Item 3", Price: models.NewMoney(599, "USD"), ConfidenceScore: 0.25},
+			},
+		},
+	}
+
+	var events []*models.Event
+	for eventType, payload := range payloads {
+		events = append(events, &models.Event{
+			ID:            "evt-" + string(eventType),
+			Type:          eventType,
+			Version:       models.CurrentVersion(eventType),
+			Timestamp:     ts,
+			IngestedAt:    ts.Add(2 * time.Second),
+			ProcessedAt:   ts.Add(3 * time.Second),
+			Late:          eventType == models.EventAddItem,
+			CorrelationID: "evt-root",
+			CausationID:   "evt-parent",
+			Payload:       payload,
+		})
+	}
+	return events
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		for _, event := range testEvents() {
+			data, err := codec.Encode(event)
+			assert.NoError(t, err, "%s %s", codec.ContentType(), event.Type)
+
+			decoded, err := codec.Decode(data)
+			assert.NoError(t, err, "%s %s", codec.ContentType(), event.Type)
+			assert.Equal(t, event, decoded, "%s %s", codec.ContentType(), event.Type)
+		}
+	}
+}
+
+func TestProtobufCodecMapPayload(t *testing.T) {
+	event := &models.Event{
+		ID:   "evt-1",
+		Type: models.EventEmployeeLogin,
+		Payload: map[string]interface{}{
+			"terminal_id": "POS001",
+			"store_id":    "STORE001",
+			"employee_id": "EMP001",
+		},
+	}
+
+	data, err := ProtobufCodec{}.Encode(event)
+	assert.NoError(t, err)
+
+	decoded, err := ProtobufCodec{}.Decode(data)
+	assert.NoError(t, err)
+	assert.Equal(t, "EMP001", decoded.Payload.(*models.EmployeeLoginPayload).EmployeeID)
+}
+
+func TestProtobufCodecRejectsInvalidPayload(t *testing.T) {
+	event := &models.Event{
+		ID:      "evt-1",
+		Type:    models.EventStartBasket,
+		Payload: &models.StartBasketPayload{},
+	}
+
+	data, err := ProtobufCodec{}.Encode(event)
+	assert.NoError(t, err)
+
+	_, err = ProtobufCodec{}.Decode(data)
+	var verr *models.ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "terminal_id", verr.Field)
+}
+
+func TestDecodeMessageContentType(t *testing.T) {
+	event := testEvents()[0]
+
+	for _, codec := range []Codec{JSONCodec{}, ProtobufCodec{}} {
+		data, err := codec.Encode(event)
+		assert.NoError(t, err)
+
+		decoded, err := decodeMessage(&sarama.ConsumerMessage{
+			Value: data,
+			Headers: []*sarama.RecordHeader{
+				{Key: []byte(HeaderContentType), Value: []byte(codec.ContentType())},
+			},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, event.ID, decoded.ID)
+	}
+
+	// Messages without a content type are JSON
+	data, err := JSONCodec{}.Encode(event)
+	assert.NoError(t, err)
+	decoded, err := decodeMessage(&sarama.ConsumerMessage{Value: data})
+	assert.NoError(t, err)
+	assert.Equal(t, event.ID, decoded.ID)
+
+	_, err = CodecFor("text/plain")
+	assert.Error(t, err)
+}
+
+func TestProtobufCodecUpcastsOldVersions(t *testing.T) {
+	// ADD_ITEM v1 payload written by an old terminal with a double price
+	payload, err := proto.Marshal(&pospb.AddItem{
+		TerminalId: "POS001",
+		StoreId:    "STORE001",
+		EmployeeId: "EMP001",
+		BasketId:   "BASKET001",
+		ItemId:     "ITEM001",
+		Price:      10.99,
+		Quantity:   1,
+	})
+	assert.NoError(t, err)
+
+	// It goes through the same upcaster as the JSON payload
+	decoded, err := ProtobufCodec{}.DecodePayload(models.EventAddItem, 1, payload)
+	assert.NoError(t, err)
+	assert.Equal(t, models.NewMoney(1099, models.DefaultCurrency), decoded.(*models.AddItemPayload).Price)
+
+	jsonDecoded, err := JSONCodec{}.DecodePayload(models.EventAddItem, 1, []byte(`{"terminal_id":"POS001","store_id":"STORE001","employee_id":"EMP001","basket_id":"BASKET001","item_id":"ITEM001","price":10.99,"quantity":1}`))
+	assert.NoError(t, err)
+	assert.Equal(t, jsonDecoded, decoded)
+
+	// The double price is not read from current payloads
+	_, err = ProtobufCodec{}.DecodePayload(models.EventAddItem, models.CurrentVersion(models.EventAddItem), payload)
+	var verr *models.ValidationError
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "price.currency", verr.Field)
+
+	_, err = ProtobufCodec{}.DecodePayload(models.EventAddItem, models.CurrentVersion(models.EventAddItem)+1, payload)
+	assert.ErrorAs(t, err, &verr)
+	assert.Equal(t, "version", verr.Field)
+}
@@ -1,36 +1,363 @@
 package kafka
 
 import (
+	"log"
 	"os"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/env"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
 )
 
 // Config holds Kafka configuration
 type Config struct {
-	Brokers        []string
-	Topic          string
-	ConsumerGroup  string
-	RetryAttempts  int
-	RetryDelay     time.Duration
+	// Transport selects the kafka, the in-process memory or the postgres
+	// queue transport
+	Transport string
+	// Partitions is the partition count of topics of the memory and postgres
+	// transports
+	Partitions int
+	// MemoryBroker is the broker of the memory transport, by default one
+	// shared by the whole process
+	MemoryBroker *MemoryBroker
+	// Database is the connection of the postgres transport, by default one
+	// opened from the DB_* environment
+	Database *database.Connection
+	// QueueVisibilityTimeout is how long a claimed queue message and the
+	// partition leases of a group member last without a heartbeat
+	QueueVisibilityTimeout time.Duration
+	// QueueRetention is how long queue messages are kept, zero keeps them
+	QueueRetention time.Duration
+	// QueuePollInterval is how often an idle consumer polls the queue
+	QueuePollInterval time.Duration
+	Brokers           []string
+	// Topic is the default topic events are produced to
+	Topic string
+	// Topics lists the topics the consumer subscribes to, by default Topic
+	// and every topic in TopicRoutes
+	Topics []string
+	// TopicPattern subscribes the consumer to all topics matching the
+	// regular expression instead of Topics
+	TopicPattern string
+	// TopicRefreshInterval is how often topics matching TopicPattern are
+	// looked up to pick up new ones
+	TopicRefreshInterval time.Duration
+	// TopicRoutes maps event types to the topic the producer sends them to,
+	// other types go to Topic
+	TopicRoutes map[models.EventType]string
+	// ProvisionTopics creates the topics the service reads and writes on
+	// startup when they do not exist
+	ProvisionTopics bool
+	// TopicPartitions, TopicReplicationFactor and TopicRetention declare how
+	// topics are created, a negative retention keeps messages forever and
+	// zero leaves the broker default
+	TopicPartitions        int
+	TopicReplicationFactor int
+	TopicRetention         time.Duration
+	// TopicSpecs overrides the declaration of single topics, zero fields
+	// keep the defaults
+	TopicSpecs    map[string]TopicSpec
+	ConsumerGroup string
+	// RetryAttempts is how often a failed send or handler call is retried
+	RetryAttempts int
+	// RetryDelay is the base delay of the consumer's exponential backoff
+	RetryDelay time.Duration
+	// CommitInterval is how often marked offsets are committed, zero commits
+	// after every message
 	CommitInterval time.Duration
+	// Delivery selects at-least-once or at-most-once processing, ignored in
+	// transactional mode
+	Delivery string
+	// Workers is the number of messages of a partition processed in
+	// parallel. Messages with the same key, such as the events of a
+	// terminal, are always processed in order.
+	Workers int
+	// ContentType selects the codec used by the producer
+	ContentType string
+	// EnvelopeMode selects between the native envelope and CloudEvents
+	EnvelopeMode string
+	// LatenessThreshold is how far behind its event time an event may be
+	// ingested before it is considered late, zero disables the check
+	LatenessThreshold time.Duration
+	// LatePolicy decides what happens to late events: flag, drop or route
+	LatePolicy string
+	// LateTopic receives late events when LatePolicy is route
+	LateTopic string
+	// PartitionBy selects how the producer keys messages: terminal, basket,
+	// store or id
+	PartitionBy string
+	// Async switches the producer to batched asynchronous delivery
+	Async bool
+	// BatchSize is the number of messages that triggers an async flush
+	BatchSize int
+	// Linger is how long the async producer waits to fill a batch
+	Linger time.Duration
+	// Compression is the codec of produced batches: none, gzip, snappy,
+	// lz4 or zstd
+	Compression string
+	// Idempotent makes the broker discard duplicates caused by producer retries
+	Idempotent bool
+	// Transactional makes the consumer publish derived events to OutputTopic
+	// and commit its offsets in one Kafka transaction
+	Transactional bool
+	// TransactionalID identifies the transactional producer across restarts,
+	// it defaults to the consumer group and hostname
+	TransactionalID string
+	// PublishDerived makes the consumer publish events derived by plugins,
+	// always enabled in transactional mode
+	PublishDerived bool
+	// OutputTopic receives derived events without a route
+	OutputTopic string
+	// OutputRoutes maps derived event types to the topics they are published to
+	OutputRoutes map[models.EventType][]string
+	// LagThreshold is the total consumer lag above which the consumer reports
+	// itself unhealthy, zero disables the check
+	LagThreshold int64
+	// LagInterval is how often the consumer fetches the high-water marks and
+	// committed offsets of its partitions, zero only updates them when
+	// messages arrive
+	LagInterval time.Duration
+	// DeadLetterTopic receives messages that could not be decoded or
+	// processed when FailurePolicy is dead-letter
+	DeadLetterTopic string
+	// FailurePolicy decides what happens to messages that could not be
+	// decoded or processed: dead-letter or drop
+	FailurePolicy string
+
+	// TLSEnabled encrypts connections to the brokers
+	TLSEnabled bool
+	// TLSCAFile is the PEM bundle used to verify the brokers, the system
+	// pool is used when empty
+	TLSCAFile string
+	// TLSCertFile and TLSKeyFile hold the client certificate presented to
+	// brokers requiring mutual TLS
+	TLSCertFile string
+	TLSKeyFile  string
+	// TLSServerName overrides the host name the broker certificates are
+	// verified against
+	TLSServerName         string
+	TLSInsecureSkipVerify bool
+	// SASLMechanism selects PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+	// authentication, empty disables SASL
+	SASLMechanism string
+	SASLUsername  string
+	SASLPassword  string
 }
 
+// Delivery modes
+const (
+	// DeliveryAtLeastOnce commits a message after it was processed, so it is
+	// processed again if the consumer fails before the commit
+	DeliveryAtLeastOnce = "at-least-once"
+	// DeliveryAtMostOnce commits a message before it is processed, so it is
+	// lost if the consumer fails while processing it
+	DeliveryAtMostOnce = "at-most-once"
+)
+
+// Late event policies
+const (
+	LatePolicyFlag  = "flag"
+	LatePolicyDrop  = "drop"
+	LatePolicyRoute = "route"
+)
+
+// Failure policies
+const (
+	// FailurePolicyDeadLetter commits a failed message once it is stored in
+	// the dead-letter topic, and redelivers it while that fails
+	FailurePolicyDeadLetter = "dead-letter"
+	// FailurePolicyDrop logs, counts and commits failed messages
+	FailurePolicyDrop = "drop"
+)
+
 // NewDefaultConfig returns a default configuration
 func NewDefaultConfig() *Config {
 	return &Config{
-		Brokers:        strings.Split(getEnvOrDefault("KAFKA_BROKERS", "localhost:9092"), ","),
-		Topic:          getEnvOrDefault("KAFKA_TOPIC", "pos_events"),
-		ConsumerGroup:  getEnvOrDefault("KAFKA_CONSUMER_GROUP", "pos_consumer_group"),
-		RetryAttempts:  3,
-		RetryDelay:     time.Second * 5,
-		CommitInterval: time.Second * 1,
+		Transport:  env.String("KAFKA_TRANSPORT", TransportKafka),
+		Partitions: env.Int("KAFKA_TRANSPORT_PARTITIONS", 3),
+
+		QueueVisibilityTimeout: env.Duration("KAFKA_QUEUE_VISIBILITY_TIMEOUT", time.Second*30),
+		QueueRetention:         env.Duration("KAFKA_QUEUE_RETENTION", time.Hour*24*7),
+		QueuePollInterval:      env.Duration("KAFKA_QUEUE_POLL_INTERVAL", time.Millisecond*500),
+
+		Brokers:        strings.Split(env.String("KAFKA_BROKERS", "localhost:9092"), ","),
+		Topic:          env.String("KAFKA_TOPIC", "pos_events"),
+		Topics:         env.List("KAFKA_TOPICS", nil),
+		TopicPattern:   os.Getenv("KAFKA_TOPIC_PATTERN"),
+		TopicRoutes:    parseTopicRoutes(os.Getenv("KAFKA_TOPIC_ROUTES")),
+		ConsumerGroup:  env.String("KAFKA_CONSUMER_GROUP", "pos_consumer_group"),
+		RetryAttempts:  env.Int("KAFKA_RETRY_ATTEMPTS", 3),
+		RetryDelay:     env.Duration("KAFKA_RETRY_DELAY", time.Second*5),
+		CommitInterval: env.Duration("KAFKA_COMMIT_INTERVAL", time.Second*1),
+
+		TopicRefreshInterval: env.Duration("KAFKA_TOPIC_REFRESH_INTERVAL", time.Minute),
+		Delivery:             env.String("KAFKA_DELIVERY", DeliveryAtLeastOnce),
+		Workers:              env.Int("KAFKA_WORKERS", 1),
+		ContentType:          env.String("KAFKA_CONTENT_TYPE", ContentTypeJSON),
+		EnvelopeMode:         env.String("KAFKA_ENVELOPE_MODE", EnvelopeNative),
+
+		ProvisionTopics:        env.Bool("KAFKA_PROVISION_TOPICS", true),
+		TopicPartitions:        env.Int("KAFKA_TOPIC_PARTITIONS", 3),
+		TopicReplicationFactor: env.Int("KAFKA_TOPIC_REPLICATION_FACTOR", 1),
+		TopicRetention:         env.Duration("KAFKA_TOPIC_RETENTION", time.Hour*24*7),
+		TopicSpecs:             parseTopicSpecs(os.Getenv("KAFKA_TOPIC_SPECS")),
+
+		LatenessThreshold: env.Duration("KAFKA_LATENESS_THRESHOLD", time.Minute*5),
+		LatePolicy:        env.String("KAFKA_LATE_POLICY", LatePolicyFlag),
+		LateTopic:         env.String("KAFKA_LATE_TOPIC", "pos_events_late"),
+		DeadLetterTopic:   env.String("KAFKA_DLQ_TOPIC", "pos_events_dlq"),
+		FailurePolicy:     env.String("KAFKA_FAILURE_POLICY", FailurePolicyDeadLetter),
+		PartitionBy:       env.String("KAFKA_PARTITION_BY", PartitionByTerminal),
+		LagThreshold:      int64(env.Int("KAFKA_LAG_THRESHOLD", 1000)),
+		LagInterval:       env.Duration("KAFKA_LAG_INTERVAL", time.Second*10),
+
+		Async:       env.Bool("KAFKA_PRODUCER_ASYNC", false),
+		BatchSize:   env.Int("KAFKA_BATCH_SIZE", 500),
+		Linger:      env.Duration("KAFKA_LINGER", time.Millisecond*10),
+		Compression: env.String("KAFKA_COMPRESSION", "none"),
+		Idempotent:  env.Bool("KAFKA_IDEMPOTENT", false),
+
+		Transactional:   env.Bool("KAFKA_TRANSACTIONAL", false),
+		TransactionalID: os.Getenv("KAFKA_TRANSACTIONAL_ID"),
+		PublishDerived:  env.Bool("KAFKA_PUBLISH_DERIVED", false),
+		OutputTopic:     env.String("KAFKA_OUTPUT_TOPIC", "pos_derived_events"),
+		OutputRoutes:    parseRoutes(os.Getenv("KAFKA_OUTPUT_ROUTES")),
+
+		TLSEnabled:            env.Bool("KAFKA_TLS_ENABLED", false),
+		TLSCAFile:             os.Getenv("KAFKA_TLS_CA_FILE"),
+		TLSCertFile:           os.Getenv("KAFKA_TLS_CERT_FILE"),
+		TLSKeyFile:            os.Getenv("KAFKA_TLS_KEY_FILE"),
+		TLSServerName:         os.Getenv("KAFKA_TLS_SERVER_NAME"),
+		TLSInsecureSkipVerify: env.Bool("KAFKA_TLS_INSECURE_SKIP_VERIFY", false),
+
+		SASLMechanism: os.Getenv("KAFKA_SASL_MECHANISM"),
+		SASLUsername:  getEnvOrFile("KAFKA_SASL_USERNAME"),
+		SASLPassword:  getEnvOrFile("KAFKA_SASL_PASSWORD"),
 	}
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
+// memoryBroker returns the broker of the memory transport
+func (cfg *Config) memoryBroker() *MemoryBroker {
+	if cfg.MemoryBroker != nil {
+		return cfg.MemoryBroker
+	}
+	return defaultMemoryBroker
+}
+
+// parseRoutes parses routes such as
+// "PURCHASE_RECOMMENDATIONS=pos_recommendations|pos_terminals,CUSTOMER_DATA=pos_customers"
+func parseRoutes(value string) map[models.EventType][]string {
+	routes := make(map[models.EventType][]string)
+	for _, route := range strings.Split(value, ",") {
+		if strings.TrimSpace(route) == "" {
+			continue
+		}
+
+		eventType, topics, ok := strings.Cut(route, "=")
+		if !ok || strings.TrimSpace(eventType) == "" {
+			log.Printf("Invalid output route %q, expected TYPE=topic|topic", route)
+			continue
+		}
+
+		var list []string
+		for _, topic := range strings.Split(topics, "|") {
+			if topic = strings.TrimSpace(topic); topic != "" {
+				list = append(list, topic)
+			}
+		}
+		routes[models.EventType(strings.TrimSpace(eventType))] = list
+	}
+	return routes
+}
+
+// parseTopicRoutes parses producer routes such as
+// "EMPLOYEE_LOGIN=pos_employee_events,PAYMENT_COMPLETE=pos_payment_events"
+func parseTopicRoutes(value string) map[models.EventType]string {
+	routes := make(map[models.EventType]string)
+	for eventType, topics := range parseRoutes(value) {
+		if len(topics) != 1 {
+			log.Printf("Invalid topic route for %s, expected a single topic", eventType)
+			continue
+		}
+		routes[eventType] = topics[0]
+	}
+	return routes
+}
+
+// parseTopicSpecs parses topic declarations such as
+// "pos_events=6:3:168h,pos_events_dlq=::720h" holding the partitions,
+// replication factor and retention of each topic
+func parseTopicSpecs(value string) map[string]TopicSpec {
+	specs := make(map[string]TopicSpec)
+	for _, item := range strings.Split(value, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+
+		name, fields, ok := strings.Cut(item, "=")
+		name = strings.TrimSpace(name)
+		parts := strings.Split(fields, ":")
+		if !ok || name == "" || len(parts) > 3 {
+			log.Printf("Invalid topic spec %q, expected topic=partitions:replication:retention", item)
+			continue
+		}
+		parts = append(parts, make([]string, 3-len(parts))...)
+
+		spec, err := parseTopicSpec(name, parts[0], parts[1], parts[2])
+		if err != nil {
+			log.Printf("Invalid topic spec %q: %v", item, err)
+			continue
+		}
+		specs[name] = spec
+	}
+	return specs
+}
+
+func parseTopicSpec(name, partitions, replication, retention string) (TopicSpec, error) {
+	spec := TopicSpec{Name: name}
+	if partitions = strings.TrimSpace(partitions); partitions != "" {
+		n, err := strconv.ParseInt(partitions, 10, 32)
+		if err != nil {
+			return spec, err
+		}
+		spec.Partitions = int32(n)
+	}
+	if replication = strings.TrimSpace(replication); replication != "" {
+		n, err := strconv.ParseInt(replication, 10, 16)
+		if err != nil {
+			return spec, err
+		}
+		spec.ReplicationFactor = int16(n)
+	}
+	if retention = strings.TrimSpace(retention); retention != "" {
+		d, err := time.ParseDuration(retention)
+		if err != nil {
+			return spec, err
+		}
+		spec.RetentionMs = retentionMs(d)
+	}
+	return spec, nil
+}
+
+// getEnvOrFile returns the value of the environment variable, or the content
+// of the file named by the variable with a _FILE suffix, so secrets can be
+// mounted as files
+func getEnvOrFile(key string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
 	}
-	return defaultValue
+
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read %s_FILE: %v", key, err)
+		return ""
+	}
+	return strings.TrimSpace(string(data))
 }
@@ -2,51 +2,197 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
 )
 
 // MessageHandler is a function that processes a Kafka message
 type MessageHandler func(context.Context, *models.Event) error
 
+// HeaderLateBy records how far behind its event time a routed late event was
+const HeaderLateBy = "x-late-by"
+
 // Consumer represents a Kafka consumer
 type Consumer struct {
-	consumer       sarama.ConsumerGroup
-	topic          string
+	transport Transport
+	topics    []string
+	// pattern subscribes to all matching topics instead of topics
+	pattern        *regexp.Regexp
+	lister         topicLister
+	topicRefresh   time.Duration
+	excluded       map[string]bool
 	handler        MessageHandler
 	ready          chan bool
-	commitInterval int64
+	commitInterval time.Duration
+	delivery       string
+	// workers is the number of messages of a partition processed in parallel
+	workers int
+	// committer commits marked offsets on the commit interval
+	committer     sync.WaitGroup
+	retryAttempts int
+	retryDelay    time.Duration
+
+	latenessThreshold time.Duration
+	latePolicy        string
+	lateTopic         string
+	deadLetterTopic   string
+	// dropFailed commits failed messages without dead-lettering them
+	dropFailed bool
+	dropped    atomic.Int64
+	// forwarder republishes messages to other topics. In transactional mode
+	// it is the transactional producer that also publishes derived events.
+	forwarder *Producer
+	// emitter publishes derived events, nil when they are not published
+	emitter eventctx.Emitter
+
+	transactional bool
+	group         string
+	// txnMu serializes transactions of concurrently consumed partitions
+	txnMu sync.Mutex
+
+	lag          *lagTracker
+	lagThreshold int64
+	lagInterval  time.Duration
+
+	pauses pauser
+	// sessionMu guards the pending offset resets and endSession, which ends
+	// the running session so the resets are applied
+	sessionMu  sync.Mutex
+	resets     []*pendingReset
+	endSession context.CancelFunc
 }
 
 // NewConsumer creates a new Kafka consumer
 func NewConsumer(cfg *Config, handler MessageHandler) (*Consumer, error) {
-	config := sarama.NewConfig()
+	config, err := newConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
 	config.Consumer.Group.Rebalance.Strategy = sarama.NewBalanceStrategyRoundRobin()
 	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	// Offsets are committed explicitly, or by the producer transaction
+	config.Consumer.Offsets.AutoCommit.Enable = false
+	if cfg.Transactional {
+		config.Version = sarama.V2_1_0_0
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+	}
+
+	switch cfg.Delivery {
+	case "", DeliveryAtLeastOnce, DeliveryAtMostOnce:
+	default:
+		return nil, fmt.Errorf("unsupported delivery mode %q", cfg.Delivery)
+	}
 
-	group, err := sarama.NewConsumerGroup(cfg.Brokers, cfg.ConsumerGroup, config)
+	switch cfg.LatePolicy {
+	case "", LatePolicyFlag, LatePolicyDrop, LatePolicyRoute:
+	default:
+		return nil, fmt.Errorf("unsupported late policy %q", cfg.LatePolicy)
+	}
+
+	var pattern *regexp.Regexp
+	if cfg.TopicPattern != "" {
+		if pattern, err = regexp.Compile(cfg.TopicPattern); err != nil {
+			return nil, fmt.Errorf("invalid topic pattern: %v", err)
+		}
+	}
+
+	if cfg.Transactional && cfg.Transport != "" && cfg.Transport != TransportKafka {
+		return nil, fmt.Errorf("transactions are not supported by the %s transport", cfg.Transport)
+	}
+	// Transactions are committed one message at a time
+	if cfg.Transactional && cfg.Workers > 1 {
+		return nil, fmt.Errorf("parallel workers are not supported in transactional mode")
+	}
+
+	switch cfg.FailurePolicy {
+	case "", FailurePolicyDeadLetter:
+		if cfg.DeadLetterTopic == "" {
+			return nil, fmt.Errorf("a dead-letter topic is required unless the failure policy is %s", FailurePolicyDrop)
+		}
+	case FailurePolicyDrop:
+	default:
+		return nil, fmt.Errorf("unsupported failure policy %q", cfg.FailurePolicy)
+	}
+
+	transport, err := newTransport(cfg, config)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create consumer group: %v", err)
+		return nil, err
+	}
+	// Every transport can list its topics for pattern subscriptions
+	lister, _ := transport.(topicLister)
+
+	c := &Consumer{
+		transport:         transport,
+		topics:            subscribedTopics(cfg),
+		pattern:           pattern,
+		lister:            lister,
+		topicRefresh:      cfg.TopicRefreshInterval,
+		excluded:          forwardingTopics(cfg),
+		handler:           handler,
+		ready:             make(chan bool),
+		commitInterval:    cfg.CommitInterval,
+		delivery:          cfg.Delivery,
+		workers:           cfg.Workers,
+		retryAttempts:     cfg.RetryAttempts,
+		retryDelay:        cfg.RetryDelay,
+		latenessThreshold: cfg.LatenessThreshold,
+		latePolicy:        cfg.LatePolicy,
+		lateTopic:         cfg.LateTopic,
+		deadLetterTopic:   cfg.DeadLetterTopic,
+		dropFailed:        cfg.FailurePolicy == FailurePolicyDrop,
+		transactional:     cfg.Transactional,
+		group:             cfg.ConsumerGroup,
+		lag:               newLagTracker(),
+		lagThreshold:      cfg.LagThreshold,
+		lagInterval:       cfg.LagInterval,
+	}
+
+	switch {
+	case c.transactional:
+		if c.forwarder, err = newTransactionalProducer(cfg); err != nil {
+			transport.Close()
+			return nil, fmt.Errorf("failed to create transactional producer: %v", err)
+		}
+	case c.latePolicy == LatePolicyRoute || !c.dropFailed || cfg.PublishDerived:
+		if c.forwarder, err = NewProducer(cfg); err != nil {
+			transport.Close()
+			return nil, fmt.Errorf("failed to create forwarding producer: %v", err)
+		}
+	}
+
+	if c.transactional || cfg.PublishDerived {
+		c.emitter = NewRouter(c.forwarder, cfg)
 	}
 
-	return &Consumer{
-		consumer:       group,
-		topic:          cfg.Topic,
-		handler:        handler,
-		ready:          make(chan bool),
-		commitInterval: cfg.CommitInterval.Milliseconds(),
-	}, nil
+	return c, nil
 }
 
 // Start starts consuming messages
 func (c *Consumer) Start(ctx context.Context) error {
-	topics := []string{c.topic}
 	for {
-		err := c.consumer.Consume(ctx, topics, c)
+		topics, err := c.subscription()
+		if err != nil {
+			return fmt.Errorf("failed to resolve topics: %v", err)
+		}
+
+		sessionCtx, cancel := context.WithCancel(ctx)
+		c.sessionMu.Lock()
+		c.endSession = cancel
+		c.sessionMu.Unlock()
+		if c.pattern != nil && c.topicRefresh > 0 {
+			go c.watchTopics(sessionCtx, topics, cancel)
+		}
+
+		err = c.transport.Subscribe(sessionCtx, topics, c)
+		cancel()
 		if err != nil {
 			return fmt.Errorf("error from consumer: %v", err)
 		}
@@ -61,44 +207,348 @@ func (c *Consumer) Start(ctx context.Context) error {
 
 // Close closes the consumer
 func (c *Consumer) Close() error {
-	return c.consumer.Close()
+	if c.forwarder != nil {
+		if err := c.forwarder.Close(); err != nil {
+			log.Printf("Error closing forwarding producer: %v", err)
+		}
+	}
+	return c.transport.Close()
 }
 
 // Setup is run at the beginning of a new session
-func (c *Consumer) Setup(sarama.ConsumerGroupSession) error {
+func (c *Consumer) Setup(session sarama.ConsumerGroupSession) error {
+	c.applyResets(session)
+	c.lag.assign(session.Claims())
+	if fetcher, ok := c.transport.(offsetFetcher); ok && c.lagInterval > 0 {
+		go c.lagLoop(session, fetcher)
+	}
+	if !c.transactional && c.commitInterval > 0 {
+		c.committer.Add(1)
+		go c.commitLoop(session)
+	}
 	close(c.ready)
 	return nil
 }
 
-// Cleanup is run at the end of a session
-func (c *Consumer) Cleanup(sarama.ConsumerGroupSession) error {
+// Cleanup is run at the end of a session, when partitions are rebalanced or
+// the consumer shuts down. Offsets marked since the last commit are committed
+// so the next owner of a partition does not reprocess them.
+func (c *Consumer) Cleanup(session sarama.ConsumerGroupSession) error {
+	c.committer.Wait()
+	if !c.transactional {
+		session.Commit()
+	}
 	return nil
 }
 
+// commitLoop commits marked offsets on the commit interval until the session ends
+func (c *Consumer) commitLoop(session sarama.ConsumerGroupSession) {
+	defer c.committer.Done()
+
+	ticker := time.NewTicker(c.commitInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			session.Commit()
+		case <-session.Context().Done():
+			return
+		}
+	}
+}
+
 // ConsumeClaim processes messages from a partition
 func (c *Consumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if c.workers > 1 {
+		return c.consumeParallel(session, claim)
+	}
+
+	tp := TopicPartition{Topic: claim.Topic(), Partition: claim.Partition()}
 	for {
+		// A message interrupted by the end of the session is not committed,
+		// so neither may the messages after it
+		if session.Context().Err() != nil || !c.pauses.wait(session.Context(), tp) {
+			return nil
+		}
+
 		select {
 		case message, ok := <-claim.Messages():
 			if !ok {
 				return nil
 			}
 
-			var event models.Event
-			if err := json.Unmarshal(message.Value, &event); err != nil {
-				log.Printf("Error unmarshaling event: %v", err)
-				continue
+			c.lag.observe(message, claim.HighWaterMarkOffset())
+			if err := c.process(session, message); err != nil {
+				// Later messages must not be committed past this one
+				log.Printf("Error processing message at offset %d, redelivering: %v", message.Offset, err)
+				c.restartSession()
+				return nil
 			}
 
-			if err := c.handler(session.Context(), &event); err != nil {
-				log.Printf("Error handling event: %v", err)
-				continue
-			}
+		case <-session.Context().Done():
+			return nil
+		}
+	}
+}
 
-			session.MarkMessage(message, "")
+// process handles a single message and commits it, unless it has to be
+// redelivered because the consumer is shutting down. An error means the
+// message is not committed and has to be redelivered as well.
+func (c *Consumer) process(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
+	if c.transactional {
+		c.txnMu.Lock()
+		defer c.txnMu.Unlock()
+	} else if c.delivery == DeliveryAtMostOnce {
+		// Commit before processing so the message is never redelivered
+		c.mark(session, message)
+		session.Commit()
+	}
 
-		case <-session.Context().Done():
+	event, err := decodeMessage(message)
+	if err != nil {
+		log.Printf("Rejected event at offset %d: %v", message.Offset, err)
+		return c.sendToDeadLetter(session, message, deadLetter{reason: DLQReasonDecode, err: err, attempts: 1})
+	}
+
+	stampTimes(event, message)
+	if c.isLate(event) {
+		if handled, err := c.handleLate(session, event, message); handled || err != nil {
+			return err
+		}
+	}
+
+	progress := NewProgress()
+	if c.transactional {
+		progress = NewTransactionalProgress()
+	}
+	ctx := eventctx.ContextWithProgress(session.Context(), progress)
+	ctx = eventctx.ContextWithDelivery(ctx, c.deliveryOf(message))
+	attempts, err := c.handleWithRetry(ctx, event)
+	if err != nil {
+		// Shutting down, the event is redelivered to the next session
+		if session.Context().Err() != nil {
 			return nil
 		}
+		log.Printf("Error handling event %s after %d attempts: %v", event.ID, attempts, err)
+		return c.sendToDeadLetter(session, message, deadLetter{reason: DLQReasonHandler, err: err, attempts: attempts})
+	}
+
+	if err := c.commit(session, message); err != nil {
+		progress.Abort()
+		return err
+	}
+	progress.Commit()
+	return nil
+}
+
+// deliveryOf identifies the message within the consumer group. The group
+// delivers a partition to one consumer at a time, so a redelivery of the
+// message means the earlier delivery was abandoned.
+func (c *Consumer) deliveryOf(message *sarama.ConsumerMessage) string {
+	return fmt.Sprintf("%s/%s/%d/%d", c.group, message.Topic, message.Partition, message.Offset)
+}
+
+// handleWithRetry runs the handler, retrying retryable failures with
+// exponential backoff. The attempts share the progress of the event in the
+// context, so a retry skips the work earlier attempts completed. It returns
+// the number of attempts made.
+func (c *Consumer) handleWithRetry(ctx context.Context, event *models.Event) (int, error) {
+	attempt := 1
+	for {
+		err := c.attempt(ctx, event, attempt)
+		if err == nil || attempt > c.retryAttempts || !IsRetryable(err) {
+			return attempt, err
+		}
+
+		delay := backoff(attempt, c.retryDelay)
+		log.Printf("Retrying event %s in %s after attempt %d: %v", event.ID, delay, attempt, err)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return attempt, err
+		}
+		attempt++
+	}
+}
+
+// attempt runs the handler once. In transactional mode it runs within a new
+// transaction that is left open on success, so the caller can commit it
+// together with the message offset. A failed transaction is aborted along
+// with the work the attempt completed.
+func (c *Consumer) attempt(ctx context.Context, event *models.Event, attempt int) error {
+	ctx = eventctx.ContextWithAttempt(ctx, attempt)
+	if c.emitter != nil {
+		ctx = eventctx.ContextWithEmitter(ctx, c.emitter)
+	}
+	if !c.transactional {
+		return c.handler(ctx, event)
+	}
+
+	if err := c.beginTxn(); err != nil {
+		return err
+	}
+	if err := c.handler(ctx, event); err != nil {
+		c.abortTxn()
+		progressFromContext(ctx).Abort()
+		return err
+	}
+	return nil
+}
+
+// stampTimes records when the event was ingested and when processing started
+func stampTimes(event *models.Event, message *sarama.ConsumerMessage) {
+	event.IngestedAt = message.Timestamp
+	if event.IngestedAt.IsZero() {
+		event.IngestedAt = time.Now()
+	}
+	event.ProcessedAt = time.Now()
+}
+
+// isLate reports whether the event arrived too far behind its event time
+func (c *Consumer) isLate(event *models.Event) bool {
+	return c.latenessThreshold > 0 && event.Lateness() > c.latenessThreshold
+}
+
+// handleLate applies the late policy and reports whether the event was
+// taken out of the normal processing path and committed
+func (c *Consumer) handleLate(session sarama.ConsumerGroupSession, event *models.Event, message *sarama.ConsumerMessage) (bool, error) {
+	switch c.latePolicy {
+	case LatePolicyDrop:
+		log.Printf("Dropping event %s, %s late", event.ID, event.Lateness())
+		if err := c.beginTxn(); err != nil {
+			return true, err
+		}
+		return true, c.commit(session, message)
+	case LatePolicyRoute:
+		err := c.forwardAndCommit(session, message, c.lateTopic, sarama.RecordHeader{
+			Key:   []byte(HeaderLateBy),
+			Value: []byte(event.Lateness().String()),
+		})
+		if err != nil {
+			return true, fmt.Errorf("failed to route late event %s: %v", event.ID, err)
+		}
+		log.Printf("Routed event %s to %s, %s late", event.ID, c.lateTopic, event.Lateness())
+		return true, nil
+	default:
+		event.Late = true
+		return false, nil
+	}
+}
+
+// sendToDeadLetter forwards a failed message to the dead-letter topic and
+// commits it once it is safely stored there, retrying with backoff. With the
+// drop policy the message is committed without being forwarded.
+func (c *Consumer) sendToDeadLetter(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, dl deadLetter) error {
+	if c.dropFailed {
+		log.Printf("Dropping failed message at offset %d of %s/%d: %v", message.Offset, message.Topic, message.Partition, dl.err)
+		if err := c.beginTxn(); err != nil {
+			return err
+		}
+		if err := c.commit(session, message); err != nil {
+			return err
+		}
+		c.dropped.Add(1)
+		return nil
+	}
+
+	attempt := 1
+	for {
+		err := c.forwardAndCommit(session, message, c.deadLetterTopic, dl.headers(message, time.Now())...)
+		if err == nil {
+			break
+		}
+		if attempt > c.retryAttempts {
+			return fmt.Errorf("failed to dead-letter message: %v", err)
+		}
+
+		delay := backoff(attempt, c.retryDelay)
+		log.Printf("Retrying dead-letter of message at offset %d in %s: %v", message.Offset, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-session.Context().Done():
+			return fmt.Errorf("failed to dead-letter message: %v", err)
+		}
+		attempt++
+	}
+
+	log.Printf("Dead-lettered message at offset %d to %s", message.Offset, c.deadLetterTopic)
+	return nil
+}
+
+// Dropped returns the number of failed messages committed without being
+// dead-lettered
+func (c *Consumer) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// restartSession ends the running session. The consumer rejoins its group
+// and resumes from the committed offsets.
+func (c *Consumer) restartSession() {
+	c.sessionMu.Lock()
+	defer c.sessionMu.Unlock()
+
+	if c.endSession != nil {
+		c.endSession()
+	}
+}
+
+// forwardAndCommit republishes a message to another topic and commits it,
+// atomically in transactional mode
+func (c *Consumer) forwardAndCommit(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage, topic string, headers ...sarama.RecordHeader) error {
+	if err := c.beginTxn(); err != nil {
+		return err
+	}
+	if err := c.forwarder.forward(topic, message, headers...); err != nil {
+		c.abortTxn()
+		return err
+	}
+	return c.commit(session, message)
+}
+
+// commit marks a message as consumed, to be committed on the next commit
+// interval or right away without one. In transactional mode the offset is
+// added to the open transaction, which is then committed.
+func (c *Consumer) commit(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) error {
+	if !c.transactional {
+		c.mark(session, message)
+		if c.commitInterval <= 0 {
+			session.Commit()
+		}
+		return nil
+	}
+
+	txn := c.forwarder.producer
+	if err := txn.AddMessageToTxn(message, c.group, nil); err != nil {
+		c.abortTxn()
+		return fmt.Errorf("failed to add offset to transaction: %v", err)
+	}
+	if err := txn.CommitTxn(); err != nil {
+		c.abortTxn()
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	c.lag.mark(message)
+	return nil
+}
+
+// beginTxn starts a transaction in transactional mode
+func (c *Consumer) beginTxn() error {
+	if !c.transactional {
+		return nil
+	}
+	if err := c.forwarder.producer.BeginTxn(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	return nil
+}
+
+// abortTxn aborts the open transaction in transactional mode
+func (c *Consumer) abortTxn() {
+	if !c.transactional {
+		return
+	}
+	if err := c.forwarder.producer.AbortTxn(); err != nil {
+		log.Printf("Error aborting transaction: %v", err)
 	}
 }
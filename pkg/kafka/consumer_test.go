@@ -0,0 +1,219 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+	"github.com/stretchr/testify/assert"
+)
+
+func lateEvent() (*models.Event, *sarama.ConsumerMessage) {
+	ts := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	message := &sarama.ConsumerMessage{
+		Key:       []byte("evt-1"),
+		Value:     []byte(`{}`),
+		Timestamp: ts.Add(10 * time.Minute),
+	}
+	event := &models.Event{ID: "evt-1", Type: models.EventAddItem, Timestamp: ts}
+	stampTimes(event, message)
+	return event, message
+}
+
+func TestConsumerLatePolicies(t *testing.T) {
+	event, message := lateEvent()
+	assert.Equal(t, 10*time.Minute, event.Lateness())
+
+	c := &Consumer{latenessThreshold: 5 * time.Minute, latePolicy: LatePolicyFlag, lag: newLagTracker()}
+	assert.True(t, c.isLate(event))
+
+	session := &markingSession{}
+	handled, err := c.handleLate(session, event, message)
+	assert.NoError(t, err)
+	assert.False(t, handled)
+	assert.True(t, event.Late)
+	assert.Empty(t, session.marked)
+
+	// Dropped events are committed without processing
+	event, message = lateEvent()
+	c.latePolicy = LatePolicyDrop
+	handled, err = c.handleLate(session, event, message)
+	assert.NoError(t, err)
+	assert.True(t, handled)
+	assert.False(t, event.Late)
+	assert.Equal(t, []*sarama.ConsumerMessage{message}, session.marked)
+
+	// A zero threshold disables late detection
+	c.latenessThreshold = 0
+	assert.False(t, c.isLate(event))
+}
+
+func TestConsumerRoutesLateEvents(t *testing.T) {
+	sync := mocks.NewSyncProducer(t, nil)
+	sync.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "pos_events_late", msg.Topic)
+		assert.Equal(t, []byte(HeaderLateBy), msg.Headers[0].Key)
+		assert.Equal(t, []byte("10m0s"), msg.Headers[0].Value)
+		return nil
+	})
+
+	c := &Consumer{
+		latenessThreshold: 5 * time.Minute,
+		latePolicy:        LatePolicyRoute,
+		lateTopic:         "pos_events_late",
+		forwarder:         &Producer{producer: sync},
+		lag:               newLagTracker(),
+	}
+
+	event, message := lateEvent()
+	session := &markingSession{}
+	handled, err := c.handleLate(session, event, message)
+	assert.NoError(t, err)
+	assert.True(t, handled)
+	assert.Equal(t, []*sarama.ConsumerMessage{message}, session.marked)
+	assert.NoError(t, sync.Close())
+}
+
+func TestConsumerCommitInterval(t *testing.T) {
+	c := &Consumer{commitInterval: time.Millisecond, lag: newLagTracker(), ready: make(chan bool)}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	session := &markingSession{ctx: ctx}
+	assert.NoError(t, c.Setup(session))
+
+	// Marked offsets are committed on the interval
+	assert.Eventually(t, func() bool {
+		session.mu.Lock()
+		defer session.mu.Unlock()
+		return session.commits > 0
+	}, time.Second, time.Millisecond)
+
+	// and once more when the session ends
+	cancel()
+	committed := func() int {
+		session.mu.Lock()
+		defer session.mu.Unlock()
+		return session.commits
+	}
+	c.committer.Wait()
+	before := committed()
+	assert.NoError(t, c.Cleanup(session))
+	assert.Equal(t, before+1, committed())
+}
+
+func TestConsumerDeliveryModes(t *testing.T) {
+	value, err := JSONCodec{}.Encode(testEvents()[0])
+	assert.NoError(t, err)
+	message := &sarama.ConsumerMessage{Topic: "pos_events", Value: value}
+
+	var marked int
+	session := &markingSession{}
+	c := &Consumer{
+		lag:            newLagTracker(),
+		commitInterval: time.Second,
+		handler: func(context.Context, *models.Event) error {
+			marked = len(session.marked)
+			return nil
+		},
+	}
+
+	// At-least-once marks after processing and leaves the commit to the interval
+	assert.NoError(t, c.process(session, message))
+	assert.Zero(t, marked)
+	assert.Len(t, session.marked, 1)
+	assert.Zero(t, session.commits)
+
+	// At-most-once commits before processing
+	c.delivery = DeliveryAtMostOnce
+	session = &markingSession{}
+	assert.NoError(t, c.process(session, message))
+	assert.Equal(t, 1, marked)
+	assert.Equal(t, 1, session.commits)
+
+	// Without an interval every message is committed right away
+	c.delivery, c.commitInterval = DeliveryAtLeastOnce, 0
+	session = &markingSession{}
+	assert.NoError(t, c.process(session, message))
+	assert.Equal(t, 1, session.commits)
+}
+
+func TestConsumerIdentifiesDeliveries(t *testing.T) {
+	value, err := JSONCodec{}.Encode(testEvents()[0])
+	assert.NoError(t, err)
+	message := &sarama.ConsumerMessage{Topic: "pos_events", Partition: 1, Offset: 42, Value: value}
+
+	var deliveries []string
+	c := &Consumer{
+		group: "group",
+		lag:   newLagTracker(),
+		handler: func(ctx context.Context, _ *models.Event) error {
+			deliveries = append(deliveries, eventctx.DeliveryFromContext(ctx))
+			if len(deliveries) == 1 {
+				return assert.AnError
+			}
+			return nil
+		},
+		retryAttempts: 1,
+		retryDelay:    time.Millisecond,
+	}
+
+	// Retries and redeliveries of the message share its delivery
+	assert.NoError(t, c.process(&markingSession{}, message))
+	assert.NoError(t, c.process(&markingSession{}, message))
+	assert.Equal(t, []string{"group/pos_events/1/42", "group/pos_events/1/42", "group/pos_events/1/42"}, deliveries)
+}
+
+func TestConsumerDoesNotCommitPastFailures(t *testing.T) {
+	events := testEvents()[:2]
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	var handled []string
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	c := &Consumer{
+		handler: func(_ context.Context, event *models.Event) error {
+			handled = append(handled, event.ID)
+			if event.ID == events[0].ID {
+				return Permanent(assert.AnError)
+			}
+			return nil
+		},
+		commitInterval:  time.Hour,
+		deadLetterTopic: "pos_events_dlq",
+		forwarder:       &Producer{producer: producer},
+		lag:             newLagTracker(),
+		endSession:      cancel,
+	}
+
+	// A message that could not be dead-lettered is neither marked nor
+	// committed at the end of the session
+	session := &markingSession{ctx: ctx}
+	assert.NoError(t, c.ConsumeClaim(session, newMessageClaim(t, events...)))
+	assert.NoError(t, c.Cleanup(session))
+	assert.Equal(t, []string{events[0].ID}, handled)
+	assert.Empty(t, session.marked)
+	assert.NoError(t, producer.Close())
+
+	// Neither is a message interrupted by a shutdown, and the messages
+	// after it are left for the next session
+	handled = nil
+	ctx, cancel = context.WithCancel(t.Context())
+	defer cancel()
+	c.handler = func(ctx context.Context, event *models.Event) error {
+		handled = append(handled, event.ID)
+		if event.ID == events[0].ID {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}
+	session = &markingSession{ctx: ctx}
+	assert.NoError(t, c.ConsumeClaim(session, newMessageClaim(t, events...)))
+	assert.Equal(t, []string{events[0].ID}, handled)
+	assert.Empty(t, session.marked)
+}
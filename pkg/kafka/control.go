@@ -0,0 +1,296 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// PauseState reports which partitions the consumer does not process
+type PauseState struct {
+	// All is set when every partition is paused, including ones assigned later
+	All        bool             `json:"all"`
+	Partitions []TopicPartition `json:"partitions"`
+}
+
+// OffsetReset moves the consumer group of a topic to an offset, or to the
+// first message at or after a timestamp
+type OffsetReset struct {
+	Topic string `json:"topic"`
+	// Partition limits the reset to one partition, otherwise all partitions
+	// of the topic consumed by this consumer are reset
+	Partition *int32     `json:"partition,omitempty"`
+	Offset    *int64     `json:"offset,omitempty"`
+	Timestamp *time.Time `json:"timestamp,omitempty"`
+}
+
+// Offset reset errors
+var (
+	// ErrInvalidReset is returned for malformed resets and offsets a
+	// partition does not hold
+	ErrInvalidReset = errors.New("invalid offset reset")
+	// ErrUnknownPartition is returned for resets of partitions the consumer
+	// does not claim
+	ErrUnknownPartition = errors.New("partition not consumed")
+	// ErrResetNotApplied is returned when the partitions were reassigned
+	// before a reset was applied
+	ErrResetNotApplied = errors.New("offset reset not applied")
+)
+
+// Validate checks that the reset names a topic and exactly one target
+func (r OffsetReset) Validate() error {
+	if r.Topic == "" {
+		return fmt.Errorf("%w: topic is required", ErrInvalidReset)
+	}
+	if (r.Offset == nil) == (r.Timestamp == nil) {
+		return fmt.Errorf("%w: exactly one of offset and timestamp is required", ErrInvalidReset)
+	}
+	if r.Offset != nil && *r.Offset < 0 {
+		return fmt.Errorf("%w: offset must not be negative", ErrInvalidReset)
+	}
+	return nil
+}
+
+func (r OffsetReset) matches(topic string, partition int32) bool {
+	return r.Topic == topic && (r.Partition == nil || *r.Partition == partition)
+}
+
+// offsetResolver looks up the offsets of a partition. OffsetForTime finds
+// the first message at or after a time, or the high-water mark if there is
+// none. OffsetRange returns the oldest offset still held and the high-water
+// mark.
+type offsetResolver interface {
+	OffsetForTime(topic string, partition int32, t time.Time) (int64, error)
+	OffsetRange(topic string, partition int32) (oldest, newest int64, err error)
+}
+
+// pauser tracks paused partitions. The zero value has nothing paused.
+type pauser struct {
+	mu         sync.Mutex
+	all        bool
+	partitions map[TopicPartition]bool
+	// changed is closed and replaced when partitions are resumed
+	changed chan struct{}
+}
+
+func (p *pauser) pause(partitions []TopicPartition) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(partitions) == 0 {
+		p.all = true
+		return
+	}
+	if p.partitions == nil {
+		p.partitions = make(map[TopicPartition]bool)
+	}
+	for _, tp := range partitions {
+		p.partitions[tp] = true
+	}
+}
+
+// resume resumes the given partitions, or everything without any
+func (p *pauser) resume(partitions []TopicPartition) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(partitions) == 0 {
+		p.all = false
+		clear(p.partitions)
+	}
+	for _, tp := range partitions {
+		delete(p.partitions, tp)
+	}
+	if p.changed != nil {
+		close(p.changed)
+		p.changed = nil
+	}
+}
+
+func (p *pauser) state() PauseState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	state := PauseState{All: p.all, Partitions: []TopicPartition{}}
+	for tp := range p.partitions {
+		state.Partitions = append(state.Partitions, tp)
+	}
+	slices.SortFunc(state.Partitions, compareTopicPartitions)
+	return state
+}
+
+// wait blocks while the partition is paused. It returns false if the context
+// ended first.
+func (p *pauser) wait(ctx context.Context, tp TopicPartition) bool {
+	for {
+		p.mu.Lock()
+		if !p.all && !p.partitions[tp] {
+			p.mu.Unlock()
+			return true
+		}
+		if p.changed == nil {
+			p.changed = make(chan struct{})
+		}
+		changed := p.changed
+		p.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// Pause stops processing the given partitions, or all partitions without
+// any, until they are resumed. Messages already being processed complete.
+func (c *Consumer) Pause(partitions ...TopicPartition) {
+	c.pauses.pause(partitions)
+	log.Printf("Paused consumption of %s", describePartitions(partitions))
+}
+
+// Resume continues processing the given partitions, or all partitions
+// without any. Resuming single partitions does not undo pausing all.
+func (c *Consumer) Resume(partitions ...TopicPartition) {
+	c.pauses.resume(partitions)
+	log.Printf("Resumed consumption of %s", describePartitions(partitions))
+}
+
+// Paused returns the paused partitions
+func (c *Consumer) Paused() PauseState {
+	return c.pauses.state()
+}
+
+func describePartitions(partitions []TopicPartition) string {
+	if len(partitions) == 0 {
+		return "all partitions"
+	}
+	return fmt.Sprint(partitions)
+}
+
+// ResetOffsets rewinds or skips the consumer group to the given position.
+// The target partitions and offsets are checked against the partitions this
+// consumer claims and the offsets they hold. The current session ends and
+// ResetOffsets waits until the next one applied the reset, so messages in
+// flight are committed first.
+func (c *Consumer) ResetOffsets(ctx context.Context, reset OffsetReset) error {
+	if err := reset.Validate(); err != nil {
+		return err
+	}
+	resolver, ok := c.transport.(offsetResolver)
+	if !ok {
+		return errors.New("the transport cannot look up offsets")
+	}
+
+	var targets []TopicPartition
+	for _, tp := range c.lag.claimed() {
+		if reset.matches(tp.Topic, tp.Partition) {
+			targets = append(targets, tp)
+		}
+	}
+	if len(targets) == 0 {
+		if reset.Partition != nil {
+			return fmt.Errorf("%w: %s/%d", ErrUnknownPartition, reset.Topic, *reset.Partition)
+		}
+		return fmt.Errorf("%w: %s", ErrUnknownPartition, reset.Topic)
+	}
+
+	pending := &pendingReset{offsets: make(map[TopicPartition]int64), done: make(chan error, 1)}
+	for _, tp := range targets {
+		offset, err := resetOffset(resolver, reset, tp)
+		if err != nil {
+			return err
+		}
+		pending.offsets[tp] = offset
+	}
+
+	c.sessionMu.Lock()
+	c.resets = append(c.resets, pending)
+	c.sessionMu.Unlock()
+	c.restartSession()
+
+	select {
+	case err := <-pending.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pendingReset holds the offsets a reset moves partitions to until the next
+// session applied them
+type pendingReset struct {
+	offsets map[TopicPartition]int64
+	done    chan error
+}
+
+// applyResets resets the claimed partitions to the requested offsets at the
+// start of a session, before their messages are fetched. Resets of
+// partitions the session did not claim fail, as another consumer may be
+// processing them.
+func (c *Consumer) applyResets(session sarama.ConsumerGroupSession) {
+	c.sessionMu.Lock()
+	resets := c.resets
+	c.resets = nil
+	c.sessionMu.Unlock()
+	if len(resets) == 0 {
+		return
+	}
+
+	claimed := make(map[TopicPartition]bool)
+	for topic, partitions := range session.Claims() {
+		for _, partition := range partitions {
+			claimed[TopicPartition{Topic: topic, Partition: partition}] = true
+		}
+	}
+
+	for _, reset := range resets {
+		var unclaimed []TopicPartition
+		for tp, offset := range reset.offsets {
+			if !claimed[tp] {
+				unclaimed = append(unclaimed, tp)
+				continue
+			}
+			// Sessions only reset to offsets up to the current one and
+			// only mark offsets after it, so one of the two moves the
+			// partition whether it is rewound or skipped ahead
+			session.ResetOffset(tp.Topic, tp.Partition, offset, "")
+			session.MarkOffset(tp.Topic, tp.Partition, offset, "")
+			c.lag.reset(tp, offset)
+			log.Printf("Reset %s/%d to offset %d", tp.Topic, tp.Partition, offset)
+		}
+
+		if len(unclaimed) > 0 {
+			slices.SortFunc(unclaimed, compareTopicPartitions)
+			log.Printf("Offset reset not applied to %v, the partitions were reassigned", unclaimed)
+			reset.done <- fmt.Errorf("%w: %v", ErrResetNotApplied, unclaimed)
+			continue
+		}
+		reset.done <- nil
+	}
+	session.Commit()
+}
+
+// resetOffset returns the offset a partition is reset to, which has to be
+// within the offsets the partition holds
+func resetOffset(resolver offsetResolver, reset OffsetReset, tp TopicPartition) (int64, error) {
+	if reset.Timestamp != nil {
+		return resolver.OffsetForTime(tp.Topic, tp.Partition, *reset.Timestamp)
+	}
+
+	oldest, newest, err := resolver.OffsetRange(tp.Topic, tp.Partition)
+	if err != nil {
+		return 0, err
+	}
+	if *reset.Offset < oldest || *reset.Offset > newest {
+		return 0, fmt.Errorf("%w: offset %d of %s/%d is outside of %d to %d",
+			ErrInvalidReset, *reset.Offset, tp.Topic, tp.Partition, oldest, newest)
+	}
+	return *reset.Offset, nil
+}
@@ -0,0 +1,215 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// runConsumer starts a consumer on the memory transport and returns the
+// channel of handled events
+func runConsumer(t *testing.T, cfg *Config) (*Consumer, <-chan *models.Event) {
+	received := make(chan *models.Event, 100)
+	consumer, err := NewConsumer(cfg, func(_ context.Context, event *models.Event) error {
+		received <- event
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		consumer.Close()
+	})
+	return consumer, received
+}
+
+func receive(t *testing.T, received <-chan *models.Event, n int) []string {
+	var ids []string
+	for len(ids) < n {
+		select {
+		case event := <-received:
+			ids = append(ids, event.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d events", len(ids), n)
+		}
+	}
+	return ids
+}
+
+func TestOffsetResetValidate(t *testing.T) {
+	offset, negative := int64(3), int64(-1)
+	now := time.Now()
+
+	assert.NoError(t, OffsetReset{Topic: "pos_events", Offset: &offset}.Validate())
+	assert.NoError(t, OffsetReset{Topic: "pos_events", Timestamp: &now}.Validate())
+	assert.Error(t, OffsetReset{Offset: &offset}.Validate())
+	assert.Error(t, OffsetReset{Topic: "pos_events"}.Validate())
+	assert.Error(t, OffsetReset{Topic: "pos_events", Offset: &offset, Timestamp: &now}.Validate())
+	assert.Error(t, OffsetReset{Topic: "pos_events", Offset: &negative}.Validate())
+}
+
+func TestPauser(t *testing.T) {
+	var p pauser
+	tp := TopicPartition{Topic: "pos_events", Partition: 1}
+	assert.True(t, p.wait(t.Context(), tp))
+
+	p.pause([]TopicPartition{tp})
+	assert.Equal(t, PauseState{Partitions: []TopicPartition{tp}}, p.state())
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	assert.False(t, p.wait(ctx, tp))
+
+	resumed := make(chan bool)
+	go func() { resumed <- p.wait(t.Context(), tp) }()
+	p.resume([]TopicPartition{tp})
+	assert.True(t, <-resumed)
+
+	// Resuming a partition does not undo pausing all of them
+	p.pause(nil)
+	p.resume([]TopicPartition{tp})
+	assert.True(t, p.state().All)
+	p.resume(nil)
+	assert.Equal(t, PauseState{Partitions: []TopicPartition{}}, p.state())
+}
+
+func TestConsumerPauseAndResume(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	consumer, received := runConsumer(t, cfg)
+	consumer.Pause()
+
+	event := testEvents()[0]
+	assert.NoError(t, producer.SendEvent(t.Context(), event))
+	select {
+	case <-received:
+		t.Fatal("paused consumer handled an event")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	consumer.Resume()
+	assert.Equal(t, []string{event.ID}, receive(t, received, 1))
+}
+
+func TestConsumerResetOffsets(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	cfg.Partitions = 1
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	events := testEvents()[:3]
+	for _, event := range events {
+		assert.NoError(t, producer.SendEvent(t.Context(), event))
+	}
+	consumer, received := runConsumer(t, cfg)
+	first := receive(t, received, len(events))
+
+	// Rewinding replays the events from the offset on
+	offset := int64(1)
+	assert.NoError(t, consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Offset: &offset}))
+	assert.Equal(t, first[1:], receive(t, received, 2))
+
+	// Skipping ahead drops the events before the offset
+	consumer.Pause()
+	more := testEvents()[3:6]
+	for _, event := range more {
+		assert.NoError(t, producer.SendEvent(t.Context(), event))
+	}
+	offset = 5
+	assert.NoError(t, consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Offset: &offset}))
+	consumer.Resume()
+	assert.Equal(t, []string{more[2].ID}, receive(t, received, 1))
+
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Timestamp: &later}))
+	select {
+	case event := <-received:
+		t.Fatalf("handled event %s after skipping to the end", event.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestConsumerRejectsInvalidResets(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	cfg.Partitions = 1
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	events := testEvents()[:3]
+	for _, event := range events {
+		assert.NoError(t, producer.SendEvent(t.Context(), event))
+	}
+	consumer, received := runConsumer(t, cfg)
+	receive(t, received, len(events))
+
+	offset, partition := int64(1), int32(1)
+	err = consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_other", Offset: &offset})
+	assert.ErrorIs(t, err, ErrUnknownPartition)
+	err = consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Partition: &partition, Offset: &offset})
+	assert.ErrorIs(t, err, ErrUnknownPartition)
+
+	// The offset has to be held by the partition
+	offset = 4
+	err = consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Offset: &offset})
+	assert.ErrorIs(t, err, ErrInvalidReset)
+	offset = 3
+	assert.NoError(t, consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Offset: &offset}))
+}
+
+func TestConsumerReportsUnappliedResets(t *testing.T) {
+	c := &Consumer{lag: newLagTracker()}
+	reset := &pendingReset{offsets: map[TopicPartition]int64{{Topic: "pos_events", Partition: 0}: 1}, done: make(chan error, 1)}
+	c.resets = []*pendingReset{reset}
+
+	// The session lost the partition before the reset was applied
+	c.applyResets(&markingSession{})
+	assert.ErrorIs(t, <-reset.done, ErrResetNotApplied)
+	assert.Empty(t, c.resets)
+}
+
+func TestMemoryBrokerOffsetForTime(t *testing.T) {
+	broker := NewMemoryBroker()
+	transport := broker.Transport("group", 1)
+	start := time.Now()
+	for i := range 3 {
+		assert.NoError(t, transport.Publish(t.Context(), &sarama.ProducerMessage{
+			Topic:     "pos_events",
+			Value:     sarama.StringEncoder("{}"),
+			Timestamp: start.Add(time.Duration(i) * time.Minute),
+		}))
+	}
+
+	for at, want := range map[time.Time]int64{
+		start.Add(-time.Minute):     0,
+		start.Add(30 * time.Second): 1,
+		start.Add(time.Hour):        3,
+	} {
+		offset, err := broker.offsetForTime("pos_events", 0, at)
+		assert.NoError(t, err)
+		assert.Equal(t, want, offset)
+	}
+}
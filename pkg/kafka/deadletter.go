@@ -0,0 +1,93 @@
+package kafka
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Headers added to messages sent to the dead-letter topic
+const (
+	HeaderDLQError             = "x-dlq-error"
+	HeaderDLQReason            = "x-dlq-reason"
+	HeaderDLQPlugins           = "x-dlq-plugins"
+	HeaderDLQAttempts          = "x-dlq-attempts"
+	HeaderDLQOriginalTopic     = "x-dlq-original-topic"
+	HeaderDLQOriginalPartition = "x-dlq-original-partition"
+	HeaderDLQOriginalOffset    = "x-dlq-original-offset"
+	HeaderDLQOriginalTimestamp = "x-dlq-original-timestamp"
+	HeaderDLQFailedAt          = "x-dlq-failed-at"
+)
+
+// Reasons for dead-lettering a message
+const (
+	// DLQReasonDecode marks messages that could not be decoded into an event
+	DLQReasonDecode = "decode"
+	// DLQReasonHandler marks events the handler failed to process
+	DLQReasonHandler = "handler"
+)
+
+// deadLetter describes why a message is sent to the dead-letter topic
+type deadLetter struct {
+	reason   string
+	err      error
+	attempts int
+}
+
+// headers returns the headers describing the failure of the given message
+func (d deadLetter) headers(message *sarama.ConsumerMessage, failedAt time.Time) []sarama.RecordHeader {
+	attrs := [][2]string{
+		{HeaderDLQError, d.err.Error()},
+		{HeaderDLQReason, d.reason},
+		{HeaderDLQAttempts, strconv.Itoa(d.attempts)},
+		{HeaderDLQOriginalTopic, message.Topic},
+		{HeaderDLQOriginalPartition, strconv.FormatInt(int64(message.Partition), 10)},
+		{HeaderDLQOriginalOffset, strconv.FormatInt(message.Offset, 10)},
+		{HeaderDLQFailedAt, failedAt.UTC().Format(time.RFC3339Nano)},
+	}
+	if plugins := failedPlugins(d.err); len(plugins) > 0 {
+		attrs = append(attrs, [2]string{HeaderDLQPlugins, strings.Join(plugins, ",")})
+	}
+	if !message.Timestamp.IsZero() {
+		attrs = append(attrs, [2]string{HeaderDLQOriginalTimestamp, message.Timestamp.UTC().Format(time.RFC3339Nano)})
+	}
+
+	headers := make([]sarama.RecordHeader, 0, len(attrs))
+	for _, attr := range attrs {
+		headers = append(headers, sarama.RecordHeader{Key: []byte(attr[0]), Value: []byte(attr[1])})
+	}
+	return headers
+}
+
+// failedPlugins collects the names of the plugins that caused an error. Plugin
+// errors are recognized by a PluginName method so this package does not
+// depend on the plugin implementation.
+func failedPlugins(err error) []string {
+	var names []string
+	seen := make(map[string]bool)
+
+	var walk func(error)
+	walk = func(err error) {
+		if err == nil {
+			return
+		}
+		if p, ok := err.(interface{ PluginName() string }); ok && !seen[p.PluginName()] {
+			seen[p.PluginName()] = true
+			names = append(names, p.PluginName())
+		}
+		switch e := err.(type) {
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				walk(inner)
+			}
+		default:
+			walk(errors.Unwrap(err))
+		}
+	}
+
+	walk(err)
+	return names
+}
@@ -0,0 +1,197 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// pluginError mimics the plugin errors returned by the server handler
+type pluginError struct {
+	name string
+}
+
+func (e *pluginError) Error() string      { return "plugin " + e.name + " failed" }
+func (e *pluginError) PluginName() string { return e.name }
+
+// markingSession records the messages marked as consumed and the commits
+type markingSession struct {
+	sarama.ConsumerGroupSession
+	ctx     context.Context
+	mu      sync.Mutex
+	marked  []*sarama.ConsumerMessage
+	commits int
+}
+
+func (s *markingSession) MarkMessage(msg *sarama.ConsumerMessage, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.marked = append(s.marked, msg)
+}
+
+func (s *markingSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits++
+}
+
+func (s *markingSession) Claims() map[string][]int32 {
+	return nil
+}
+
+func (s *markingSession) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+func TestFailedPlugins(t *testing.T) {
+	err := errors.Join(
+		&pluginError{name: "customer_lookup"},
+		fmt.Errorf("generated event: %w", errors.Join(
+			&pluginError{name: "purchase_recommender"},
+			&pluginError{name: "customer_lookup"},
+		)),
+	)
+	assert.Equal(t, []string{"customer_lookup", "purchase_recommender"}, failedPlugins(err))
+	assert.Empty(t, failedPlugins(errors.New("malformed")))
+}
+
+func TestConsumerDeadLettersFailedMessages(t *testing.T) {
+	failedAt := time.Date(2024, 1, 1, 10, 0, 1, 0, time.UTC)
+	message := &sarama.ConsumerMessage{
+		Topic:     "pos_events",
+		Partition: 2,
+		Offset:    42,
+		Key:       []byte("evt-1"),
+		Value:     []byte(`{"id": `),
+		Timestamp: time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		Headers:   []*sarama.RecordHeader{{Key: []byte(HeaderContentType), Value: []byte(ContentTypeJSON)}},
+	}
+	dl := deadLetter{reason: DLQReasonHandler, err: &pluginError{name: "customer_lookup"}, attempts: 3}
+
+	headers := make(map[string]string)
+	for _, h := range dl.headers(message, failedAt) {
+		headers[string(h.Key)] = string(h.Value)
+	}
+	assert.Equal(t, map[string]string{
+		HeaderDLQError:             "plugin customer_lookup failed",
+		HeaderDLQReason:            DLQReasonHandler,
+		HeaderDLQPlugins:           "customer_lookup",
+		HeaderDLQAttempts:          "3",
+		HeaderDLQOriginalTopic:     "pos_events",
+		HeaderDLQOriginalPartition: "2",
+		HeaderDLQOriginalOffset:    "42",
+		HeaderDLQOriginalTimestamp: "2024-01-01T10:00:00Z",
+		HeaderDLQFailedAt:          "2024-01-01T10:00:01Z",
+	}, headers)
+
+	// The original bytes and headers are kept
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "pos_events_dlq", msg.Topic)
+		value, _ := msg.Value.Encode()
+		assert.Equal(t, message.Value, value)
+		assert.Equal(t, []byte(HeaderContentType), msg.Headers[0].Key)
+		return nil
+	})
+
+	c := &Consumer{deadLetterTopic: "pos_events_dlq", forwarder: &Producer{producer: producer}, lag: newLagTracker()}
+	session := &markingSession{}
+	assert.NoError(t, c.sendToDeadLetter(session, message, dl))
+	assert.Equal(t, []*sarama.ConsumerMessage{message}, session.marked)
+
+	// Messages are not marked when the dead-letter topic is unavailable
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	session = &markingSession{}
+	assert.Error(t, c.sendToDeadLetter(session, message, dl))
+	assert.Empty(t, session.marked)
+
+	assert.NoError(t, producer.Close())
+}
+
+// messageClaim delivers a fixed set of messages of one partition
+type messageClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func newMessageClaim(t *testing.T, events ...*models.Event) *messageClaim {
+	claim := &messageClaim{messages: make(chan *sarama.ConsumerMessage, len(events))}
+	for i, event := range events {
+		value, err := JSONCodec{}.Encode(event)
+		assert.NoError(t, err)
+		claim.messages <- &sarama.ConsumerMessage{Topic: "pos_events", Offset: int64(i), Value: value}
+	}
+	close(claim.messages)
+	return claim
+}
+
+func (c *messageClaim) Topic() string                            { return "pos_events" }
+func (c *messageClaim) Partition() int32                         { return 0 }
+func (c *messageClaim) HighWaterMarkOffset() int64               { return int64(cap(c.messages)) }
+func (c *messageClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func TestConsumerRedeliversWhenDeadLetteringFails(t *testing.T) {
+	events := testEvents()[:2]
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	var handled []string
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	c := &Consumer{
+		handler: func(_ context.Context, event *models.Event) error {
+			handled = append(handled, event.ID)
+			if event.ID == events[0].ID {
+				return Permanent(assert.AnError)
+			}
+			return nil
+		},
+		retryAttempts:   1,
+		retryDelay:      time.Millisecond,
+		deadLetterTopic: "pos_events_dlq",
+		forwarder:       &Producer{producer: producer},
+		lag:             newLagTracker(),
+		endSession:      cancel,
+	}
+
+	// The next message is not processed, so its commit cannot skip the
+	// failed one, and the session ends to redeliver both
+	session := &markingSession{ctx: ctx}
+	assert.NoError(t, c.ConsumeClaim(session, newMessageClaim(t, events...)))
+	assert.Empty(t, session.marked)
+	assert.Equal(t, []string{events[0].ID}, handled)
+	assert.Error(t, ctx.Err())
+
+	assert.NoError(t, producer.Close())
+}
+
+func TestConsumerDropsFailedMessages(t *testing.T) {
+	events := testEvents()[:2]
+	c := &Consumer{
+		handler: func(_ context.Context, event *models.Event) error {
+			if event.ID == events[0].ID {
+				return Permanent(assert.AnError)
+			}
+			return nil
+		},
+		dropFailed: true,
+		lag:        newLagTracker(),
+	}
+
+	session := &markingSession{}
+	assert.NoError(t, c.ConsumeClaim(session, newMessageClaim(t, events...)))
+	assert.Len(t, session.marked, 2)
+	assert.Equal(t, int64(1), c.Dropped())
+}
@@ -0,0 +1,232 @@
+package kafka
+
+import (
+	"cmp"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// PartitionLag is how far the consumer is behind on a partition
+type PartitionLag struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	// HighWaterMark is the offset the next produced message will get
+	HighWaterMark int64 `json:"highWaterMark"`
+	// Committed is the offset the consumer group committed, -1 until the
+	// offsets are fetched from the transport
+	Committed int64 `json:"committed"`
+	// Marked is the offset of the next message the consumer will process,
+	// ahead of Committed until the next commit, -1 until the first message
+	// is marked
+	Marked int64 `json:"marked"`
+	Lag    int64 `json:"lag"`
+}
+
+// TopicPartition identifies a partition of a topic
+type TopicPartition struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+}
+
+// compareTopicPartitions orders partitions by topic and partition
+func compareTopicPartitions(a, b TopicPartition) int {
+	return cmp.Or(cmp.Compare(a.Topic, b.Topic), cmp.Compare(a.Partition, b.Partition))
+}
+
+// partitionOffsets are the offsets of a partition read from the transport
+type partitionOffsets struct {
+	highWaterMark int64
+	// committed is the next offset the group consumes, the initial offset
+	// of the consumer if the group committed none
+	committed int64
+}
+
+// offsetFetcher reads the high-water marks and committed offsets of
+// partitions for the transport's consumer group
+type offsetFetcher interface {
+	FetchOffsets(partitions []TopicPartition) (map[TopicPartition]partitionOffsets, error)
+}
+
+// lagTracker tracks high-water marks and consumer offsets of the claimed partitions
+type lagTracker struct {
+	mu         sync.RWMutex
+	partitions map[TopicPartition]*PartitionLag
+}
+
+func newLagTracker() *lagTracker {
+	return &lagTracker{partitions: make(map[TopicPartition]*PartitionLag)}
+}
+
+// assign resets the tracker to the partitions claimed by a new session
+func (t *lagTracker) assign(claims map[string][]int32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	partitions := make(map[TopicPartition]*PartitionLag)
+	for topic, ids := range claims {
+		for _, id := range ids {
+			tp := TopicPartition{Topic: topic, Partition: id}
+			if p, ok := t.partitions[tp]; ok {
+				partitions[tp] = p
+			} else {
+				partitions[tp] = newPartitionLag(tp)
+			}
+		}
+	}
+	t.partitions = partitions
+}
+
+// observe records the high-water mark of a partition when a message is received
+func (t *lagTracker) observe(message *sarama.ConsumerMessage, highWaterMark int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.partition(message)
+	p.HighWaterMark = max(p.HighWaterMark, highWaterMark)
+	if position := max(p.Committed, p.Marked); position >= 0 {
+		p.Lag = max(p.HighWaterMark-position, 0)
+		return
+	}
+	// Nothing committed yet, everything from this message on is pending
+	p.Lag = p.HighWaterMark - message.Offset
+}
+
+// mark records that a message was marked as consumed
+func (t *lagTracker) mark(message *sarama.ConsumerMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p := t.partition(message)
+	p.Marked = message.Offset + 1
+	p.Lag = max(p.HighWaterMark-max(p.Committed, p.Marked), 0)
+}
+
+// reset records that a partition was reset to an offset, which may be
+// behind the offsets marked and committed before
+func (t *lagTracker) reset(tp TopicPartition, offset int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, ok := t.partitions[tp]
+	if !ok {
+		p = newPartitionLag(tp)
+		t.partitions[tp] = p
+	}
+	p.Committed = offset
+	p.Marked = offset
+	p.Lag = max(p.HighWaterMark-offset, 0)
+}
+
+// refresh records offsets fetched from the transport. Partitions that are
+// no longer claimed are ignored.
+func (t *lagTracker) refresh(offsets map[TopicPartition]partitionOffsets) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tp, o := range offsets {
+		p, ok := t.partitions[tp]
+		if !ok {
+			continue
+		}
+		p.HighWaterMark = max(p.HighWaterMark, o.highWaterMark)
+		p.Committed = o.committed
+		p.Lag = max(p.HighWaterMark-max(p.Committed, p.Marked), 0)
+	}
+}
+
+// claimed returns the partitions being tracked
+func (t *lagTracker) claimed() []TopicPartition {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	partitions := make([]TopicPartition, 0, len(t.partitions))
+	for tp := range t.partitions {
+		partitions = append(partitions, tp)
+	}
+	return partitions
+}
+
+func (t *lagTracker) partition(message *sarama.ConsumerMessage) *PartitionLag {
+	tp := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+	p, ok := t.partitions[tp]
+	if !ok {
+		p = newPartitionLag(tp)
+		t.partitions[tp] = p
+	}
+	return p
+}
+
+// topicPartition returns the partition the lag is reported for
+func (p PartitionLag) topicPartition() TopicPartition {
+	return TopicPartition{Topic: p.Topic, Partition: p.Partition}
+}
+
+func newPartitionLag(tp TopicPartition) *PartitionLag {
+	return &PartitionLag{Topic: tp.Topic, Partition: tp.Partition, Committed: -1, Marked: -1}
+}
+
+// snapshot returns the lag of all partitions ordered by topic and partition
+func (t *lagTracker) snapshot() []PartitionLag {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	lags := make([]PartitionLag, 0, len(t.partitions))
+	for _, p := range t.partitions {
+		lags = append(lags, *p)
+	}
+	slices.SortFunc(lags, func(a, b PartitionLag) int {
+		return compareTopicPartitions(a.topicPartition(), b.topicPartition())
+	})
+	return lags
+}
+
+// lagLoop fetches the offsets of the claimed partitions on the lag interval
+// until the session ends, so the lag keeps growing while no messages are
+// processed, for example during retries or while partitions are paused
+func (c *Consumer) lagLoop(session sarama.ConsumerGroupSession, fetcher offsetFetcher) {
+	ticker := time.NewTicker(c.lagInterval)
+	defer ticker.Stop()
+
+	for {
+		offsets, err := fetcher.FetchOffsets(c.lag.claimed())
+		if err != nil {
+			log.Printf("Error fetching consumer offsets: %v", err)
+		} else {
+			c.lag.refresh(offsets)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-session.Context().Done():
+			return
+		}
+	}
+}
+
+// Lag returns the lag of each partition claimed by the consumer
+func (c *Consumer) Lag() []PartitionLag {
+	return c.lag.snapshot()
+}
+
+// TotalLag returns the number of messages the consumer is behind
+func (c *Consumer) TotalLag() int64 {
+	var total int64
+	for _, p := range c.lag.snapshot() {
+		total += p.Lag
+	}
+	return total
+}
+
+// LagThreshold returns the total lag above which the consumer is unhealthy
+func (c *Consumer) LagThreshold() int64 {
+	return c.lagThreshold
+}
+
+// Healthy reports whether the consumer keeps up, a zero threshold disables the check
+func (c *Consumer) Healthy() bool {
+	return c.lagThreshold <= 0 || c.TotalLag() <= c.lagThreshold
+}
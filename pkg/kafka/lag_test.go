@@ -0,0 +1,113 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsumerLag(t *testing.T) {
+	c := &Consumer{lag: newLagTracker(), lagThreshold: 50}
+	c.lag.assign(map[string][]int32{"pos_events": {1, 0}})
+
+	// Partitions are reported before any message arrives
+	assert.Equal(t, []PartitionLag{
+		{Topic: "pos_events", Partition: 0, Committed: -1, Marked: -1},
+		{Topic: "pos_events", Partition: 1, Committed: -1, Marked: -1},
+	}, c.Lag())
+
+	first := &sarama.ConsumerMessage{Topic: "pos_events", Partition: 0, Offset: 10}
+	c.lag.observe(first, 110)
+	assert.Equal(t, int64(100), c.TotalLag())
+	assert.False(t, c.Healthy())
+
+	c.lag.mark(first)
+	second := &sarama.ConsumerMessage{Topic: "pos_events", Partition: 0, Offset: 80}
+	c.lag.observe(second, 110)
+	c.lag.mark(second)
+	assert.Equal(t, PartitionLag{
+		Topic:         "pos_events",
+		Partition:     0,
+		HighWaterMark: 110,
+		Committed:     -1,
+		Marked:        81,
+		Lag:           29,
+	}, c.Lag()[0])
+	assert.True(t, c.Healthy())
+
+	// Fetched offsets update the lag without new messages, partitions that
+	// are not claimed are ignored
+	c.lag.refresh(map[TopicPartition]partitionOffsets{
+		{Topic: "pos_events", Partition: 0}: {highWaterMark: 150, committed: 60},
+		{Topic: "pos_events", Partition: 2}: {highWaterMark: 10, committed: 0},
+	})
+	assert.Equal(t, PartitionLag{
+		Topic:         "pos_events",
+		Partition:     0,
+		HighWaterMark: 150,
+		Committed:     60,
+		Marked:        81,
+		Lag:           69,
+	}, c.Lag()[0])
+	assert.Len(t, c.Lag(), 2)
+
+	// Revoked partitions are no longer reported
+	c.lag.assign(map[string][]int32{"pos_events": {1}})
+	assert.Len(t, c.Lag(), 1)
+	assert.Zero(t, c.TotalLag())
+
+	c.lagThreshold = 0
+	assert.True(t, c.Healthy())
+}
+
+func TestConsumerLagWhilePaused(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	cfg.Partitions = 1
+	cfg.LagInterval = 10 * time.Millisecond
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	consumer, _ := runConsumer(t, cfg)
+	consumer.Pause()
+
+	// The lag grows while nothing is consumed
+	for _, event := range testEvents()[:3] {
+		assert.NoError(t, producer.SendEvent(t.Context(), event))
+	}
+	assert.Eventually(t, func() bool {
+		lags := consumer.Lag()
+		return len(lags) == 1 && lags[0].HighWaterMark == 3 && lags[0].Committed == 0 && lags[0].Lag == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestConsumerLagAfterRewind(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	cfg.Partitions = 1
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	events := testEvents()[:3]
+	for _, event := range events {
+		assert.NoError(t, producer.SendEvent(t.Context(), event))
+	}
+	consumer, received := runConsumer(t, cfg)
+	receive(t, received, len(events))
+	assert.Eventually(t, func() bool { return consumer.TotalLag() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	// The replayed events are pending again
+	consumer.Pause()
+	offset := int64(0)
+	assert.NoError(t, consumer.ResetOffsets(t.Context(), OffsetReset{Topic: "pos_events", Offset: &offset}))
+	assert.Eventually(t, func() bool {
+		lags := consumer.Lag()
+		return len(lags) == 1 && lags[0].Marked == 0 && lags[0].Committed == 0 && lags[0].Lag == 3
+	}, 5*time.Second, 10*time.Millisecond)
+}
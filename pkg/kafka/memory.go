@@ -0,0 +1,550 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// defaultMemoryBroker is shared by all memory transports of the process that
+// do not configure their own broker
+var defaultMemoryBroker = NewMemoryBroker()
+
+// MemoryBroker is an in-process broker with partitioned topics, consumer
+// groups and committed offsets. Messages are kept for the lifetime of the
+// broker.
+type MemoryBroker struct {
+	mu     sync.Mutex
+	topics map[string][][]*sarama.ConsumerMessage
+	groups map[string]*memoryGroup
+	// published is closed and replaced whenever messages are published
+	published chan struct{}
+	members   int
+}
+
+// memoryGroup is a consumer group of a MemoryBroker
+type memoryGroup struct {
+	// offsets holds the next offset to consume of each partition
+	offsets map[string]map[int32]int64
+	// members maps member IDs to their subscribed topics
+	members    map[string][]string
+	generation int32
+	// rebalance is closed and replaced when the members change
+	rebalance chan struct{}
+}
+
+// NewMemoryBroker creates an empty broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		topics:    make(map[string][][]*sarama.ConsumerMessage),
+		groups:    make(map[string]*memoryGroup),
+		published: make(chan struct{}),
+	}
+}
+
+// Transport returns a transport joining the given consumer group. Topics it
+// creates have the given number of partitions.
+func (b *MemoryBroker) Transport(group string, partitions int) Transport {
+	return &memoryTransport{broker: b, group: group, partitions: max(partitions, 1)}
+}
+
+// CreateTopic creates a topic unless it exists
+func (b *MemoryBroker) CreateTopic(topic string, partitions int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.createTopic(topic, partitions)
+}
+
+func (b *MemoryBroker) createTopic(topic string, partitions int) [][]*sarama.ConsumerMessage {
+	if _, ok := b.topics[topic]; !ok {
+		b.topics[topic] = make([][]*sarama.ConsumerMessage, max(partitions, 1))
+	}
+	return b.topics[topic]
+}
+
+// RefreshMetadata is a no-op, the broker's metadata is always current
+func (b *MemoryBroker) RefreshMetadata(...string) error {
+	return nil
+}
+
+// Topics returns the sorted names of all topics
+func (b *MemoryBroker) Topics() ([]string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	topics := make([]string, 0, len(b.topics))
+	for topic := range b.topics {
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics, nil
+}
+
+// publish appends a message to a partition chosen by hashing its key, as the
+// Kafka producer does
+func (b *MemoryBroker) publish(msg *sarama.ProducerMessage, partitions int) error {
+	message := &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Timestamp: msg.Timestamp,
+	}
+	if message.Timestamp.IsZero() {
+		message.Timestamp = time.Now()
+	}
+
+	var err error
+	if msg.Key != nil {
+		if message.Key, err = msg.Key.Encode(); err != nil {
+			return fmt.Errorf("failed to encode key: %v", err)
+		}
+	}
+	if msg.Value != nil {
+		if message.Value, err = msg.Value.Encode(); err != nil {
+			return fmt.Errorf("failed to encode value: %v", err)
+		}
+	}
+	for _, h := range msg.Headers {
+		message.Headers = append(message.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	logs := b.createTopic(msg.Topic, partitions)
+	partition, err := sarama.NewHashPartitioner(msg.Topic).Partition(msg, int32(len(logs)))
+	if err != nil {
+		return fmt.Errorf("failed to choose partition: %v", err)
+	}
+
+	message.Partition = partition
+	message.Offset = int64(len(logs[partition]))
+	logs[partition] = append(logs[partition], message)
+	msg.Partition, msg.Offset = message.Partition, message.Offset
+
+	close(b.published)
+	b.published = make(chan struct{})
+	return nil
+}
+
+// join adds or updates a member of a consumer group and returns its claims,
+// the group generation and a channel closed on the next rebalance
+func (b *MemoryBroker) join(group, member string, topics []string, partitions int) (map[string][]int32, int32, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g := b.group(group)
+	if current, ok := g.members[member]; !ok || !slices.Equal(current, topics) {
+		g.members[member] = topics
+		for _, topic := range topics {
+			b.createTopic(topic, partitions)
+		}
+		g.bump()
+	}
+
+	// Partitions of a topic are spread round-robin over the members
+	// subscribed to it
+	claims := make(map[string][]int32)
+	for _, topic := range topics {
+		var subscribers []string
+		for id, subscribed := range g.members {
+			if slices.Contains(subscribed, topic) {
+				subscribers = append(subscribers, id)
+			}
+		}
+		slices.Sort(subscribers)
+		index := slices.Index(subscribers, member)
+
+		for partition := range b.topics[topic] {
+			if partition%len(subscribers) == index {
+				claims[topic] = append(claims[topic], int32(partition))
+			}
+		}
+	}
+	return claims, g.generation, g.rebalance
+}
+
+// leave removes a member from its consumer group
+func (b *MemoryBroker) leave(group, member string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g := b.group(group)
+	if _, ok := g.members[member]; ok {
+		delete(g.members, member)
+		g.bump()
+	}
+}
+
+func (b *MemoryBroker) group(name string) *memoryGroup {
+	g, ok := b.groups[name]
+	if !ok {
+		g = &memoryGroup{
+			offsets:   make(map[string]map[int32]int64),
+			members:   make(map[string][]string),
+			rebalance: make(chan struct{}),
+		}
+		b.groups[name] = g
+	}
+	return g
+}
+
+// bump starts a new generation, ending the sessions of the current one
+func (g *memoryGroup) bump() {
+	g.generation++
+	close(g.rebalance)
+	g.rebalance = make(chan struct{})
+}
+
+// commit stores the next offsets to consume of a consumer group
+func (b *MemoryBroker) commit(group string, offsets map[string]map[int32]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	g := b.group(group)
+	for topic, partitions := range offsets {
+		if g.offsets[topic] == nil {
+			g.offsets[topic] = make(map[int32]int64)
+		}
+		for partition, offset := range partitions {
+			g.offsets[topic][partition] = offset
+		}
+	}
+}
+
+// committed returns the next offset a consumer group consumes from a partition
+func (b *MemoryBroker) committed(group, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.group(group).offsets[topic][partition]
+}
+
+// partitionLog returns the messages of a partition, the broker lock must be
+// held
+func (b *MemoryBroker) partitionLog(topic string, partition int32) ([]*sarama.ConsumerMessage, error) {
+	partitions, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(partitions) {
+		return nil, fmt.Errorf("%w: %s/%d", sarama.ErrUnknownTopicOrPartition, topic, partition)
+	}
+	return partitions[partition], nil
+}
+
+// highWaterMark returns the offset of the next message of a partition
+func (b *MemoryBroker) highWaterMark(topic string, partition int32) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log, err := b.partitionLog(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(log)), nil
+}
+
+// fetch returns the message at the given offset, or nil and a channel that
+// is closed once more messages are published
+func (b *MemoryBroker) fetch(topic string, partition int32, offset int64) (*sarama.ConsumerMessage, <-chan struct{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log, err := b.partitionLog(topic, partition)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset < int64(len(log)) {
+		message := *log[offset]
+		return &message, nil, nil
+	}
+	return nil, b.published, nil
+}
+
+// offsetForTime returns the offset of the first message of a partition
+// published at or after the time, or the high-water mark if there is none
+func (b *MemoryBroker) offsetForTime(topic string, partition int32, at time.Time) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	log, err := b.partitionLog(topic, partition)
+	if err != nil {
+		return 0, err
+	}
+	offset, _ := slices.BinarySearchFunc(log, at, func(m *sarama.ConsumerMessage, at time.Time) int {
+		return m.Timestamp.Compare(at)
+	})
+	return int64(offset), nil
+}
+
+// memoryTransport is a Transport of a MemoryBroker
+type memoryTransport struct {
+	broker     *MemoryBroker
+	group      string
+	partitions int
+
+	mu     sync.Mutex
+	member string
+}
+
+func (t *memoryTransport) Publish(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return t.broker.publish(msg, t.partitions)
+}
+
+func (t *memoryTransport) RefreshMetadata(...string) error {
+	return nil
+}
+
+func (t *memoryTransport) Topics() ([]string, error) {
+	return t.broker.Topics()
+}
+
+func (t *memoryTransport) OffsetForTime(topic string, partition int32, at time.Time) (int64, error) {
+	return t.broker.offsetForTime(topic, partition, at)
+}
+
+// OffsetRange returns the offsets of a partition, which keeps all messages
+func (t *memoryTransport) OffsetRange(topic string, partition int32) (int64, int64, error) {
+	hwm, err := t.broker.highWaterMark(topic, partition)
+	if err != nil {
+		return 0, 0, err
+	}
+	return 0, hwm, nil
+}
+
+// FetchOffsets returns the high-water marks and the offsets committed by the
+// transport's group
+func (t *memoryTransport) FetchOffsets(partitions []TopicPartition) (map[TopicPartition]partitionOffsets, error) {
+	offsets := make(map[TopicPartition]partitionOffsets, len(partitions))
+	for _, tp := range partitions {
+		hwm, err := t.broker.highWaterMark(tp.Topic, tp.Partition)
+		if err != nil {
+			return nil, err
+		}
+		offsets[tp] = partitionOffsets{
+			highWaterMark: hwm,
+			committed:     t.broker.committed(t.group, tp.Topic, tp.Partition),
+		}
+	}
+	return offsets, nil
+}
+
+func (t *memoryTransport) Subscribe(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	topics = slices.Sorted(slices.Values(topics))
+	claims, generation, rebalance := t.broker.join(t.group, t.memberID(), topics, t.partitions)
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	session := &memorySession{
+		ctx:        sessionCtx,
+		transport:  t,
+		claims:     claims,
+		generation: generation,
+		marked:     make(map[string]map[int32]int64),
+	}
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			claim := &memoryClaim{
+				broker:    t.broker,
+				topic:     topic,
+				partition: partition,
+				initial:   t.broker.committed(t.group, topic, partition),
+				messages:  make(chan *sarama.ConsumerMessage),
+			}
+
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				claim.feed(sessionCtx)
+			}()
+			go func() {
+				defer wg.Done()
+				handler.ConsumeClaim(session, claim)
+			}()
+		}
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-rebalance:
+	}
+	cancel()
+	wg.Wait()
+	return handler.Cleanup(session)
+}
+
+// memberID returns the ID of the transport in its consumer group, assigned
+// on the first subscription
+func (t *memoryTransport) memberID() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.member == "" {
+		t.broker.mu.Lock()
+		t.broker.members++
+		t.member = fmt.Sprintf("%s-%d", t.group, t.broker.members)
+		t.broker.mu.Unlock()
+	}
+	return t.member
+}
+
+func (t *memoryTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.member != "" {
+		t.broker.leave(t.group, t.member)
+		t.member = ""
+	}
+	return nil
+}
+
+// memorySession implements sarama.ConsumerGroupSession for a MemoryBroker
+type memorySession struct {
+	ctx        context.Context
+	transport  *memoryTransport
+	claims     map[string][]int32
+	generation int32
+
+	mu sync.Mutex
+	// marked holds the offsets to commit on the next Commit
+	marked map[string]map[int32]int64
+}
+
+func (s *memorySession) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *memorySession) MemberID() string {
+	return s.transport.memberID()
+}
+
+func (s *memorySession) GenerationID() int32 {
+	return s.generation
+}
+
+// MarkOffset marks the offset of the next message to consume, ignoring
+// offsets up to the current one
+func (s *memorySession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset <= s.offset(topic, partition) {
+		return
+	}
+	s.mark(topic, partition, offset)
+}
+
+// ResetOffset marks an offset behind the current one. Like sarama's, it
+// ignores offsets after the current one, which have to be marked instead.
+func (s *memorySession) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if offset > s.offset(topic, partition) {
+		return
+	}
+	s.mark(topic, partition, offset)
+}
+
+// offset returns the offset marked for a partition, or the committed one if
+// none was marked in the session
+func (s *memorySession) offset(topic string, partition int32) int64 {
+	if offset, ok := s.marked[topic][partition]; ok {
+		return offset
+	}
+	return s.transport.broker.committed(s.transport.group, topic, partition)
+}
+
+func (s *memorySession) mark(topic string, partition int32, offset int64) {
+	if s.marked[topic] == nil {
+		s.marked[topic] = make(map[int32]int64)
+	}
+	s.marked[topic][partition] = offset
+}
+
+func (s *memorySession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// Commit stores the marked offsets in the consumer group
+func (s *memorySession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.transport.broker.commit(s.transport.group, s.marked)
+}
+
+func (s *memorySession) Context() context.Context {
+	return s.ctx
+}
+
+// memoryClaim implements sarama.ConsumerGroupClaim for a MemoryBroker
+type memoryClaim struct {
+	broker    *MemoryBroker
+	topic     string
+	partition int32
+	initial   int64
+	messages  chan *sarama.ConsumerMessage
+}
+
+func (c *memoryClaim) Topic() string {
+	return c.topic
+}
+
+func (c *memoryClaim) Partition() int32 {
+	return c.partition
+}
+
+func (c *memoryClaim) InitialOffset() int64 {
+	return c.initial
+}
+
+// HighWaterMarkOffset returns the high-water mark of the claimed partition,
+// which exists for as long as the claim
+func (c *memoryClaim) HighWaterMarkOffset() int64 {
+	hwm, _ := c.broker.highWaterMark(c.topic, c.partition)
+	return hwm
+}
+
+func (c *memoryClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// feed delivers the messages of the partition from the initial offset until
+// the session ends
+func (c *memoryClaim) feed(ctx context.Context) {
+	defer close(c.messages)
+
+	offset := c.initial
+	for {
+		message, published, err := c.broker.fetch(c.topic, c.partition, offset)
+		if err != nil {
+			log.Printf("Error fetching %s/%d at offset %d: %v", c.topic, c.partition, offset, err)
+			return
+		}
+		if message == nil {
+			select {
+			case <-published:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case c.messages <- message:
+			offset++
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func memoryConfig(broker *MemoryBroker) *Config {
+	return &Config{
+		Transport:     TransportMemory,
+		MemoryBroker:  broker,
+		Partitions:    2,
+		Topic:         "pos_events",
+		ConsumerGroup: "pos_consumer_group",
+		FailurePolicy: FailurePolicyDrop,
+	}
+}
+
+// consumeEvents runs a consumer until n events were handled
+func consumeEvents(t *testing.T, cfg *Config, n int) []*models.Event {
+	received := make(chan *models.Event, n)
+	consumer, err := NewConsumer(cfg, func(_ context.Context, event *models.Event) error {
+		received <- event
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan error, 1)
+	go func() { done <- consumer.Start(ctx) }()
+
+	var events []*models.Event
+	for len(events) < n {
+		select {
+		case event := <-received:
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("received %d of %d events", len(events), n)
+		}
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-done, context.Canceled)
+	assert.NoError(t, consumer.Close())
+	return events
+}
+
+func TestMemoryTransportEndToEnd(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	events := testEvents()
+	for _, event := range events {
+		assert.NoError(t, producer.SendEvent(t.Context(), event))
+	}
+
+	received := consumeEvents(t, cfg, len(events))
+	var ids []string
+	for _, event := range received {
+		ids = append(ids, event.ID)
+	}
+	for _, event := range events {
+		assert.Contains(t, ids, event.ID)
+	}
+
+	// Committed events are not consumed again by the group
+	extra := testEvents()[0]
+	extra.ID = "after-restart"
+	assert.NoError(t, producer.SendEvent(t.Context(), extra))
+	received = consumeEvents(t, cfg, 1)
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "after-restart", received[0].ID)
+	}
+}
+
+func TestMemoryBrokerPartitionsByKey(t *testing.T) {
+	broker := NewMemoryBroker()
+	transport := broker.Transport("group", 4)
+
+	var partitions []int32
+	for range 3 {
+		msg := &sarama.ProducerMessage{Topic: "pos_events", Key: sarama.StringEncoder("STORE001/POS001"), Value: sarama.StringEncoder("{}")}
+		assert.NoError(t, transport.Publish(t.Context(), msg))
+		partitions = append(partitions, msg.Partition)
+	}
+
+	// Messages with the same key land in order on the same partition
+	assert.Equal(t, partitions[0], partitions[1])
+	assert.Equal(t, partitions[0], partitions[2])
+	hwm, err := broker.highWaterMark("pos_events", partitions[0])
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), hwm)
+
+	topics, err := broker.Topics()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pos_events"}, topics)
+}
+
+func TestMemoryBrokerUnknownPartition(t *testing.T) {
+	broker := NewMemoryBroker()
+	broker.CreateTopic("pos_events", 2)
+	transport := &memoryTransport{broker: broker, group: "group", partitions: 2}
+
+	for _, tp := range []TopicPartition{
+		{Topic: "unknown", Partition: 0},
+		{Topic: "pos_events", Partition: 2},
+		{Topic: "pos_events", Partition: -1},
+	} {
+		_, _, err := transport.OffsetRange(tp.Topic, tp.Partition)
+		assert.ErrorIs(t, err, sarama.ErrUnknownTopicOrPartition)
+		_, err = transport.OffsetForTime(tp.Topic, tp.Partition, time.Now())
+		assert.ErrorIs(t, err, sarama.ErrUnknownTopicOrPartition)
+		_, err = transport.FetchOffsets([]TopicPartition{tp})
+		assert.ErrorIs(t, err, sarama.ErrUnknownTopicOrPartition)
+		_, _, err = broker.fetch(tp.Topic, tp.Partition, 0)
+		assert.ErrorIs(t, err, sarama.ErrUnknownTopicOrPartition)
+	}
+}
+
+// claimRecorder records the claims of its sessions
+type claimRecorder struct {
+	claims chan map[string][]int32
+}
+
+func (r *claimRecorder) Setup(session sarama.ConsumerGroupSession) error {
+	r.claims <- session.Claims()
+	return nil
+}
+
+func (r *claimRecorder) Cleanup(sarama.ConsumerGroupSession) error { return nil }
+
+func (r *claimRecorder) ConsumeClaim(session sarama.ConsumerGroupSession, _ sarama.ConsumerGroupClaim) error {
+	<-session.Context().Done()
+	return nil
+}
+
+func TestMemoryBrokerRebalancesGroup(t *testing.T) {
+	broker := NewMemoryBroker()
+	broker.CreateTopic("pos_events", 4)
+	first, second := broker.Transport("group", 4), broker.Transport("group", 4)
+
+	recorder := &claimRecorder{claims: make(chan map[string][]int32, 4)}
+	ended := make(chan error, 1)
+	go func() { ended <- first.Subscribe(t.Context(), []string{"pos_events"}, recorder) }()
+	assert.Equal(t, map[string][]int32{"pos_events": {0, 1, 2, 3}}, <-recorder.claims)
+
+	// A second member joining ends the session of the first
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go second.Subscribe(ctx, []string{"pos_events"}, recorder)
+	assert.Len(t, (<-recorder.claims)["pos_events"], 2)
+	assert.NoError(t, <-ended)
+
+	go func() { ended <- first.Subscribe(t.Context(), []string{"pos_events"}, recorder) }()
+	assert.Len(t, (<-recorder.claims)["pos_events"], 2)
+	assert.NoError(t, first.Close())
+}
+
+func TestMemorySessionOffsets(t *testing.T) {
+	broker := NewMemoryBroker()
+	transport := broker.Transport("group", 1).(*memoryTransport)
+	session := &memorySession{ctx: t.Context(), transport: transport, marked: make(map[string]map[int32]int64)}
+
+	session.MarkOffset("pos_events", 0, 5, "")
+	session.MarkOffset("pos_events", 0, 3, "")
+	session.Commit()
+	assert.Equal(t, int64(5), broker.committed("group", "pos_events", 0))
+
+	// Resetting rewinds past the marked offset
+	session.ResetOffset("pos_events", 0, 1, "")
+	session.Commit()
+	assert.Equal(t, int64(1), broker.committed("group", "pos_events", 0))
+
+	// Like with sarama, resetting does not move ahead and marking does not
+	// move back
+	session.ResetOffset("pos_events", 0, 4, "")
+	session.MarkOffset("pos_events", 0, 0, "")
+	session.Commit()
+	assert.Equal(t, int64(1), broker.committed("group", "pos_events", 0))
+}
+
+func TestMemoryTransportRejectsTransactions(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	cfg.Transactional = true
+	_, err := NewConsumer(cfg, nil)
+	assert.ErrorContains(t, err, "not supported")
+}
@@ -0,0 +1,64 @@
+package kafka
+
+import (
+	"fmt"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+)
+
+// Partitioning strategies. Events with the same key land on the same
+// partition and are consumed in the order they were produced.
+const (
+	// PartitionByTerminal keeps all events of a terminal in order
+	PartitionByTerminal = "terminal"
+	// PartitionByBasket keeps the events of a basket in order, events
+	// outside a basket fall back to the terminal
+	PartitionByBasket = "basket"
+	// PartitionByStore keeps all events of a store in order
+	PartitionByStore = "store"
+	// PartitionByID spreads events evenly without any ordering guarantee
+	PartitionByID = "id"
+)
+
+// validPartitionStrategy checks a configured partitioning strategy
+func validPartitionStrategy(strategy string) error {
+	switch strategy {
+	case "", PartitionByTerminal, PartitionByBasket, PartitionByStore, PartitionByID:
+		return nil
+	default:
+		return fmt.Errorf("unsupported partitioning strategy %q", strategy)
+	}
+}
+
+// partitionKey returns the message key of an event for the given strategy.
+// When the payload lacks the field the strategy asks for, the next coarser
+// scope is used, and the event ID as a last resort.
+func partitionKey(event *models.Event, strategy string) string {
+	if strategy == PartitionByID {
+		return event.ID
+	}
+
+	scope := event.Scope()
+	terminal := ""
+	if scope.TerminalID != "" {
+		// Terminal IDs are only unique within a store
+		terminal = scope.StoreID + "/" + scope.TerminalID
+	}
+
+	var candidates []string
+	switch strategy {
+	case PartitionByBasket:
+		candidates = []string{scope.BasketID, terminal, scope.StoreID}
+	case PartitionByStore:
+		candidates = []string{scope.StoreID}
+	default:
+		candidates = []string{terminal, scope.StoreID}
+	}
+
+	for _, key := range candidates {
+		if key != "" {
+			return key
+		}
+	}
+	return event.ID
+}
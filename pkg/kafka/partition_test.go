@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionKey(t *testing.T) {
+	base := models.BasePayload{TerminalID: "POS001", StoreID: "STORE001"}
+	login := &models.Event{
+		ID:      "evt-1",
+		Type:    models.EventEmployeeLogin,
+		Payload: &models.EmployeeLoginPayload{BasePayload: base, EmployeeID: "EMP001"},
+	}
+	addItem := &models.Event{
+		ID:   "evt-2",
+		Type: models.EventAddItem,
+		Payload: map[string]any{
+			"terminal_id": "POS001",
+			"store_id":    "STORE001",
+			"basket_id":   "BASKET001",
+		},
+	}
+
+	for strategy, want := range map[string][2]string{
+		PartitionByTerminal: {"STORE001/POS001", "STORE001/POS001"},
+		"":                  {"STORE001/POS001", "STORE001/POS001"},
+		PartitionByBasket:   {"STORE001/POS001", "BASKET001"},
+		PartitionByStore:    {"STORE001", "STORE001"},
+		PartitionByID:       {"evt-1", "evt-2"},
+	} {
+		assert.Equal(t, want[0], partitionKey(login, strategy), strategy)
+		assert.Equal(t, want[1], partitionKey(addItem, strategy), strategy)
+	}
+
+	// Events without a scope fall back to their ID
+	assert.Equal(t, "evt-3", partitionKey(&models.Event{ID: "evt-3"}, PartitionByBasket))
+
+	assert.Error(t, validPartitionStrategy("employee"))
+}
+
+func TestProducerKeysByStrategy(t *testing.T) {
+	sync := mocks.NewSyncProducer(t, nil)
+	sync.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		key, _ := msg.Key.Encode()
+		assert.Equal(t, "BASKET001", string(key))
+		return nil
+	})
+
+	enc, err := newEncoder(&Config{})
+	assert.NoError(t, err)
+	p := &Producer{producer: sync, topic: "pos_events", encoder: enc, partitionBy: PartitionByBasket}
+
+	event := testEvents()[0]
+	event.Type = models.EventStartBasket
+	event.Payload = &models.StartBasketPayload{BasketPayload: models.BasketPayload{
+		BasePayload: models.BasePayload{TerminalID: "POS001", StoreID: "STORE001"},
+		EmployeeID:  "EMP001",
+		BasketID:    "BASKET001",
+	}}
+	assert.NoError(t, p.SendEvent(t.Context(), event))
+	assert.NoError(t, sync.Close())
+}
@@ -0,0 +1,886 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/database"
+	"github.com/google/uuid"
+)
+
+// queueBatchSize is the number of messages a consumer claims per poll
+const queueBatchSize = 100
+
+// postgresTransport is a Transport storing messages in the event_queue table.
+// The partitions are spread over the members of a group, which lease them in
+// the event_queue_leases table so each partition is consumed by one member
+// at a time. Members claim messages of their partitions in offset order and
+// lock them for the visibility timeout, which is extended while they are in
+// flight. Messages of a member that stops are delivered again once its
+// leases and locks expire.
+type postgresTransport struct {
+	db *database.Connection
+	// owned is set when the transport opened db itself and must close it
+	owned      bool
+	group      string
+	member     string
+	partitions int
+
+	visibilityTimeout time.Duration
+	retention         time.Duration
+	pollInterval      time.Duration
+}
+
+// queueHeader is a record header stored in the headers column
+type queueHeader struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// newPostgresTransport returns a transport on the configured database, or
+// one opened from the environment
+func newPostgresTransport(cfg *Config) (*postgresTransport, error) {
+	t := &postgresTransport{
+		db:                cfg.Database,
+		group:             cfg.ConsumerGroup,
+		member:            uuid.New().String(),
+		partitions:        max(cfg.Partitions, 1),
+		visibilityTimeout: cfg.QueueVisibilityTimeout,
+		retention:         cfg.QueueRetention,
+		pollInterval:      cfg.QueuePollInterval,
+	}
+	if t.pollInterval <= 0 {
+		t.pollInterval = time.Second
+	}
+	if t.visibilityTimeout <= 0 {
+		t.visibilityTimeout = 30 * time.Second
+	}
+	if t.db != nil {
+		return t, nil
+	}
+
+	ctx := context.Background()
+	db, err := database.New(ctx, database.NewDefaultConfig())
+	if err != nil {
+		return nil, err
+	}
+	if err := db.InitSchema(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	t.db, t.owned = db, true
+	return t, nil
+}
+
+// Publish appends a message to a partition chosen by hashing its key, taking
+// the next offset of the partition
+func (t *postgresTransport) Publish(ctx context.Context, msg *sarama.ProducerMessage) error {
+	partition, err := sarama.NewHashPartitioner(msg.Topic).Partition(msg, int32(t.partitions))
+	if err != nil {
+		return fmt.Errorf("failed to choose partition: %v", err)
+	}
+
+	var key, value []byte
+	if msg.Key != nil {
+		if key, err = msg.Key.Encode(); err != nil {
+			return fmt.Errorf("failed to encode key: %v", err)
+		}
+	}
+	if msg.Value != nil {
+		if value, err = msg.Value.Encode(); err != nil {
+			return fmt.Errorf("failed to encode value: %v", err)
+		}
+	}
+	headers := make([]queueHeader, 0, len(msg.Headers))
+	for _, h := range msg.Headers {
+		headers = append(headers, queueHeader{Key: h.Key, Value: h.Value})
+	}
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers: %v", err)
+	}
+
+	// The partition row stays locked until the insert commits, so offsets
+	// become visible in order
+	var offset int64
+	err = t.db.Pool().QueryRow(ctx, `
+		WITH next AS (
+			INSERT INTO event_queue_partitions (topic, partition, next_offset)
+			VALUES ($1, $2, 1)
+			ON CONFLICT (topic, partition)
+			DO UPDATE SET next_offset = event_queue_partitions.next_offset + 1
+			RETURNING next_offset - 1 AS message_offset
+		)
+		INSERT INTO event_queue (topic, partition, message_offset, key, value, headers)
+		SELECT $1, $2, message_offset, $3::bytea, $4::bytea, $5::jsonb FROM next
+		RETURNING message_offset
+	`, msg.Topic, partition, key, value, string(encoded)).Scan(&offset)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue message: %v", err)
+	}
+
+	msg.Partition, msg.Offset = partition, offset
+	return nil
+}
+
+// Subscribe leases the partitions assigned to this member and polls for
+// their messages, dispatching them to a claim per partition. Like a Kafka
+// consumer group session, it returns when the context is done or the members
+// of the group change.
+func (t *postgresTransport) Subscribe(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	topics = slices.Sorted(slices.Values(topics))
+	assigned, _, err := t.heartbeat(ctx, topics, nil)
+	if err != nil {
+		return fmt.Errorf("failed to join consumer group: %v", err)
+	}
+	// Leases are released after Cleanup committed the offsets
+	defer t.release(context.WithoutCancel(ctx))
+	leased, err := t.lease(ctx, assigned)
+	if err != nil {
+		return fmt.Errorf("failed to lease partitions: %v", err)
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	session := newQueueSession(sessionCtx, t)
+	session.member = t.member
+	session.claims = leased
+	claims := make(map[TopicPartition]*queueClaim)
+	for topic, partitions := range leased {
+		for _, partition := range partitions {
+			claims[TopicPartition{Topic: topic, Partition: partition}] = &queueClaim{
+				topic:     topic,
+				partition: partition,
+				messages:  make(chan *sarama.ConsumerMessage, queueBatchSize),
+			}
+		}
+	}
+
+	if err := handler.Setup(session); err != nil {
+		return err
+	}
+
+	// Leases and locks are renewed until the messages in flight are handled
+	// and committed, even after the session ended
+	renewCtx, stopRenewing := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopRenewing()
+	go t.keepAlive(renewCtx, topics, session, cancel)
+
+	var wg sync.WaitGroup
+	for _, claim := range claims {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ConsumeClaim(session, claim)
+		}()
+	}
+
+	t.poll(sessionCtx, topics, session, claims)
+
+	cancel()
+	for _, claim := range claims {
+		close(claim.messages)
+	}
+	wg.Wait()
+	return handler.Cleanup(session)
+}
+
+// poll claims visible messages until the context is done. It polls again
+// right away while full batches are returned.
+func (t *postgresTransport) poll(ctx context.Context, topics []string, session *queueSession, claims map[TopicPartition]*queueClaim) {
+	var purged time.Time
+	for {
+		if t.retention > 0 && time.Since(purged) > time.Minute {
+			if err := t.purge(ctx); err != nil {
+				log.Printf("Error purging event queue: %v", err)
+			}
+			purged = time.Now()
+		}
+
+		if err := t.refreshHighWaterMarks(ctx, topics, claims); err != nil {
+			log.Printf("Error reading event queue offsets: %v", err)
+		}
+
+		messages, err := t.claim(ctx, session.positions(slices.Collect(maps.Keys(claims))))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Error claiming queued messages: %v", err)
+		}
+		for _, message := range messages {
+			claim, ok := claims[TopicPartition{Topic: message.Topic, Partition: message.Partition}]
+			if !ok {
+				// Only claimed partitions are queried, the message is
+				// delivered once its lock expires
+				log.Printf("Skipping message at offset %d of unclaimed partition %s/%d", message.Offset, message.Topic, message.Partition)
+				continue
+			}
+			session.delivered(message)
+			select {
+			case claim.messages <- message:
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		if len(messages) == queueBatchSize {
+			continue
+		}
+		select {
+		case <-time.After(t.pollInterval):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// keepAlive sends heartbeats until the context is done. It ends the session
+// once the partitions assigned to the member change or it lost a lease.
+func (t *postgresTransport) keepAlive(ctx context.Context, topics []string, session *queueSession, endSession func()) {
+	ticker := time.NewTicker(t.visibilityTimeout / 3)
+	defer ticker.Stop()
+
+	leases := 0
+	for _, partitions := range session.claims {
+		leases += len(partitions)
+	}
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+
+		assigned, renewed, err := t.heartbeat(ctx, topics, session.inFlight())
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error renewing event queue leases: %v", err)
+			}
+			continue
+		}
+		if renewed < leases || !maps.EqualFunc(assigned, session.claims, slices.Equal) {
+			// Subscribe is called again with the new assignment
+			endSession()
+		}
+	}
+}
+
+// heartbeat keeps the member in its group, renews its leases and the locks
+// of its messages in flight, and returns the partitions assigned to it along
+// with the number of leases it still holds
+func (t *postgresTransport) heartbeat(ctx context.Context, topics []string, inFlight map[TopicPartition][]int64) (map[string][]int32, int, error) {
+	timeout := t.visibilityTimeout.Seconds()
+	_, err := t.db.Pool().Exec(ctx, `
+		INSERT INTO event_queue_members (group_id, member_id, expires_at)
+		VALUES ($1, $2, now() + make_interval(secs => $3))
+		ON CONFLICT (group_id, member_id) DO UPDATE SET expires_at = EXCLUDED.expires_at
+	`, t.group, t.member, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := t.db.Pool().Exec(ctx, `
+		DELETE FROM event_queue_members WHERE group_id = $1 AND expires_at < now()
+	`, t.group); err != nil {
+		return nil, 0, err
+	}
+
+	tag, err := t.db.Pool().Exec(ctx, `
+		UPDATE event_queue_leases SET expires_at = now() + make_interval(secs => $3)
+		WHERE group_id = $1 AND member_id = $2 AND expires_at >= now()
+	`, t.group, t.member, timeout)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for tp, offsets := range inFlight {
+		_, err := t.db.Pool().Exec(ctx, `
+			UPDATE event_queue_deliveries SET locked_until = now() + make_interval(secs => $5)
+			WHERE group_id = $1 AND topic = $2 AND partition = $3 AND message_offset = ANY($4) AND acked_at IS NULL
+		`, t.group, tp.Topic, tp.Partition, offsets, timeout)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	rows, err := t.db.Pool().Query(ctx, `
+		SELECT member_id FROM event_queue_members WHERE group_id = $1 ORDER BY member_id
+	`, t.group)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	var members []string
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, 0, err
+		}
+		members = append(members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	return assignPartitions(members, t.member, topics, t.partitions), int(tag.RowsAffected()), nil
+}
+
+// assignPartitions spreads the partitions of the topics over the sorted
+// members of a group in turn and returns the ones of member
+func assignPartitions(members []string, member string, topics []string, partitions int) map[string][]int32 {
+	assigned := make(map[string][]int32)
+	index := slices.Index(members, member)
+	if index < 0 {
+		return assigned
+	}
+
+	var i int
+	for _, topic := range topics {
+		for partition := range int32(partitions) {
+			if i%len(members) == index {
+				assigned[topic] = append(assigned[topic], partition)
+			}
+			i++
+		}
+	}
+	return assigned
+}
+
+// lease takes the assigned partitions that no other member holds and
+// returns the ones this member holds now
+func (t *postgresTransport) lease(ctx context.Context, assigned map[string][]int32) (map[string][]int32, error) {
+	leased := make(map[string][]int32)
+	for topic, partitions := range assigned {
+		rows, err := t.db.Pool().Query(ctx, `
+			INSERT INTO event_queue_leases (group_id, topic, partition, member_id, expires_at)
+			SELECT $1, $2, partition, $3, now() + make_interval(secs => $5)
+			FROM unnest($4::integer[]) AS partition
+			ON CONFLICT (group_id, topic, partition) DO UPDATE
+			SET member_id = EXCLUDED.member_id, expires_at = EXCLUDED.expires_at
+			WHERE event_queue_leases.member_id = EXCLUDED.member_id OR event_queue_leases.expires_at < now()
+			RETURNING partition
+		`, t.group, topic, t.member, partitions, t.visibilityTimeout.Seconds())
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var partition int32
+			if err := rows.Scan(&partition); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			leased[topic] = append(leased[topic], partition)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		// Partitions held by another member are leased on a later rebalance
+		slices.Sort(leased[topic])
+	}
+	return leased, nil
+}
+
+// release gives up the leases of this member so others can take them over
+func (t *postgresTransport) release(ctx context.Context) {
+	_, err := t.db.Pool().Exec(ctx, `
+		DELETE FROM event_queue_leases WHERE group_id = $1 AND member_id = $2
+	`, t.group, t.member)
+	if err != nil {
+		log.Printf("Error releasing event queue leases: %v", err)
+	}
+}
+
+// claim locks the next visible messages of the partitions claimed by the
+// session, as long as this member still leases them, starting each partition
+// at the offset after the last message delivered to the session. The lease
+// rows stay locked until the transaction commits, so claims of a partition
+// never interleave. A partition is claimed in offset order and never past a
+// message locked by a delivery of an earlier session, so its messages are
+// handled in order even if that session ended with messages in flight.
+func (t *postgresTransport) claim(ctx context.Context, partitions map[TopicPartition]int64) ([]*sarama.ConsumerMessage, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+	topics := make([]string, 0, len(partitions))
+	ids := make([]int32, 0, len(partitions))
+	next := make([]int64, 0, len(partitions))
+	for tp, offset := range partitions {
+		topics = append(topics, tp.Topic)
+		ids = append(ids, tp.Partition)
+		next = append(next, offset)
+	}
+
+	tx, err := t.db.Pool().Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		WITH leased AS (
+			SELECT s.topic, s.partition, s.next_offset
+			FROM unnest($2::text[], $6::integer[], $7::bigint[]) AS s(topic, partition, next_offset)
+			JOIN event_queue_leases l
+				ON l.group_id = $1
+				AND l.topic = s.topic
+				AND l.partition = s.partition
+				AND l.member_id = $5
+				AND l.expires_at > now()
+			FOR UPDATE OF l
+		), blocked AS (
+			-- First message of each partition still locked by a delivery
+			-- that is not in flight in this session
+			SELECT p.topic, p.partition, min(d.message_offset) AS message_offset
+			FROM leased p
+			JOIN event_queue_deliveries d
+				ON d.group_id = $1
+				AND d.topic = p.topic
+				AND d.partition = p.partition
+				AND d.message_offset >= p.next_offset
+			WHERE d.acked_at IS NULL AND d.locked_until >= now()
+			GROUP BY p.topic, p.partition
+		), visible AS (
+			SELECT q.topic, q.partition, q.message_offset
+			FROM event_queue q
+			JOIN leased p
+				ON p.topic = q.topic
+				AND p.partition = q.partition
+				AND q.message_offset >= p.next_offset
+			LEFT JOIN blocked b
+				ON b.topic = q.topic
+				AND b.partition = q.partition
+			LEFT JOIN event_queue_deliveries d
+				ON d.group_id = $1
+				AND d.topic = q.topic
+				AND d.partition = q.partition
+				AND d.message_offset = q.message_offset
+			WHERE d.acked_at IS NULL
+				AND (d.locked_until IS NULL OR d.locked_until < now())
+				AND (b.message_offset IS NULL OR q.message_offset < b.message_offset)
+			ORDER BY q.topic, q.partition, q.message_offset
+			LIMIT $3
+		), claimed AS (
+			INSERT INTO event_queue_deliveries (group_id, topic, partition, message_offset, attempts, locked_until)
+			SELECT $1, topic, partition, message_offset, 1, now() + make_interval(secs => $4)
+			FROM visible
+			ON CONFLICT (group_id, topic, partition, message_offset)
+			DO UPDATE SET attempts = event_queue_deliveries.attempts + 1, locked_until = EXCLUDED.locked_until
+			RETURNING topic, partition, message_offset
+		)
+		SELECT q.topic, q.partition, q.message_offset, q.key, q.value, q.headers, q.created_at
+		FROM event_queue q
+		JOIN claimed c
+			ON c.topic = q.topic
+			AND c.partition = q.partition
+			AND c.message_offset = q.message_offset
+		ORDER BY q.topic, q.partition, q.message_offset
+	`, t.group, topics, queueBatchSize, t.visibilityTimeout.Seconds(), t.member, ids, next)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*sarama.ConsumerMessage
+	for rows.Next() {
+		var (
+			message sarama.ConsumerMessage
+			headers []queueHeader
+		)
+		if err := rows.Scan(&message.Topic, &message.Partition, &message.Offset, &message.Key, &message.Value, &headers, &message.Timestamp); err != nil {
+			return nil, err
+		}
+		for _, h := range headers {
+			message.Headers = append(message.Headers, &sarama.RecordHeader{Key: h.Key, Value: h.Value})
+		}
+		messages = append(messages, &message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// refreshHighWaterMarks reads the next offset of each claimed partition
+func (t *postgresTransport) refreshHighWaterMarks(ctx context.Context, topics []string, claims map[TopicPartition]*queueClaim) error {
+	rows, err := t.db.Pool().Query(ctx, `
+		SELECT topic, partition, next_offset FROM event_queue_partitions WHERE topic = ANY($1)
+	`, topics)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			tp     TopicPartition
+			offset int64
+		)
+		if err := rows.Scan(&tp.Topic, &tp.Partition, &offset); err != nil {
+			return err
+		}
+		if claim, ok := claims[tp]; ok {
+			claim.highWaterMark.Store(offset)
+		}
+	}
+	return rows.Err()
+}
+
+// FetchOffsets returns the next offset of each partition, and as committed
+// offset the first message the group has not acknowledged
+func (t *postgresTransport) FetchOffsets(partitions []TopicPartition) (map[TopicPartition]partitionOffsets, error) {
+	offsets := make(map[TopicPartition]partitionOffsets, len(partitions))
+	for _, tp := range partitions {
+		var o partitionOffsets
+		err := t.db.Pool().QueryRow(context.Background(), `
+			SELECT
+				COALESCE(p.next_offset, 0),
+				COALESCE(
+					(SELECT min(q.message_offset)
+					FROM event_queue q
+					LEFT JOIN event_queue_deliveries d
+						ON d.group_id = $1
+						AND d.topic = q.topic
+						AND d.partition = q.partition
+						AND d.message_offset = q.message_offset
+					WHERE q.topic = $2 AND q.partition = $3 AND d.acked_at IS NULL),
+					p.next_offset,
+					0
+				)
+			FROM (SELECT 1) one
+			LEFT JOIN event_queue_partitions p ON p.topic = $2 AND p.partition = $3
+		`, t.group, tp.Topic, tp.Partition).Scan(&o.highWaterMark, &o.committed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch offsets of %s/%d: %v", tp.Topic, tp.Partition, err)
+		}
+		offsets[tp] = o
+	}
+	return offsets, nil
+}
+
+// purge deletes messages older than the retention along with their
+// deliveries, as long as the group acknowledged them and every message
+// before them, so messages that were not consumed yet are never lost
+func (t *postgresTransport) purge(ctx context.Context) error {
+	_, err := t.db.Pool().Exec(ctx, `
+		WITH committed AS (
+			SELECT q.topic, q.partition, min(q.message_offset) AS message_offset
+			FROM event_queue q
+			LEFT JOIN event_queue_deliveries d
+				ON d.group_id = $1
+				AND d.topic = q.topic
+				AND d.partition = q.partition
+				AND d.message_offset = q.message_offset
+			WHERE d.acked_at IS NULL
+			GROUP BY q.topic, q.partition
+		)
+		DELETE FROM event_queue q
+		WHERE q.created_at < now() - make_interval(secs => $2)
+			AND NOT EXISTS (
+				SELECT 1 FROM committed c
+				WHERE c.topic = q.topic
+					AND c.partition = q.partition
+					AND c.message_offset <= q.message_offset
+			)
+	`, t.group, t.retention.Seconds())
+	return err
+}
+
+// ack acknowledges messages of a partition so they are never delivered to
+// the group again
+func (t *postgresTransport) ack(ctx context.Context, tp TopicPartition, offsets []int64) error {
+	_, err := t.db.Pool().Exec(ctx, `
+		UPDATE event_queue_deliveries SET acked_at = now()
+		WHERE group_id = $1 AND topic = $2 AND partition = $3 AND message_offset = ANY($4)
+	`, t.group, tp.Topic, tp.Partition, offsets)
+	return err
+}
+
+// reset makes a partition continue at an offset: messages before it are
+// acknowledged and the ones from it on are delivered again
+func (t *postgresTransport) reset(ctx context.Context, tp TopicPartition, offset int64) error {
+	_, err := t.db.Pool().Exec(ctx, `
+		DELETE FROM event_queue_deliveries
+		WHERE group_id = $1 AND topic = $2 AND partition = $3 AND message_offset >= $4
+	`, t.group, tp.Topic, tp.Partition, offset)
+	if err != nil {
+		return err
+	}
+
+	_, err = t.db.Pool().Exec(ctx, `
+		INSERT INTO event_queue_deliveries (group_id, topic, partition, message_offset, acked_at)
+		SELECT $1, topic, partition, message_offset, now()
+		FROM event_queue
+		WHERE topic = $2 AND partition = $3 AND message_offset < $4
+		ON CONFLICT (group_id, topic, partition, message_offset)
+		DO UPDATE SET acked_at = COALESCE(event_queue_deliveries.acked_at, now())
+	`, t.group, tp.Topic, tp.Partition, offset)
+	return err
+}
+
+// OffsetForTime returns the first offset of a partition enqueued at or after
+// the time, or the high-water mark if there is none
+func (t *postgresTransport) OffsetForTime(topic string, partition int32, at time.Time) (int64, error) {
+	var offset int64
+	err := t.db.Pool().QueryRow(context.Background(), `
+		SELECT COALESCE(
+			(SELECT min(message_offset) FROM event_queue WHERE topic = $1 AND partition = $2 AND created_at >= $3),
+			(SELECT next_offset FROM event_queue_partitions WHERE topic = $1 AND partition = $2),
+			0
+		)
+	`, topic, partition, at).Scan(&offset)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset: %v", err)
+	}
+	return offset, nil
+}
+
+// OffsetRange returns the oldest offset of a partition that was not purged
+// and its next offset
+func (t *postgresTransport) OffsetRange(topic string, partition int32) (int64, int64, error) {
+	var oldest, newest int64
+	err := t.db.Pool().QueryRow(context.Background(), `
+		SELECT
+			COALESCE(
+				(SELECT min(message_offset) FROM event_queue WHERE topic = $1 AND partition = $2),
+				p.next_offset,
+				0
+			),
+			COALESCE(p.next_offset, 0)
+		FROM (SELECT 1) one
+		LEFT JOIN event_queue_partitions p ON p.topic = $1 AND p.partition = $2
+	`, topic, partition).Scan(&oldest, &newest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get offsets: %v", err)
+	}
+	return oldest, newest, nil
+}
+
+func (t *postgresTransport) RefreshMetadata(...string) error {
+	return nil
+}
+
+// Topics returns the topics messages were published to
+func (t *postgresTransport) Topics() ([]string, error) {
+	rows, err := t.db.Pool().Query(context.Background(), `SELECT DISTINCT topic FROM event_queue_partitions`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var topics []string
+	for rows.Next() {
+		var topic string
+		if err := rows.Scan(&topic); err != nil {
+			return nil, err
+		}
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics, rows.Err()
+}
+
+// Close leaves the consumer group, so the other members take over its
+// partitions on their next heartbeat
+func (t *postgresTransport) Close() error {
+	_, err := t.db.Pool().Exec(context.Background(), `
+		DELETE FROM event_queue_members WHERE group_id = $1 AND member_id = $2
+	`, t.group, t.member)
+	if err != nil {
+		log.Printf("Error leaving consumer group: %v", err)
+	}
+	if t.owned {
+		t.db.Close()
+	}
+	return nil
+}
+
+// queueAcker stores acknowledgements and offset resets of a queue session
+type queueAcker interface {
+	ack(ctx context.Context, tp TopicPartition, offsets []int64) error
+	reset(ctx context.Context, tp TopicPartition, offset int64) error
+}
+
+// queueSession implements sarama.ConsumerGroupSession for a postgres
+// transport. Marking an offset acknowledges the messages delivered to the
+// session before it on the next Commit.
+type queueSession struct {
+	ctx    context.Context
+	acker  queueAcker
+	member string
+	claims map[string][]int32
+
+	mu sync.Mutex
+	// pending holds the delivered offsets that are not acknowledged yet,
+	// next the offset after the last message delivered of each partition
+	pending map[TopicPartition][]int64
+	next    map[TopicPartition]int64
+	marked  map[TopicPartition]int64
+	resets  map[TopicPartition]int64
+}
+
+func newQueueSession(ctx context.Context, acker queueAcker) *queueSession {
+	return &queueSession{
+		ctx:     ctx,
+		acker:   acker,
+		claims:  make(map[string][]int32),
+		pending: make(map[TopicPartition][]int64),
+		next:    make(map[TopicPartition]int64),
+		marked:  make(map[TopicPartition]int64),
+		resets:  make(map[TopicPartition]int64),
+	}
+}
+
+// delivered records a message handed to the handler
+func (s *queueSession) delivered(message *sarama.ConsumerMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tp := TopicPartition{Topic: message.Topic, Partition: message.Partition}
+	s.pending[tp] = append(s.pending[tp], message.Offset)
+	s.next[tp] = max(s.next[tp], message.Offset+1)
+}
+
+// positions returns the offset each partition is claimed from, after the
+// messages already delivered to the session
+func (s *queueSession) positions(partitions []TopicPartition) map[TopicPartition]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	positions := make(map[TopicPartition]int64, len(partitions))
+	for _, tp := range partitions {
+		positions[tp] = s.next[tp]
+	}
+	return positions
+}
+
+// inFlight returns the offsets delivered to the handler that are not
+// acknowledged yet
+func (s *queueSession) inFlight() map[TopicPartition][]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	inFlight := make(map[TopicPartition][]int64, len(s.pending))
+	for tp, offsets := range s.pending {
+		if len(offsets) > 0 {
+			inFlight[tp] = slices.Clone(offsets)
+		}
+	}
+	return inFlight
+}
+
+func (s *queueSession) Claims() map[string][]int32 {
+	return s.claims
+}
+
+func (s *queueSession) MemberID() string {
+	return s.member
+}
+
+func (s *queueSession) GenerationID() int32 {
+	return 0
+}
+
+func (s *queueSession) MarkOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tp := TopicPartition{Topic: topic, Partition: partition}
+	s.marked[tp] = max(s.marked[tp], offset)
+}
+
+// ResetOffset makes the partition continue at the offset, delivering the
+// messages from it on again
+func (s *queueSession) ResetOffset(topic string, partition int32, offset int64, _ string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tp := TopicPartition{Topic: topic, Partition: partition}
+	s.resets[tp] = offset
+	delete(s.marked, tp)
+}
+
+func (s *queueSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.MarkOffset(msg.Topic, msg.Partition, msg.Offset+1, metadata)
+}
+
+// Commit applies offset resets and acknowledges the delivered messages
+// behind the marked offsets. Failed acknowledgements are retried on the next Commit.
+func (s *queueSession) Commit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Commits must survive the end of the session, like Cleanup's final one
+	ctx := context.WithoutCancel(s.ctx)
+	for tp, offset := range s.resets {
+		if err := s.acker.reset(ctx, tp, offset); err != nil {
+			log.Printf("Error resetting %s/%d to %d: %v", tp.Topic, tp.Partition, offset, err)
+			continue
+		}
+		delete(s.resets, tp)
+		s.next[tp] = offset
+	}
+
+	for tp, marked := range s.marked {
+		var acked, pending []int64
+		for _, offset := range s.pending[tp] {
+			if offset < marked {
+				acked = append(acked, offset)
+			} else {
+				pending = append(pending, offset)
+			}
+		}
+		if len(acked) == 0 {
+			continue
+		}
+		if err := s.acker.ack(ctx, tp, acked); err != nil {
+			log.Printf("Error acknowledging messages of %s/%d: %v", tp.Topic, tp.Partition, err)
+			continue
+		}
+		s.pending[tp] = pending
+	}
+}
+
+func (s *queueSession) Context() context.Context {
+	return s.ctx
+}
+
+// queueClaim implements sarama.ConsumerGroupClaim for a postgres transport
+type queueClaim struct {
+	topic         string
+	partition     int32
+	messages      chan *sarama.ConsumerMessage
+	highWaterMark atomic.Int64
+}
+
+func (c *queueClaim) Topic() string {
+	return c.topic
+}
+
+func (c *queueClaim) Partition() int32 {
+	return c.partition
+}
+
+// InitialOffset is unknown, messages are claimed individually
+func (c *queueClaim) InitialOffset() int64 {
+	return sarama.OffsetOldest
+}
+
+func (c *queueClaim) HighWaterMarkOffset() int64 {
+	return c.highWaterMark.Load()
+}
+
+func (c *queueClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
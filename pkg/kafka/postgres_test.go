@@ -0,0 +1,115 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeAcker records the acknowledgements and offset resets of a queue session
+type fakeAcker struct {
+	acked  map[TopicPartition][]int64
+	resets map[TopicPartition]int64
+	err    error
+}
+
+func (a *fakeAcker) ack(_ context.Context, tp TopicPartition, offsets []int64) error {
+	if a.err != nil {
+		return a.err
+	}
+	a.acked[tp] = append(a.acked[tp], offsets...)
+	return nil
+}
+
+func (a *fakeAcker) reset(_ context.Context, tp TopicPartition, offset int64) error {
+	a.resets[tp] = offset
+	return nil
+}
+
+func TestQueueSessionCommit(t *testing.T) {
+	acker := &fakeAcker{acked: make(map[TopicPartition][]int64), resets: make(map[TopicPartition]int64)}
+	session := newQueueSession(t.Context(), acker)
+	tp := TopicPartition{Topic: "pos_events", Partition: 1}
+
+	for _, offset := range []int64{3, 4, 7} {
+		session.delivered(&sarama.ConsumerMessage{Topic: "pos_events", Partition: 1, Offset: offset})
+	}
+
+	// Marking acknowledges the messages delivered before the marked one
+	session.MarkMessage(&sarama.ConsumerMessage{Topic: "pos_events", Partition: 1, Offset: 4}, "")
+	session.Commit()
+	assert.Equal(t, []int64{3, 4}, acker.acked[tp])
+
+	// Failed acknowledgements are retried on the next commit
+	acker.err = assert.AnError
+	session.MarkOffset("pos_events", 1, 8, "")
+	session.Commit()
+	acker.err = nil
+	session.Commit()
+	assert.Equal(t, []int64{3, 4, 7}, acker.acked[tp])
+
+	session.ResetOffset("pos_events", 1, 2, "")
+	session.Commit()
+	assert.Equal(t, int64(2), acker.resets[tp])
+}
+
+func TestQueueSessionInFlight(t *testing.T) {
+	acker := &fakeAcker{acked: make(map[TopicPartition][]int64), resets: make(map[TopicPartition]int64)}
+	session := newQueueSession(t.Context(), acker)
+	tp := TopicPartition{Topic: "pos_events", Partition: 0}
+
+	for _, offset := range []int64{1, 2, 3} {
+		session.delivered(&sarama.ConsumerMessage{Topic: "pos_events", Offset: offset})
+	}
+	assert.Equal(t, map[TopicPartition][]int64{tp: {1, 2, 3}}, session.inFlight())
+
+	// Acknowledged messages no longer need their locks extended
+	session.MarkOffset("pos_events", 0, 4, "")
+	session.Commit()
+	assert.Empty(t, session.inFlight())
+}
+
+func TestQueueSessionPositions(t *testing.T) {
+	acker := &fakeAcker{acked: make(map[TopicPartition][]int64), resets: make(map[TopicPartition]int64)}
+	session := newQueueSession(t.Context(), acker)
+	first := TopicPartition{Topic: "pos_events", Partition: 0}
+	second := TopicPartition{Topic: "pos_events", Partition: 1}
+
+	// A new session claims from the first message not acknowledged
+	assert.Equal(t, map[TopicPartition]int64{first: 0, second: 0}, session.positions([]TopicPartition{first, second}))
+
+	// Messages in flight are not claimed again
+	for _, offset := range []int64{5, 6} {
+		session.delivered(&sarama.ConsumerMessage{Topic: "pos_events", Offset: offset})
+	}
+	session.MarkOffset("pos_events", 0, 6, "")
+	session.Commit()
+	assert.Equal(t, map[TopicPartition]int64{first: 7, second: 0}, session.positions([]TopicPartition{first, second}))
+
+	// A reset moves the partition back to the offset
+	session.ResetOffset("pos_events", 0, 2, "")
+	session.Commit()
+	assert.Equal(t, map[TopicPartition]int64{first: 2}, session.positions([]TopicPartition{first}))
+}
+
+func TestAssignPartitions(t *testing.T) {
+	members := []string{"a", "b"}
+	topics := []string{"pos_events", "pos_events_late"}
+
+	// Partitions are spread over the members in turn
+	assert.Equal(t, map[string][]int32{"pos_events": {0, 2}, "pos_events_late": {1}}, assignPartitions(members, "a", topics, 3))
+	assert.Equal(t, map[string][]int32{"pos_events": {1}, "pos_events_late": {0, 2}}, assignPartitions(members, "b", topics, 3))
+
+	// A single member gets every partition
+	assert.Equal(t, map[string][]int32{"pos_events": {0, 1, 2}}, assignPartitions([]string{"a"}, "a", topics[:1], 3))
+
+	// Members that have not joined get none
+	assert.Empty(t, assignPartitions(members, "c", topics, 3))
+}
+
+func TestPostgresTransportRejectsTransactions(t *testing.T) {
+	_, err := NewConsumer(&Config{Transport: TransportPostgres, Transactional: true}, nil)
+	assert.ErrorContains(t, err, "not supported")
+}
@@ -2,8 +2,9 @@ package kafka
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
 
 	"github.com/IBM/sarama"
 	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
@@ -12,49 +13,293 @@ import (
 // Producer represents a Kafka producer
 type Producer struct {
 	producer sarama.SyncProducer
-	topic    string
+	// async is set instead of producer in asynchronous mode
+	async sarama.AsyncProducer
+	// dispatchers reports async deliveries to their callbacks
+	dispatchers sync.WaitGroup
+	topic       string
+	encoder     *encoder
+	// partitionBy selects the payload field used as message key
+	partitionBy string
+	// routes maps event types to topics other than the default topic
+	routes map[models.EventType]string
+}
+
+// DeliveryCallback is called once an event was acknowledged by the broker or
+// failed to be delivered. In asynchronous mode it runs on the producer's
+// dispatch goroutine and must not block.
+type DeliveryCallback func(event *models.Event, err error)
+
+// delivery travels with an async message as its metadata
+type delivery struct {
+	event    *models.Event
+	callback DeliveryCallback
 }
 
 // NewProducer creates a new Kafka producer
 func NewProducer(cfg *Config) (*Producer, error) {
-	config := sarama.NewConfig()
-	config.Producer.RequiredAcks = sarama.WaitForAll
-	config.Producer.Retry.Max = cfg.RetryAttempts
-	config.Producer.Return.Successes = true
+	enc, err := newEncoder(cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	producer, err := sarama.NewSyncProducer(cfg.Brokers, config)
+	config, err := producerConfig(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create producer: %v", err)
+		return nil, err
 	}
 
+	if cfg.Transport == "" || cfg.Transport == TransportKafka {
+		return newProducer(cfg, config, enc)
+	}
+
+	// Other transports publish synchronously, without batching
+	transport, err := newTransport(cfg, config)
+	if err != nil {
+		return nil, err
+	}
 	return &Producer{
-		producer: producer,
-		topic:    cfg.Topic,
+		producer:    &transportProducer{transport: transport},
+		topic:       cfg.Topic,
+		encoder:     enc,
+		partitionBy: cfg.PartitionBy,
+		routes:      cfg.TopicRoutes,
 	}, nil
 }
 
-// Close closes the producer
+// newTransactionalProducer creates a synchronous producer that publishes to
+// the output topic within transactions
+func newTransactionalProducer(cfg *Config) (*Producer, error) {
+	txCfg := *cfg
+	txCfg.Topic = cfg.OutputTopic
+	txCfg.Async = false
+	txCfg.Idempotent = true
+
+	enc, err := newEncoder(&txCfg)
+	if err != nil {
+		return nil, err
+	}
+	config, err := producerConfig(&txCfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.Transaction.ID = transactionalID(cfg)
+
+	return newProducer(&txCfg, config, enc)
+}
+
+// transactionalID returns the configured transactional ID, defaulting to one
+// that is stable for the consumer group on this host
+func transactionalID(cfg *Config) string {
+	if cfg.TransactionalID != "" {
+		return cfg.TransactionalID
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	return cfg.ConsumerGroup + "-" + hostname
+}
+
+// newProducer creates a producer with the given sarama configuration
+func newProducer(cfg *Config, config *sarama.Config, enc *encoder) (*Producer, error) {
+	var err error
+	p := &Producer{
+		topic:       cfg.Topic,
+		encoder:     enc,
+		partitionBy: cfg.PartitionBy,
+		routes:      cfg.TopicRoutes,
+	}
+
+	if cfg.Async {
+		if p.async, err = sarama.NewAsyncProducer(cfg.Brokers, config); err != nil {
+			return nil, fmt.Errorf("failed to create async producer: %v", err)
+		}
+		p.dispatch()
+		return p, nil
+	}
+
+	if p.producer, err = sarama.NewSyncProducer(cfg.Brokers, config); err != nil {
+		return nil, fmt.Errorf("failed to create producer: %v", err)
+	}
+	return p, nil
+}
+
+// producerConfig returns the sarama configuration for the configured
+// batching, compression and delivery guarantees
+func producerConfig(cfg *Config) (*sarama.Config, error) {
+	if err := validPartitionStrategy(cfg.PartitionBy); err != nil {
+		return nil, err
+	}
+
+	config, err := newConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = cfg.RetryAttempts
+	config.Producer.Return.Successes = true
+	config.Producer.Partitioner = sarama.NewHashPartitioner
+
+	if cfg.Async {
+		config.Producer.Flush.Messages = cfg.BatchSize
+		config.Producer.Flush.Frequency = cfg.Linger
+	}
+
+	if cfg.Compression != "" {
+		if err := config.Producer.Compression.UnmarshalText([]byte(cfg.Compression)); err != nil {
+			return nil, fmt.Errorf("unsupported compression %q", cfg.Compression)
+		}
+	}
+
+	if cfg.Idempotent || config.Producer.Compression == sarama.CompressionZSTD {
+		config.Version = sarama.V2_1_0_0
+	}
+	if cfg.Idempotent {
+		// Idempotence relies on in-order delivery of a single in-flight request
+		config.Producer.Idempotent = true
+		config.Net.MaxOpenRequests = 1
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid producer configuration: %v", err)
+	}
+	return config, nil
+}
+
+// dispatch reports async deliveries and failures to their callbacks until the
+// producer is closed
+func (p *Producer) dispatch() {
+	p.dispatchers.Add(2)
+	go func() {
+		defer p.dispatchers.Done()
+		for msg := range p.async.Successes() {
+			deliver(msg, nil)
+		}
+	}()
+	go func() {
+		defer p.dispatchers.Done()
+		for perr := range p.async.Errors() {
+			deliver(perr.Msg, perr.Err)
+		}
+	}()
+}
+
+// deliver invokes the callback attached to a message
+func deliver(msg *sarama.ProducerMessage, err error) {
+	if d, ok := msg.Metadata.(*delivery); ok && d.callback != nil {
+		d.callback(d.event, err)
+	}
+}
+
+// Close closes the producer. In asynchronous mode buffered events are
+// flushed and their callbacks invoked before Close returns.
 func (p *Producer) Close() error {
+	if p.async != nil {
+		p.async.AsyncClose()
+		p.dispatchers.Wait()
+		return nil
+	}
 	return p.producer.Close()
 }
 
-// SendEvent sends an event to Kafka
+// SendEvent sends an event to the topic routed for its type and waits until
+// it is delivered
 func (p *Producer) SendEvent(ctx context.Context, event *models.Event) error {
-	data, err := json.Marshal(event)
+	return p.SendEventTo(ctx, p.topicFor(event.Type), event)
+}
+
+// SendEventTo sends an event to the given topic and waits until it is delivered
+func (p *Producer) SendEventTo(ctx context.Context, topic string, event *models.Event) error {
+	msg, err := p.message(topic, event)
 	if err != nil {
-		return fmt.Errorf("failed to marshal event: %v", err)
+		return err
 	}
 
-	msg := &sarama.ProducerMessage{
-		Topic: p.topic,
-		Value: sarama.StringEncoder(data),
-		Key:   sarama.StringEncoder(event.ID),
+	if err := p.send(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send message: %v", err)
 	}
 
-	_, _, err = p.producer.SendMessage(msg)
+	return nil
+}
+
+// SendEventAsync queues an event and returns without waiting for delivery.
+// The outcome is reported to the callback. Errors returned directly mean the
+// event was never queued. It must not be called after Close.
+func (p *Producer) SendEventAsync(ctx context.Context, event *models.Event, callback DeliveryCallback) error {
+	msg, err := p.message(p.topicFor(event.Type), event)
 	if err != nil {
-		return fmt.Errorf("failed to send message: %v", err)
+		return err
+	}
+
+	if p.async == nil {
+		err := p.send(ctx, msg)
+		if callback != nil {
+			callback(event, err)
+		}
+		return nil
+	}
+
+	msg.Metadata = &delivery{event: event, callback: callback}
+	return p.enqueue(ctx, msg)
+}
+
+// message builds the Kafka message of an event
+func (p *Producer) message(topic string, event *models.Event) (*sarama.ProducerMessage, error) {
+	data, headers, err := p.encoder.encode(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode event: %v", err)
+	}
+
+	return &sarama.ProducerMessage{
+		Topic:   topic,
+		Value:   sarama.ByteEncoder(data),
+		Key:     sarama.StringEncoder(partitionKey(event, p.partitionBy)),
+		Headers: headers,
+	}, nil
+}
+
+// send delivers a message and waits for the broker acknowledgement
+func (p *Producer) send(ctx context.Context, msg *sarama.ProducerMessage) error {
+	if p.async == nil {
+		_, _, err := p.producer.SendMessage(msg)
+		return err
 	}
 
+	result := make(chan error, 1)
+	msg.Metadata = &delivery{callback: func(_ *models.Event, err error) { result <- err }}
+	if err := p.enqueue(ctx, msg); err != nil {
+		return err
+	}
+	return <-result
+}
+
+// enqueue hands a message to the async producer, blocking while its buffer is full
+func (p *Producer) enqueue(ctx context.Context, msg *sarama.ProducerMessage) error {
+	select {
+	case p.async.Input() <- msg:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// forward republishes a consumed message unchanged to another topic,
+// appending the given headers to the original ones
+func (p *Producer) forward(topic string, message *sarama.ConsumerMessage, headers ...sarama.RecordHeader) error {
+	msg := &sarama.ProducerMessage{
+		Topic: topic,
+		Key:   sarama.ByteEncoder(message.Key),
+		Value: sarama.ByteEncoder(message.Value),
+	}
+	for _, h := range message.Headers {
+		if h != nil {
+			msg.Headers = append(msg.Headers, *h)
+		}
+	}
+	msg.Headers = append(msg.Headers, headers...)
+
+	if err := p.send(context.Background(), msg); err != nil {
+		return fmt.Errorf("failed to forward message to %s: %v", topic, err)
+	}
 	return nil
 }
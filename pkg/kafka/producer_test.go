@@ -0,0 +1,76 @@
+package kafka
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProducerConfig(t *testing.T) {
+	config, err := producerConfig(&Config{
+		RetryAttempts: 3,
+		Async:         true,
+		BatchSize:     100,
+		Linger:        20 * time.Millisecond,
+		Compression:   "zstd",
+		Idempotent:    true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 100, config.Producer.Flush.Messages)
+	assert.Equal(t, 20*time.Millisecond, config.Producer.Flush.Frequency)
+	assert.Equal(t, sarama.CompressionZSTD, config.Producer.Compression)
+	assert.True(t, config.Producer.Idempotent)
+	assert.Equal(t, 1, config.Net.MaxOpenRequests)
+
+	_, err = producerConfig(&Config{Compression: "brotli"})
+	assert.Error(t, err)
+
+	// Idempotent delivery depends on producer retries
+	_, err = producerConfig(&Config{Idempotent: true})
+	assert.Error(t, err)
+}
+
+func TestProducerSendEventAsync(t *testing.T) {
+	config := mocks.NewTestConfig()
+	config.Producer.Return.Successes = true
+	async := mocks.NewAsyncProducer(t, config)
+	async.ExpectInputAndSucceed()
+	async.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	async.ExpectInputAndSucceed()
+
+	enc, err := newEncoder(&Config{})
+	assert.NoError(t, err)
+	p := &Producer{async: async, topic: "pos_events", encoder: enc}
+	p.dispatch()
+
+	var (
+		mu      sync.Mutex
+		results = make(map[string]error)
+	)
+	callback := func(event *models.Event, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[event.ID] = err
+	}
+
+	events := testEvents()[:2]
+	for _, event := range events {
+		assert.NoError(t, p.SendEventAsync(context.Background(), event, callback))
+	}
+
+	// Synchronous sends wait for their own delivery
+	assert.NoError(t, p.SendEvent(context.Background(), testEvents()[2]))
+
+	// Close flushes outstanding deliveries to their callbacks
+	assert.NoError(t, p.Close())
+	assert.Equal(t, map[string]error{
+		events[0].ID: nil,
+		events[1].ID: sarama.ErrOutOfBrokers,
+	}, results)
+}
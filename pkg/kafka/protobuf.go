@@ -0,0 +1,416 @@
+package kafka
+
+//go:generate protoc -I ../../docs --go_out=pospb --go_opt=paths=source_relative pos_events.proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/kafka/pospb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ProtobufCodec encodes events using the protobuf schema in docs/pos_events.proto
+type ProtobufCodec struct{}
+
+// ContentType returns the protobuf content type
+func (ProtobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+// Encode serializes an event as a protobuf Event message
+func (c ProtobufCodec) Encode(event *models.Event) ([]byte, error) {
+	payloadData, err := c.EncodePayload(event)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(&pospb.Event{
+		Id:            event.ID,
+		Type:          string(event.Type),
+		Version:       int32(event.Version),
+		Timestamp:     protoTime(event.Timestamp),
+		Payload:       payloadData,
+		CorrelationId: event.CorrelationID,
+		CausationId:   event.CausationID,
+		IngestedAt:    protoTime(event.IngestedAt),
+		ProcessedAt:   protoTime(event.ProcessedAt),
+		Late:          event.Late,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return data, nil
+}
+
+// Decode deserializes a protobuf Event message and validates its payload
+func (c ProtobufCodec) Decode(data []byte) (*models.Event, error) {
+	msg := &pospb.Event{}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, &models.ValidationError{Reason: fmt.Sprintf("malformed protobuf envelope: %v", err)}
+	}
+
+	event := &models.Event{
+		ID:            msg.Id,
+		Type:          models.EventType(msg.Type),
+		Version:       int(msg.Version),
+		Timestamp:     fromProtoTime(msg.Timestamp),
+		CorrelationID: msg.CorrelationId,
+		CausationID:   msg.CausationId,
+		IngestedAt:    fromProtoTime(msg.IngestedAt),
+		ProcessedAt:   fromProtoTime(msg.ProcessedAt),
+		Late:          msg.Late,
+	}
+	if event.ID == "" {
+		return nil, &models.ValidationError{EventType: event.Type, Field: "id", Reason: "is required"}
+	}
+	if event.Type == "" {
+		return nil, &models.ValidationError{Field: "type", Reason: "is required"}
+	}
+
+	payload, err := c.DecodePayload(event.Type, event.Version, msg.Payload)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = payload
+	event.Version = models.CurrentVersion(event.Type)
+
+	return event, nil
+}
+
+// EncodePayload serializes the event payload as its protobuf message
+func (ProtobufCodec) EncodePayload(event *models.Event) ([]byte, error) {
+	msg, err := protoPayload(event)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s payload: %v", event.Type, err)
+	}
+	return data, nil
+}
+
+// DecodePayload deserializes and validates a protobuf payload message.
+// Payloads written with an older schema version are converted to the JSON
+// they were written as and go through the same upcasters as JSON payloads.
+func (ProtobufCodec) DecodePayload(eventType models.EventType, version int, data []byte) (models.EventPayload, error) {
+	msg, ok := newProtoPayload(eventType)
+	if !ok {
+		return nil, &models.ValidationError{EventType: eventType, Reason: "unknown event type"}
+	}
+
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, &models.ValidationError{EventType: eventType, Field: "payload", Reason: err.Error()}
+	}
+
+	if !models.IsCurrentVersion(eventType, version) {
+		raw, err := json.Marshal(protoMap(msg.ProtoReflect()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s payload: %v", eventType, err)
+		}
+		return JSONCodec{}.DecodePayload(eventType, version, raw)
+	}
+
+	payload, err := fromProto(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := models.ValidatePayload(eventType, payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// protoPayload converts the payload of an event to its protobuf message.
+// Untyped payloads such as maps are decoded through the registry first.
+func protoPayload(event *models.Event) (proto.Message, error) {
+	if p, ok := event.Payload.(models.EventPayload); ok {
+		if msg, err := toProto(p); err == nil {
+			return msg, nil
+		}
+	}
+
+	data, err := JSONCodec{}.Encode(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	decoded, err := models.DecodeEvent(data)
+	if err != nil {
+		return nil, err
+	}
+	return toProto(decoded.Payload.(models.EventPayload))
+}
+
+// newProtoPayload returns an empty payload message for an event type
+func newProtoPayload(eventType models.EventType) (proto.Message, bool) {
+	switch eventType {
+	case models.EventEmployeeLogin:
+		return &pospb.EmployeeLogin{}, true
+	case models.EventEmployeeLogout:
+		return &pospb.EmployeeLogout{}, true
+	case models.EventStartBasket:
+		return &pospb.StartBasket{}, true
+	case models.EventCustomerIdentify:
+		return &pospb.CustomerIdentify{}, true
+	case models.EventAddItem:
+		return &pospb.AddItem{}, true
+	case models.EventFinalizeSubtotal:
+		return &pospb.FinalizeSubtotal{}, true
+	case models.EventPaymentComplete:
+		return &pospb.PaymentComplete{}, true
+	case models.EventCustomerData:
+		return &pospb.CustomerData{}, true
+	case models.EventPurchaseRecommendations:
+		return &pospb.PurchaseRecommendations{}, true
+	default:
+		return nil, false
+	}
+}
+
+// toProto converts a payload to its protobuf message
+func toProto(payload models.EventPayload) (proto.Message, error) {
+	switch p := payload.(type) {
+	case *models.EmployeeLoginPayload:
+		return &pospb.EmployeeLogin{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			EmployeeId: p.EmployeeID,
+		}, nil
+	case *models.EmployeeLogoutPayload:
+		return &pospb.EmployeeLogout{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			EmployeeId: p.EmployeeID,
+			AutoLogout: p.AutoLogout,
+			Reason:     p.Reason,
+		}, nil
+	case *models.StartBasketPayload:
+		return &pospb.StartBasket{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			EmployeeId: p.EmployeeID,
+			BasketId:   p.BasketID,
+		}, nil
+	case *models.CustomerIdentifyPayload:
+		return &pospb.CustomerIdentify{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			EmployeeId: p.EmployeeID,
+			BasketId:   p.BasketID,
+			CustomerId: p.CustomerID,
+		}, nil
+	case *models.AddItemPayload:
+		return &pospb.AddItem{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			EmployeeId: p.EmployeeID,
+			BasketId:   p.BasketID,
+			ItemId:     p.ItemID,
+			Quantity:   int32(p.Quantity),
+			PriceMoney: protoMoney(p.Price),
+		}, nil
+	case *models.FinalizeSubtotalPayload:
+		return &pospb.FinalizeSubtotal{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			EmployeeId: p.EmployeeID,
+			BasketId:   p.BasketID,
+		}, nil
+	case *models.PaymentCompletePayload:
+		return &pospb.PaymentComplete{
+			TerminalId:    p.TerminalID,
+			StoreId:       p.StoreID,
+			EmployeeId:    p.EmployeeID,
+			BasketId:      p.BasketID,
+			PaymentMethod: p.PaymentMethod,
+		}, nil
+	case *models.CustomerDataPayload:
+		msg := &pospb.CustomerData{
+			TerminalId: p.TerminalID,
+			StoreId:    p.StoreID,
+			BasketId:   p.BasketID,
+			CustomerId: p.CustomerID,
+		}
+		if p.Data != nil {
+			data, err := structpb.NewStruct(p.Data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to convert customer data: %v", err)
+			}
+			msg.Data = data
+		}
+		return msg, nil
+	case *models.PurchaseRecommendationsPayload:
+		msg := &pospb.PurchaseRecommendations{
+			TerminalId:   p.TerminalID,
+			StoreId:      p.StoreID,
+			BasketId:     p.BasketID,
+			SourceItemId: p.SourceItemID,
+		}
+		for _, r := range p.Recommendations {
+			msg.Recommendations = append(msg.Recommendations, &pospb.Recommendation{
+				ItemId:          r.ItemID,
+				Name:            r.Name,
+				ConfidenceScore: r.ConfidenceScore,
+				PriceMoney:      protoMoney(r.Price),
+			})
+		}
+		return msg, nil
+	default:
+		return nil, fmt.Errorf("no protobuf schema for payload %T", payload)
+	}
+}
+
+// fromProto converts a payload message of the current schema version
+func fromProto(msg proto.Message) (models.EventPayload, error) {
+	switch m := msg.(type) {
+	case *pospb.EmployeeLogin:
+		return &models.EmployeeLoginPayload{
+			BasePayload: models.BasePayload{TerminalID: m.TerminalId, StoreID: m.StoreId},
+			EmployeeID:  m.EmployeeId,
+		}, nil
+	case *pospb.EmployeeLogout:
+		return &models.EmployeeLogoutPayload{
+			BasePayload: models.BasePayload{TerminalID: m.TerminalId, StoreID: m.StoreId},
+			EmployeeID:  m.EmployeeId,
+			AutoLogout:  m.AutoLogout,
+			Reason:      m.Reason,
+		}, nil
+	case *pospb.StartBasket:
+		return &models.StartBasketPayload{
+			BasketPayload: basketPayload(m.TerminalId, m.StoreId, m.EmployeeId, m.BasketId),
+		}, nil
+	case *pospb.CustomerIdentify:
+		return &models.CustomerIdentifyPayload{
+			BasketPayload: basketPayload(m.TerminalId, m.StoreId, m.EmployeeId, m.BasketId),
+			CustomerID:    m.CustomerId,
+		}, nil
+	case *pospb.AddItem:
+		return &models.AddItemPayload{
+			BasketPayload: basketPayload(m.TerminalId, m.StoreId, m.EmployeeId, m.BasketId),
+			ItemID:        m.ItemId,
+			Price:         fromProtoMoney(m.PriceMoney),
+			Quantity:      int(m.Quantity),
+		}, nil
+	case *pospb.FinalizeSubtotal:
+		return &models.FinalizeSubtotalPayload{
+			BasketPayload: basketPayload(m.TerminalId, m.StoreId, m.EmployeeId, m.BasketId),
+		}, nil
+	case *pospb.PaymentComplete:
+		return &models.PaymentCompletePayload{
+			BasketPayload: basketPayload(m.TerminalId, m.StoreId, m.EmployeeId, m.BasketId),
+			PaymentMethod: m.PaymentMethod,
+		}, nil
+	case *pospb.CustomerData:
+		payload := &models.CustomerDataPayload{
+			BasePayload: models.BasePayload{TerminalID: m.TerminalId, StoreID: m.StoreId},
+			BasketID:    m.BasketId,
+			CustomerID:  m.CustomerId,
+		}
+		if m.Data != nil {
+			payload.Data = m.Data.AsMap()
+		}
+		return payload, nil
+	case *pospb.PurchaseRecommendations:
+		payload := &models.PurchaseRecommendationsPayload{
+			BasePayload:  models.BasePayload{TerminalID: m.TerminalId, StoreID: m.StoreId},
+			BasketID:     m.BasketId,
+			SourceItemID: m.SourceItemId,
+		}
+		for _, r := range m.Recommendations {
+			payload.Recommendations = append(payload.Recommendations, models.Recommendation{
+				ItemID:          r.ItemId,
+				Name:            r.Name,
+				Price:           fromProtoMoney(r.PriceMoney),
+				ConfidenceScore: r.ConfidenceScore,
+			})
+		}
+		return payload, nil
+	default:
+		return nil, fmt.Errorf("no payload for protobuf message %T", msg)
+	}
+}
+
+func basketPayload(terminalID, storeID, employeeID, basketID string) models.BasketPayload {
+	return models.BasketPayload{
+		BasePayload: models.BasePayload{TerminalID: terminalID, StoreID: storeID},
+		EmployeeID:  employeeID,
+		BasketID:    basketID,
+	}
+}
+
+func protoMoney(m models.Money) *pospb.Money {
+	if m == (models.Money{}) {
+		return nil
+	}
+	return &pospb.Money{Amount: m.Amount, Currency: m.Currency}
+}
+
+func fromProtoMoney(m *pospb.Money) models.Money {
+	if m == nil {
+		return models.Money{}
+	}
+	return models.NewMoney(m.Amount, m.Currency)
+}
+
+// protoMap converts a message to a map keyed by its proto field names. The
+// fields of a payload keep the JSON names of the schema version that
+// introduced them, so the map is the JSON payload of that version.
+func protoMap(m protoreflect.Message) map[string]any {
+	if s, ok := m.Interface().(*structpb.Struct); ok {
+		return s.AsMap()
+	}
+
+	values := make(map[string]any)
+	fields := m.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		// Scalars are kept even when zero, proto3 does not tell them apart
+		// from missing ones
+		if fd.Kind() == protoreflect.MessageKind && !m.Has(fd) {
+			continue
+		}
+
+		v := m.Get(fd)
+		if !fd.IsList() {
+			values[string(fd.Name())] = protoValue(fd, v)
+			continue
+		}
+		list := v.List()
+		items := make([]any, list.Len())
+		for j := range items {
+			items[j] = protoValue(fd, list.Get(j))
+		}
+		values[string(fd.Name())] = items
+	}
+	return values
+}
+
+func protoValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	if fd.Kind() == protoreflect.MessageKind {
+		return protoMap(v.Message())
+	}
+	return v.Interface()
+}
+
+// protoTime converts a time to a protobuf timestamp, omitting zero times
+func protoTime(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func fromProtoTime(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
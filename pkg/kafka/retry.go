@@ -0,0 +1,208 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+)
+
+// maxRetryDelay caps the exponential backoff between handler attempts
+const maxRetryDelay = time.Minute
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks an error as permanent so the consumer does not retry it
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsRetryable reports whether a failed handler may succeed when retried.
+// Validation failures and errors marked with Permanent are never retried,
+// anything else, such as a database timeout, is assumed to be transient.
+// Joined errors, such as the failures of several plugins, are retried when
+// any of them is transient, since a retry redoes the work that failed.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for err != nil {
+		switch e := err.(type) {
+		case *permanentError, *models.ValidationError:
+			return false
+		case interface{ Unwrap() []error }:
+			for _, inner := range e.Unwrap() {
+				if IsRetryable(inner) {
+					return true
+				}
+			}
+			return false
+		}
+		if err == context.Canceled {
+			return false
+		}
+		err = errors.Unwrap(err)
+	}
+	return true
+}
+
+// backoff returns the delay before the given retry, doubling the base delay
+// on every retry. Half of the delay is randomized so that consumers failing
+// at the same time do not retry in lockstep.
+func backoff(retry int, base time.Duration) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+
+	delay := base
+	for i := 1; i < retry && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// Progress records the work completed by earlier attempts of an event, so a
+// handler retrying it only redoes the work that failed. In transactional
+// mode an aborted attempt discards the events it published, so the work it
+// completed is forgotten while the results it stored are kept. The consumer
+// passes it to the handler as the eventctx.Progress of the event.
+type Progress struct {
+	mu      sync.Mutex
+	done    map[string]bool
+	results map[string]any
+	// transactional defers the AfterCommit functions until the transaction
+	// of the event ends
+	transactional bool
+	pending       []func(committed bool)
+}
+
+// NewProgress creates the progress of an event that was not attempted yet
+func NewProgress() *Progress {
+	return &Progress{done: make(map[string]bool), results: make(map[string]any)}
+}
+
+// NewTransactionalProgress creates the progress of an event handled in a
+// transaction, which the consumer ends with Commit or Abort
+func NewTransactionalProgress() *Progress {
+	p := NewProgress()
+	p.transactional = true
+	return p
+}
+
+// Done reports whether the work identified by key was completed
+func (p *Progress) Done(key string) bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.done[key]
+}
+
+// Complete records that the work identified by key was completed
+func (p *Progress) Complete(key string) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[key] = true
+}
+
+// Load returns the result stored under key by an earlier attempt
+func (p *Progress) Load(key string) (any, bool) {
+	if p == nil {
+		return nil, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	value, ok := p.results[key]
+	return value, ok
+}
+
+// Store keeps a result for the following attempts, even if the attempt
+// storing it is aborted
+func (p *Progress) Store(key string, value any) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.results[key] = value
+}
+
+// AfterCommit runs fn once the work done so far is durable. Outside a
+// transaction that is right away, otherwise fn runs when the transaction
+// commits, or with false when it is aborted.
+func (p *Progress) AfterCommit(fn func(committed bool)) {
+	if p == nil || !p.transactional {
+		fn(true)
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, fn)
+}
+
+// Commit runs the AfterCommit functions once the transaction committed
+func (p *Progress) Commit() {
+	for _, fn := range p.settle() {
+		fn(true)
+	}
+}
+
+// Abort forgets the work completed by an aborted attempt and runs the
+// AfterCommit functions it registered
+func (p *Progress) Abort() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	clear(p.done)
+	p.mu.Unlock()
+
+	for _, fn := range p.settle() {
+		fn(false)
+	}
+}
+
+// settle takes the pending AfterCommit functions
+func (p *Progress) settle() []func(committed bool) {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pending := p.pending
+	p.pending = nil
+	return pending
+}
+
+// progressFromContext returns the progress the consumer passed to the
+// handler, nil when the context carries none or another implementation
+func progressFromContext(ctx context.Context) *Progress {
+	progress, _ := eventctx.ProgressFromContext(ctx).(*Progress)
+	return progress
+}
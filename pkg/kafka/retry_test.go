@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	assert.True(t, IsRetryable(errors.New("connection timeout")))
+	assert.True(t, IsRetryable(fmt.Errorf("query: %w", context.DeadlineExceeded)))
+
+	assert.False(t, IsRetryable(nil))
+	assert.False(t, IsRetryable(Permanent(errors.New("bad data"))))
+	assert.False(t, IsRetryable(context.Canceled))
+	assert.False(t, IsRetryable(fmt.Errorf("plugin errors: %w", errors.Join(
+		&models.ValidationError{EventType: models.EventAddItem, Field: "item_id", Reason: "is required"},
+		Permanent(errors.New("bad data")),
+	))))
+
+	// A transient failure of one plugin is retried even if another plugin
+	// rejected the event
+	assert.True(t, IsRetryable(fmt.Errorf("plugin errors: %w", errors.Join(
+		&models.ValidationError{EventType: models.EventAddItem, Field: "item_id", Reason: "is required"},
+		fmt.Errorf("plugin customer_lookup: %w", context.DeadlineExceeded),
+	))))
+}
+
+func TestBackoff(t *testing.T) {
+	for retry, want := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		3:  4 * time.Second,
+		20: maxRetryDelay,
+	} {
+		for range 10 {
+			delay := backoff(retry, time.Second)
+			assert.GreaterOrEqual(t, delay, want/2, "retry %d", retry)
+			assert.LessOrEqual(t, delay, want, "retry %d", retry)
+		}
+	}
+	assert.Zero(t, backoff(1, 0))
+}
+
+func TestConsumerHandleWithRetry(t *testing.T) {
+	event := &models.Event{ID: "evt-1"}
+
+	var attempts []int
+	c := &Consumer{
+		retryAttempts: 3,
+		retryDelay:    time.Millisecond,
+		handler: func(ctx context.Context, _ *models.Event) error {
+			attempts = append(attempts, eventctx.AttemptFromContext(ctx))
+			if len(attempts) < 3 {
+				return errors.New("connection timeout")
+			}
+			return nil
+		},
+	}
+
+	n, err := c.handleWithRetry(context.Background(), event)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []int{1, 2, 3}, attempts)
+
+	// The attempts share the progress of the event
+	var done []bool
+	c.handler = func(ctx context.Context, _ *models.Event) error {
+		progress := progressFromContext(ctx)
+		done = append(done, progress.Done("plugin"))
+		progress.Complete("plugin")
+		return errors.New("connection timeout")
+	}
+	_, err = c.handleWithRetry(eventctx.ContextWithProgress(context.Background(), NewProgress()), event)
+	assert.Error(t, err)
+	assert.Equal(t, []bool{false, true, true, true}, done)
+
+	// Retries stop after the configured number of attempts
+	c.handler = func(context.Context, *models.Event) error { return errors.New("connection timeout") }
+	n, err = c.handleWithRetry(context.Background(), event)
+	assert.Error(t, err)
+	assert.Equal(t, 4, n)
+
+	// Permanent errors are not retried
+	c.handler = func(context.Context, *models.Event) error { return Permanent(errors.New("bad data")) }
+	n, err = c.handleWithRetry(context.Background(), event)
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestProgress(t *testing.T) {
+	progress := NewProgress()
+	progress.Complete("plugin/evt-1")
+	progress.Store("plugin/evt-1", "result")
+	assert.True(t, progress.Done("plugin/evt-1"))
+	assert.False(t, progress.Done("plugin/evt-2"))
+
+	// An aborted attempt forgets its work but keeps its results
+	progress.Abort()
+	assert.False(t, progress.Done("plugin/evt-1"))
+	result, ok := progress.Load("plugin/evt-1")
+	assert.True(t, ok)
+	assert.Equal(t, "result", result)
+
+	// Outside a transaction committed work is settled right away
+	var settled []bool
+	settle := func(committed bool) { settled = append(settled, committed) }
+	progress.AfterCommit(settle)
+	assert.Equal(t, []bool{true}, settled)
+
+	// In a transaction it is settled when the transaction ends
+	settled = nil
+	progress = NewTransactionalProgress()
+	progress.AfterCommit(settle)
+	assert.Empty(t, settled)
+	progress.Abort()
+	progress.AfterCommit(settle)
+	progress.Commit()
+	progress.Commit()
+	assert.Equal(t, []bool{false, true}, settled)
+
+	// Handlers run outside the consumer have no progress
+	var none *Progress
+	none.Complete("plugin/evt-1")
+	none.Store("plugin/evt-1", "result")
+	assert.False(t, none.Done("plugin/evt-1"))
+	_, ok = none.Load("plugin/evt-1")
+	assert.False(t, ok)
+	settled = nil
+	none.AfterCommit(settle)
+	assert.Equal(t, []bool{true}, settled)
+	assert.Nil(t, progressFromContext(context.Background()))
+}
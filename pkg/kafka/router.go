@@ -0,0 +1,56 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+)
+
+// Router publishes derived events to the topics configured for their type.
+// Types without a route go to the output topic.
+type Router struct {
+	producer      *Producer
+	routes        map[models.EventType][]string
+	defaultTopics []string
+}
+
+// NewRouter creates a router publishing through the given producer
+func NewRouter(producer *Producer, cfg *Config) *Router {
+	r := &Router{producer: producer, routes: cfg.OutputRoutes}
+	if cfg.OutputTopic != "" {
+		r.defaultTopics = []string{cfg.OutputTopic}
+	}
+	return r
+}
+
+// Topics returns the topics an event type is published to
+func (r *Router) Topics(eventType models.EventType) []string {
+	if topics, ok := r.routes[eventType]; ok {
+		return topics
+	}
+	return r.defaultTopics
+}
+
+// SendEvent publishes an event to every topic routed for its type. When an
+// earlier attempt of the event being handled already published it to some of
+// them, only the remaining topics are published to.
+func (r *Router) SendEvent(ctx context.Context, event *models.Event) error {
+	progress := eventctx.ProgressFromContext(ctx)
+
+	var errs []error
+	for _, topic := range r.Topics(event.Type) {
+		key := "publish/" + event.ID + "/" + topic
+		if progress.Done(key) {
+			continue
+		}
+		if err := r.producer.SendEventTo(ctx, topic, event); err != nil {
+			errs = append(errs, fmt.Errorf("failed to publish %s to %s: %v", event.Type, topic, err))
+			continue
+		}
+		progress.Complete(key)
+	}
+	return errors.Join(errs...)
+}
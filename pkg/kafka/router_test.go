@@ -0,0 +1,81 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRoutes(t *testing.T) {
+	routes := parseRoutes("PURCHASE_RECOMMENDATIONS=pos_recommendations|pos_terminals, CUSTOMER_DATA=pos_customers,invalid")
+	assert.Equal(t, map[models.EventType][]string{
+		models.EventPurchaseRecommendations: {"pos_recommendations", "pos_terminals"},
+		models.EventCustomerData:            {"pos_customers"},
+	}, routes)
+
+	assert.Empty(t, parseRoutes(""))
+}
+
+func TestRouterSendEvent(t *testing.T) {
+	var topics []string
+	sync := mocks.NewSyncProducer(t, nil)
+	for range 3 {
+		sync.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			topics = append(topics, msg.Topic)
+			return nil
+		})
+	}
+
+	enc, err := newEncoder(&Config{})
+	assert.NoError(t, err)
+	router := NewRouter(&Producer{producer: sync, encoder: enc}, &Config{
+		OutputTopic: "pos_derived_events",
+		OutputRoutes: map[models.EventType][]string{
+			models.EventPurchaseRecommendations: {"pos_recommendations", "pos_terminals"},
+		},
+	})
+
+	for _, event := range testEvents() {
+		if event.Type == models.EventPurchaseRecommendations || event.Type == models.EventCustomerData {
+			assert.NoError(t, router.SendEvent(t.Context(), event))
+		}
+	}
+
+	// Routed types fan out, other types go to the output topic
+	assert.ElementsMatch(t, []string{"pos_recommendations", "pos_terminals", "pos_derived_events"}, topics)
+	assert.NoError(t, sync.Close())
+}
+
+func TestRouterRetriesFailedTopics(t *testing.T) {
+	var topics []string
+	record := func(msg *sarama.ProducerMessage) error {
+		topics = append(topics, msg.Topic)
+		return nil
+	}
+	sync := mocks.NewSyncProducer(t, nil)
+	sync.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+	sync.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+	sync.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(record)
+
+	enc, err := newEncoder(&Config{})
+	assert.NoError(t, err)
+	router := NewRouter(&Producer{producer: sync, encoder: enc}, &Config{
+		OutputRoutes: map[models.EventType][]string{
+			models.EventPurchaseRecommendations: {"pos_recommendations", "pos_terminals"},
+		},
+	})
+
+	event := &models.Event{ID: "derived", Type: models.EventPurchaseRecommendations, Payload: &models.PurchaseRecommendationsPayload{}}
+	ctx := eventctx.ContextWithProgress(t.Context(), NewProgress())
+	assert.Error(t, router.SendEvent(ctx, event))
+	assert.Equal(t, []string{"pos_recommendations"}, topics)
+
+	// The retry only publishes to the topic that failed
+	assert.NoError(t, router.SendEvent(ctx, event))
+	assert.Equal(t, []string{"pos_recommendations", "pos_terminals"}, topics)
+	assert.NoError(t, sync.Close())
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+)
+
+// writeCertificate writes a self-signed certificate and its key to dir
+func writeCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "pos-consumer"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile = filepath.Join(dir, "client.crt")
+	keyFile = filepath.Join(dir, "client.key")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+func TestNewConfigTLS(t *testing.T) {
+	certFile, keyFile := writeCertificate(t, t.TempDir())
+
+	config, err := newConfig(&Config{
+		TLSEnabled:    true,
+		TLSCAFile:     certFile,
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSServerName: "kafka.internal",
+	})
+	assert.NoError(t, err)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.Equal(t, "kafka.internal", config.Net.TLS.Config.ServerName)
+	assert.Len(t, config.Net.TLS.Config.Certificates, 1)
+	assert.NotNil(t, config.Net.TLS.Config.RootCAs)
+
+	_, err = newConfig(&Config{TLSEnabled: true, TLSCertFile: certFile})
+	assert.ErrorContains(t, err, "client certificate")
+
+	_, err = newConfig(&Config{TLSEnabled: true, TLSCAFile: keyFile})
+	assert.ErrorContains(t, err, "no certificates")
+}
+
+func TestNewConfigSASL(t *testing.T) {
+	config, err := newConfig(&Config{})
+	assert.NoError(t, err)
+	assert.False(t, config.Net.SASL.Enable)
+	assert.False(t, config.Net.TLS.Enable)
+
+	config, err = newConfig(&Config{SASLMechanism: SASLScramSHA512, SASLUsername: "pos", SASLPassword: "secret"})
+	assert.NoError(t, err)
+	assert.True(t, config.Net.SASL.Enable)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), config.Net.SASL.Mechanism)
+	assert.NotNil(t, config.Net.SASL.SCRAMClientGeneratorFunc)
+	assert.NoError(t, config.Validate())
+
+	_, err = newConfig(&Config{SASLMechanism: "GSSAPI", SASLUsername: "pos"})
+	assert.ErrorContains(t, err, "unsupported SASL mechanism")
+
+	_, err = newConfig(&Config{SASLMechanism: SASLPlain})
+	assert.ErrorContains(t, err, "requires a username")
+}
+
+func TestScramClient(t *testing.T) {
+	kf := scram.KeyFactors{Salt: "salt", Iters: 4096}
+	client, err := scram.SHA256.NewClient("pos", "secret", "")
+	assert.NoError(t, err)
+	credentials := client.GetStoredCredentials(kf)
+	server, err := scram.SHA256.NewServer(func(string) (scram.StoredCredentials, error) {
+		return credentials, nil
+	})
+	assert.NoError(t, err)
+	conversation := server.NewConversation()
+
+	// Run the SCRAM exchange as sarama would against the broker
+	c := &scramClient{hash: scram.SHA256}
+	assert.NoError(t, c.Begin("pos", "secret", ""))
+	challenge := ""
+	for !c.Done() {
+		response, err := c.Step(challenge)
+		assert.NoError(t, err)
+		if c.Done() {
+			break
+		}
+		challenge, err = conversation.Step(response)
+		assert.NoError(t, err)
+	}
+	assert.True(t, conversation.Valid())
+}
+
+func TestGetEnvOrFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	assert.NoError(t, os.WriteFile(path, []byte("secret\n"), 0o600))
+
+	t.Setenv("KAFKA_SASL_PASSWORD_FILE", path)
+	assert.Equal(t, "secret", getEnvOrFile("KAFKA_SASL_PASSWORD"))
+
+	t.Setenv("KAFKA_SASL_PASSWORD", "from-env")
+	assert.Equal(t, "from-env", getEnvOrFile("KAFKA_SASL_PASSWORD"))
+}
@@ -0,0 +1,125 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+)
+
+// topicLister looks up the topics of the cluster, implemented by sarama.Client
+type topicLister interface {
+	RefreshMetadata(topics ...string) error
+	Topics() ([]string, error)
+}
+
+// subscribedTopics returns the topics the consumer reads when no pattern is
+// configured: the configured list, or the default topic and every topic the
+// producer routes events to
+func subscribedTopics(cfg *Config) []string {
+	if len(cfg.Topics) > 0 {
+		return cfg.Topics
+	}
+
+	topics := []string{cfg.Topic}
+	for _, topic := range cfg.TopicRoutes {
+		if !slices.Contains(topics, topic) {
+			topics = append(topics, topic)
+		}
+	}
+	slices.Sort(topics[1:])
+	return topics
+}
+
+// forwardingTopics returns the topics the consumer itself writes to, which a
+// topic pattern must never subscribe to
+func forwardingTopics(cfg *Config) map[string]bool {
+	topics := map[string]bool{
+		cfg.LateTopic:       true,
+		cfg.DeadLetterTopic: true,
+		cfg.OutputTopic:     true,
+	}
+	for _, routed := range cfg.OutputRoutes {
+		for _, topic := range routed {
+			topics[topic] = true
+		}
+	}
+	delete(topics, "")
+	return topics
+}
+
+// matchTopics returns the sorted topics matching the pattern, skipping
+// internal topics and the excluded ones
+func matchTopics(lister topicLister, pattern *regexp.Regexp, excluded map[string]bool) ([]string, error) {
+	if err := lister.RefreshMetadata(); err != nil {
+		return nil, fmt.Errorf("failed to refresh metadata: %v", err)
+	}
+	all, err := lister.Topics()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list topics: %v", err)
+	}
+
+	var topics []string
+	for _, topic := range all {
+		if strings.HasPrefix(topic, "__") || excluded[topic] || !pattern.MatchString(topic) {
+			continue
+		}
+		topics = append(topics, topic)
+	}
+	slices.Sort(topics)
+	return topics, nil
+}
+
+// subscription returns the topics to consume in the next session
+func (c *Consumer) subscription() ([]string, error) {
+	if c.pattern == nil {
+		return c.topics, nil
+	}
+
+	topics, err := matchTopics(c.lister, c.pattern, c.excluded)
+	if err != nil {
+		return nil, err
+	}
+	if len(topics) == 0 {
+		return nil, fmt.Errorf("no topics match %q", c.pattern)
+	}
+	return topics, nil
+}
+
+// watchTopics ends the session when the topics matching the pattern change,
+// so the consumer resubscribes to the new set
+func (c *Consumer) watchTopics(ctx context.Context, current []string, resubscribe context.CancelFunc) {
+	ticker := time.NewTicker(c.topicRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			topics, err := matchTopics(c.lister, c.pattern, c.excluded)
+			if err != nil {
+				log.Printf("Error refreshing topics: %v", err)
+				continue
+			}
+			if !slices.Equal(topics, current) {
+				log.Printf("Topics matching %q changed to %v, resubscribing", c.pattern, topics)
+				resubscribe()
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// topicFor returns the topic the producer sends an event to
+func (p *Producer) topicFor(eventType models.EventType) string {
+	if topic, ok := p.routes[eventType]; ok {
+		return topic
+	}
+	return p.topic
+}
@@ -0,0 +1,100 @@
+package kafka
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeLister struct {
+	topics []string
+	err    error
+}
+
+func (l *fakeLister) RefreshMetadata(...string) error { return l.err }
+func (l *fakeLister) Topics() ([]string, error)       { return l.topics, nil }
+
+func TestSubscribedTopics(t *testing.T) {
+	cfg := &Config{
+		Topic: "pos_events",
+		TopicRoutes: map[models.EventType]string{
+			models.EventPaymentComplete: "pos_payments",
+			models.EventEmployeeLogin:   "pos_logins",
+			models.EventAddItem:         "pos_events",
+		},
+	}
+	assert.Equal(t, []string{"pos_events", "pos_logins", "pos_payments"}, subscribedTopics(cfg))
+
+	cfg.Topics = []string{"a", "b"}
+	assert.Equal(t, []string{"a", "b"}, subscribedTopics(cfg))
+}
+
+func TestParseTopicRoutes(t *testing.T) {
+	routes := parseTopicRoutes("PAYMENT_COMPLETE=pos_payments,ADD_ITEM=a|b")
+	assert.Equal(t, map[models.EventType]string{models.EventPaymentComplete: "pos_payments"}, routes)
+}
+
+func TestMatchTopics(t *testing.T) {
+	lister := &fakeLister{topics: []string{"pos_events_us", "__consumer_offsets", "pos_events_eu", "pos_events_dlq", "inventory"}}
+	excluded := forwardingTopics(&Config{DeadLetterTopic: "pos_events_dlq", OutputTopic: "pos_derived_events"})
+
+	topics, err := matchTopics(lister, regexp.MustCompile("^pos_events"), excluded)
+	assert.NoError(t, err)
+	// Internal and forwarding topics are never subscribed to
+	assert.Equal(t, []string{"pos_events_eu", "pos_events_us"}, topics)
+
+	lister.err = errors.New("broker down")
+	_, err = matchTopics(lister, regexp.MustCompile("^pos_events"), excluded)
+	assert.Error(t, err)
+}
+
+func TestSubscription(t *testing.T) {
+	c := &Consumer{topics: []string{"pos_events"}}
+	topics, err := c.subscription()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pos_events"}, topics)
+
+	c.pattern = regexp.MustCompile("^pos_events_")
+	c.lister = &fakeLister{topics: []string{"pos_events_eu"}}
+	topics, err = c.subscription()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"pos_events_eu"}, topics)
+
+	c.lister = &fakeLister{topics: []string{"pos_events"}}
+	_, err = c.subscription()
+	assert.ErrorContains(t, err, "no topics match")
+}
+
+func TestProducerRoutesByType(t *testing.T) {
+	var topics []string
+	sync := mocks.NewSyncProducer(t, nil)
+	for range 2 {
+		sync.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			topics = append(topics, msg.Topic)
+			return nil
+		})
+	}
+
+	enc, err := newEncoder(&Config{})
+	assert.NoError(t, err)
+	p := &Producer{
+		producer: sync,
+		topic:    "pos_events",
+		encoder:  enc,
+		routes:   map[models.EventType]string{models.EventPaymentComplete: "pos_payments"},
+	}
+
+	for _, event := range testEvents() {
+		if event.Type == models.EventPaymentComplete || event.Type == models.EventAddItem {
+			assert.NoError(t, p.SendEvent(t.Context(), event))
+		}
+	}
+
+	assert.ElementsMatch(t, []string{"pos_payments", "pos_events"}, topics)
+	assert.NoError(t, sync.Close())
+}
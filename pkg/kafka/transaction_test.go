@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/Piyushhbhutoria/tote-assignment/pkg/eventctx"
+	"github.com/stretchr/testify/assert"
+)
+
+// txnRecorder records the transaction calls made on a mock producer
+type txnRecorder struct {
+	*mocks.SyncProducer
+	calls []string
+}
+
+func (r *txnRecorder) BeginTxn() error {
+	r.calls = append(r.calls, "begin")
+	return r.SyncProducer.BeginTxn()
+}
+
+func (r *txnRecorder) CommitTxn() error {
+	r.calls = append(r.calls, "commit")
+	return r.SyncProducer.CommitTxn()
+}
+
+func (r *txnRecorder) AbortTxn() error {
+	r.calls = append(r.calls, "abort")
+	return r.SyncProducer.AbortTxn()
+}
+
+func (r *txnRecorder) AddMessageToTxn(msg *sarama.ConsumerMessage, groupID string, metadata *string) error {
+	r.calls = append(r.calls, "offset")
+	return r.SyncProducer.AddMessageToTxn(msg, groupID, metadata)
+}
+
+func transactionalConsumer(t *testing.T, handler MessageHandler) (*Consumer, *txnRecorder) {
+	config, err := producerConfig(&Config{RetryAttempts: 3, Idempotent: true})
+	assert.NoError(t, err)
+	config.Producer.Transaction.ID = "pos_consumer_group-test"
+	txn := &txnRecorder{SyncProducer: mocks.NewSyncProducer(t, config)}
+
+	enc, err := newEncoder(&Config{})
+	assert.NoError(t, err)
+
+	forwarder := &Producer{producer: txn, topic: "pos_derived_events", encoder: enc}
+	return &Consumer{
+		handler:         handler,
+		deadLetterTopic: "pos_events_dlq",
+		transactional:   true,
+		group:           "pos_consumer_group",
+		forwarder:       forwarder,
+		emitter:         NewRouter(forwarder, &Config{OutputTopic: "pos_derived_events"}),
+		lag:             newLagTracker(),
+	}, txn
+}
+
+func TestConsumerTransactionalCommit(t *testing.T) {
+	event := testEvents()[0]
+	value, err := JSONCodec{}.Encode(event)
+	assert.NoError(t, err)
+	message := &sarama.ConsumerMessage{Topic: "pos_events", Value: value}
+
+	c, txn := transactionalConsumer(t, func(ctx context.Context, event *models.Event) error {
+		return eventctx.EmitterFromContext(ctx).SendEvent(ctx, &models.Event{ID: "derived", Type: event.Type, Payload: event.Payload})
+	})
+	txn.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "pos_derived_events", msg.Topic)
+		return nil
+	})
+
+	// Derived events and the offset are committed in one transaction
+	session := &markingSession{}
+	assert.NoError(t, c.process(session, message))
+	assert.Equal(t, []string{"begin", "offset", "commit"}, txn.calls)
+	assert.Empty(t, session.marked)
+	assert.NoError(t, txn.Close())
+}
+
+func TestConsumerTransactionalAbort(t *testing.T) {
+	event := testEvents()[0]
+	value, err := JSONCodec{}.Encode(event)
+	assert.NoError(t, err)
+	message := &sarama.ConsumerMessage{Topic: "pos_events", Value: value}
+
+	c, txn := transactionalConsumer(t, func(ctx context.Context, event *models.Event) error {
+		if err := eventctx.EmitterFromContext(ctx).SendEvent(ctx, &models.Event{ID: "derived", Type: event.Type, Payload: event.Payload}); err != nil {
+			return err
+		}
+		return Permanent(assert.AnError)
+	})
+	txn.ExpectSendMessageAndSucceed()
+	txn.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+		assert.Equal(t, "pos_events_dlq", msg.Topic)
+		return nil
+	})
+
+	// The derived event is discarded and the message dead-lettered in a new transaction
+	assert.NoError(t, c.process(&markingSession{}, message))
+	assert.Equal(t, []string{"begin", "abort", "begin", "offset", "commit"}, txn.calls)
+	assert.NoError(t, txn.Close())
+}
@@ -0,0 +1,250 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/IBM/sarama"
+)
+
+// Transports selectable with Config.Transport
+const (
+	// TransportKafka connects to the Kafka brokers
+	TransportKafka = "kafka"
+	// TransportMemory runs on an in-process broker, so producers and
+	// consumers in one process work without Kafka
+	TransportMemory = "memory"
+	// TransportPostgres queues messages in a Postgres table
+	TransportPostgres = "postgres"
+)
+
+// Transport carries messages between producers and consumers. Consumed
+// messages are acknowledged through the session passed to the handler, by
+// marking and committing them as with a Kafka consumer group.
+type Transport interface {
+	// Publish stores a message and sets its partition and offset
+	Publish(ctx context.Context, msg *sarama.ProducerMessage) error
+	// Subscribe consumes the topics as a member of the transport's consumer
+	// group. Like sarama.ConsumerGroup.Consume it runs a single session and
+	// returns when the context is done or the partitions are rebalanced.
+	Subscribe(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error
+	// Close leaves the consumer group and releases the connections
+	Close() error
+}
+
+// newTransport connects to the transport selected by cfg. The sarama
+// configuration is only used by the kafka transport.
+func newTransport(cfg *Config, config *sarama.Config) (Transport, error) {
+	switch cfg.Transport {
+	case "", TransportKafka:
+		return newKafkaTransport(cfg, config)
+	case TransportMemory:
+		return cfg.memoryBroker().Transport(cfg.ConsumerGroup, cfg.Partitions), nil
+	case TransportPostgres:
+		return newPostgresTransport(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported transport %q", cfg.Transport)
+	}
+}
+
+// kafkaTransport is the Transport of a Kafka cluster. Its consumer group and
+// producer share one client.
+type kafkaTransport struct {
+	client    sarama.Client
+	group     sarama.ConsumerGroup
+	groupName string
+
+	producerOnce sync.Once
+	producer     sarama.SyncProducer
+	producerErr  error
+}
+
+// newKafkaTransport connects to the brokers as a member of the consumer group
+func newKafkaTransport(cfg *Config, config *sarama.Config) (*kafkaTransport, error) {
+	// Required by the producer Publish creates from the shared client
+	config.Producer.Return.Successes = true
+
+	client, err := sarama.NewClient(cfg.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create client: %v", err)
+	}
+
+	group, err := sarama.NewConsumerGroupFromClient(cfg.ConsumerGroup, client)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to create consumer group: %v", err)
+	}
+	return &kafkaTransport{client: client, group: group, groupName: cfg.ConsumerGroup}, nil
+}
+
+// Publish sends a message with a producer created on first use
+func (t *kafkaTransport) Publish(_ context.Context, msg *sarama.ProducerMessage) error {
+	t.producerOnce.Do(func() {
+		t.producer, t.producerErr = sarama.NewSyncProducerFromClient(t.client)
+	})
+	if t.producerErr != nil {
+		return fmt.Errorf("failed to create producer: %v", t.producerErr)
+	}
+
+	_, _, err := t.producer.SendMessage(msg)
+	return err
+}
+
+func (t *kafkaTransport) Subscribe(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	return t.group.Consume(ctx, topics, handler)
+}
+
+func (t *kafkaTransport) RefreshMetadata(topics ...string) error {
+	return t.client.RefreshMetadata(topics...)
+}
+
+func (t *kafkaTransport) Topics() ([]string, error) {
+	return t.client.Topics()
+}
+
+// OffsetForTime asks the brokers for the first offset at or after the time
+func (t *kafkaTransport) OffsetForTime(topic string, partition int32, at time.Time) (int64, error) {
+	offset, err := t.client.GetOffset(topic, partition, at.UnixMilli())
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset: %v", err)
+	}
+	if offset >= 0 {
+		return offset, nil
+	}
+
+	// No message at or after the time
+	offset, err = t.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get offset: %v", err)
+	}
+	return offset, nil
+}
+
+// OffsetRange asks the brokers for the oldest offset a partition retains and
+// its newest offset
+func (t *kafkaTransport) OffsetRange(topic string, partition int32) (int64, int64, error) {
+	oldest, err := t.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get offset: %v", err)
+	}
+	newest, err := t.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get offset: %v", err)
+	}
+	return oldest, newest, nil
+}
+
+// FetchOffsets asks the brokers for the newest offsets of the partitions and
+// the group coordinator for the offsets the group committed
+func (t *kafkaTransport) FetchOffsets(partitions []TopicPartition) (map[TopicPartition]partitionOffsets, error) {
+	if len(partitions) == 0 {
+		return nil, nil
+	}
+
+	coordinator, err := t.client.Coordinator(t.groupName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find group coordinator: %v", err)
+	}
+	requested := make(map[string][]int32)
+	for _, tp := range partitions {
+		requested[tp.Topic] = append(requested[tp.Topic], tp.Partition)
+	}
+	config := t.client.Config()
+	resp, err := coordinator.FetchOffset(sarama.NewOffsetFetchRequest(config.Version, t.groupName, requested))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch committed offsets: %v", err)
+	}
+
+	offsets := make(map[TopicPartition]partitionOffsets, len(partitions))
+	for _, tp := range partitions {
+		hwm, err := t.client.GetOffset(tp.Topic, tp.Partition, sarama.OffsetNewest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get offset: %v", err)
+		}
+
+		committed := int64(-1)
+		if block := resp.GetBlock(tp.Topic, tp.Partition); block != nil {
+			if block.Err != sarama.ErrNoError {
+				return nil, fmt.Errorf("failed to fetch committed offset of %s/%d: %v", tp.Topic, tp.Partition, block.Err)
+			}
+			committed = block.Offset
+		}
+		if committed < 0 {
+			// Nothing committed, the group starts at the initial offset
+			if committed, err = t.client.GetOffset(tp.Topic, tp.Partition, config.Consumer.Offsets.Initial); err != nil {
+				return nil, fmt.Errorf("failed to get offset: %v", err)
+			}
+		}
+		offsets[tp] = partitionOffsets{highWaterMark: hwm, committed: committed}
+	}
+	return offsets, nil
+}
+
+func (t *kafkaTransport) Close() error {
+	if t.producer != nil {
+		if err := t.producer.Close(); err != nil {
+			return err
+		}
+	}
+	if err := t.group.Close(); err != nil {
+		return err
+	}
+	// Consumer groups created from a client leave it open
+	return t.client.Close()
+}
+
+// transportProducer adapts a Transport to the sarama.SyncProducer used by
+// Producer. It does not support transactions.
+type transportProducer struct {
+	transport Transport
+}
+
+func (p *transportProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	if err := p.transport.Publish(context.Background(), msg); err != nil {
+		return -1, -1, err
+	}
+	return msg.Partition, msg.Offset, nil
+}
+
+func (p *transportProducer) SendMessages(msgs []*sarama.ProducerMessage) error {
+	for _, msg := range msgs {
+		if _, _, err := p.SendMessage(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *transportProducer) Close() error {
+	return p.transport.Close()
+}
+
+func (p *transportProducer) TxnStatus() sarama.ProducerTxnStatusFlag {
+	return sarama.ProducerTxnFlagReady
+}
+
+func (p *transportProducer) IsTransactional() bool {
+	return false
+}
+
+func (p *transportProducer) BeginTxn() error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *transportProducer) CommitTxn() error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *transportProducer) AbortTxn() error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *transportProducer) AddOffsetsToTxn(map[string][]*sarama.PartitionOffsetMetadata, string) error {
+	return sarama.ErrNonTransactedProducer
+}
+
+func (p *transportProducer) AddMessageToTxn(*sarama.ConsumerMessage, string, *string) error {
+	return sarama.ErrNonTransactedProducer
+}
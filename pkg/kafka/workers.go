@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/IBM/sarama"
+)
+
+// workerQueueSize is the number of messages buffered per worker, bounding
+// how far a partition is read ahead of its slowest key
+const workerQueueSize = 16
+
+// orderedSession marks the offsets of a partition whose messages are
+// processed in parallel. A message is only marked once every message before
+// it is done, so a commit never skips a message that is still in flight.
+type orderedSession struct {
+	sarama.ConsumerGroupSession
+	lag *lagTracker
+
+	mu sync.Mutex
+	// inFlight holds the dispatched messages in offset order, down to the
+	// oldest one that is not done
+	inFlight []*sarama.ConsumerMessage
+	// pending records whether each in-flight offset is done
+	pending map[int64]bool
+}
+
+func newOrderedSession(session sarama.ConsumerGroupSession, lag *lagTracker) *orderedSession {
+	return &orderedSession{ConsumerGroupSession: session, lag: lag, pending: make(map[int64]bool)}
+}
+
+// dispatched records a message handed to a worker
+func (s *orderedSession) dispatched(message *sarama.ConsumerMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inFlight = append(s.inFlight, message)
+	s.pending[message.Offset] = false
+}
+
+// done records that a message was processed and marks the newest message
+// up to which all messages are done
+func (s *orderedSession) done(message *sarama.ConsumerMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.pending[message.Offset]; !ok {
+		// Already marked
+		return
+	}
+	s.pending[message.Offset] = true
+
+	var last *sarama.ConsumerMessage
+	for len(s.inFlight) > 0 && s.pending[s.inFlight[0].Offset] {
+		last = s.inFlight[0]
+		delete(s.pending, last.Offset)
+		s.inFlight = s.inFlight[1:]
+	}
+	if last != nil {
+		s.ConsumerGroupSession.MarkMessage(last, "")
+		s.lag.mark(last)
+	}
+}
+
+// mark marks a message as consumed. Messages processed in parallel are
+// marked once all messages before them in the partition are done.
+func (c *Consumer) mark(session sarama.ConsumerGroupSession, message *sarama.ConsumerMessage) {
+	if ordered, ok := session.(*orderedSession); ok {
+		ordered.done(message)
+		return
+	}
+	session.MarkMessage(message, "")
+	c.lag.mark(message)
+}
+
+// consumeParallel processes the messages of a partition on a pool of
+// workers. Messages with the same key always go to the same worker, so
+// events of a basket or terminal are processed in order while other keys
+// are not held up by them.
+func (c *Consumer) consumeParallel(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	ctx := session.Context()
+	tp := TopicPartition{Topic: claim.Topic(), Partition: claim.Partition()}
+	ordered := newOrderedSession(session, c.lag)
+
+	var wg sync.WaitGroup
+	queues := make([]chan *sarama.ConsumerMessage, c.workers)
+	for i := range queues {
+		queues[i] = make(chan *sarama.ConsumerMessage, workerQueueSize)
+		wg.Add(1)
+		go func(queue <-chan *sarama.ConsumerMessage) {
+			defer wg.Done()
+			for message := range queue {
+				// Shutting down, the remaining messages are redelivered
+				if ctx.Err() != nil {
+					continue
+				}
+				// Messages are done once process committed them, a message
+				// that is not holds back the offsets after it
+				if err := c.process(ordered, message); err != nil {
+					log.Printf("Error processing message at offset %d, redelivering: %v", message.Offset, err)
+					c.restartSession()
+				}
+			}
+		}(queues[i])
+	}
+	defer func() {
+		for _, queue := range queues {
+			close(queue)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		if !c.pauses.wait(ctx, tp) {
+			return nil
+		}
+
+		select {
+		case message, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+
+			c.lag.observe(message, claim.HighWaterMarkOffset())
+			ordered.dispatched(message)
+			select {
+			case queues[workerFor(message, c.workers)] <- message:
+			case <-ctx.Done():
+				return nil
+			}
+
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// workerFor returns the worker processing a message. Messages without a key
+// have no order to keep and are spread by offset.
+func workerFor(message *sarama.ConsumerMessage, workers int) int {
+	if len(message.Key) == 0 {
+		return int(message.Offset % int64(workers))
+	}
+	h := fnv.New32a()
+	h.Write(message.Key)
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/IBM/sarama/mocks"
+	"github.com/Piyushhbhutoria/tote-assignment/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderedSessionMarksLowestDone(t *testing.T) {
+	inner := &markingSession{}
+	lag := newLagTracker()
+	session := newOrderedSession(inner, lag)
+
+	messages := make([]*sarama.ConsumerMessage, 3)
+	for i := range messages {
+		messages[i] = &sarama.ConsumerMessage{Topic: "pos_events", Offset: int64(i)}
+		session.dispatched(messages[i])
+	}
+
+	// Nothing is marked while an earlier message is in flight
+	session.done(messages[2])
+	assert.Empty(t, inner.marked)
+
+	session.done(messages[0])
+	assert.Equal(t, []*sarama.ConsumerMessage{messages[0]}, inner.marked)
+
+	// Marking a message twice has no effect
+	session.done(messages[0])
+	assert.Len(t, inner.marked, 1)
+
+	session.done(messages[1])
+	assert.Equal(t, []*sarama.ConsumerMessage{messages[0], messages[2]}, inner.marked)
+	assert.Equal(t, int64(3), lag.snapshot()[0].Marked)
+}
+
+func TestWorkerFor(t *testing.T) {
+	keyed := func(key string, offset int64) *sarama.ConsumerMessage {
+		return &sarama.ConsumerMessage{Key: []byte(key), Offset: offset}
+	}
+
+	// Messages with the same key always go to the same worker
+	for offset := range int64(10) {
+		assert.Equal(t, workerFor(keyed("STORE001/POS001", 0), 4), workerFor(keyed("STORE001/POS001", offset), 4))
+	}
+
+	// Messages without a key are spread over the workers
+	workers := make(map[int]bool)
+	for offset := range int64(4) {
+		workers[workerFor(keyed("", offset), 4)] = true
+	}
+	assert.Len(t, workers, 4)
+}
+
+func TestConsumerWorkersKeepKeyOrder(t *testing.T) {
+	broker := NewMemoryBroker()
+	cfg := memoryConfig(broker)
+	cfg.Partitions = 1
+	cfg.Workers = 4
+
+	producer, err := NewProducer(cfg)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer producer.Close()
+
+	// Both terminals share the partition, the first one is published first
+	for _, terminal := range []string{"POS001", "POS002"} {
+		for i := range 3 {
+			event := &models.Event{
+				ID:        fmt.Sprintf("%s-%d", terminal, i),
+				Type:      models.EventEmployeeLogin,
+				Version:   models.CurrentVersion(models.EventEmployeeLogin),
+				Timestamp: time.Now(),
+				Payload: &models.EmployeeLoginPayload{
+					BasePayload: models.BasePayload{TerminalID: terminal, StoreID: "STORE001"},
+					EmployeeID:  "EMP001",
+				},
+			}
+			assert.NoError(t, producer.SendEvent(t.Context(), event))
+		}
+	}
+
+	var mu sync.Mutex
+	handled := make(map[string][]string)
+	release := make(chan struct{})
+	consumer, err := NewConsumer(cfg, func(ctx context.Context, event *models.Event) error {
+		terminal := event.Scope().TerminalID
+		if event.ID == "POS001-0" {
+			// A slow event holds up its own terminal only
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		handled[terminal] = append(handled[terminal], event.ID)
+		return nil
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		consumer.Start(ctx)
+	}()
+	defer func() {
+		cancel()
+		<-done
+		consumer.Close()
+	}()
+
+	count := func(terminal string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled[terminal])
+	}
+	assert.Eventually(t, func() bool { return count("POS002") == 3 }, 5*time.Second, time.Millisecond)
+
+	// The first message is still in flight, so no offset is committed yet
+	assert.Equal(t, int64(0), broker.committed("pos_consumer_group", "pos_events", 0))
+
+	close(release)
+	assert.Eventually(t, func() bool { return count("POS001") == 3 }, 5*time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return broker.committed("pos_consumer_group", "pos_events", 0) == 6
+	}, 5*time.Second, time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"POS001-0", "POS001-1", "POS001-2"}, handled["POS001"])
+	assert.Equal(t, []string{"POS002-0", "POS002-1", "POS002-2"}, handled["POS002"])
+}
+
+func TestConsumerWorkersHoldBackFailedMessages(t *testing.T) {
+	events := testEvents()[:2]
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(sarama.ErrOutOfBrokers)
+
+	second := make(chan struct{})
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	c := &Consumer{
+		workers: 2,
+		handler: func(_ context.Context, event *models.Event) error {
+			if event.ID != events[0].ID {
+				close(second)
+				return nil
+			}
+			<-second
+			return Permanent(assert.AnError)
+		},
+		deadLetterTopic: "pos_events_dlq",
+		forwarder:       &Producer{producer: producer},
+		lag:             newLagTracker(),
+		endSession:      cancel,
+	}
+
+	// The later message is processed first, but its offset is not marked
+	// past the failed one and the session ends to redeliver both
+	session := &markingSession{ctx: ctx}
+	assert.NoError(t, c.ConsumeClaim(session, newMessageClaim(t, events...)))
+	assert.Empty(t, session.marked)
+	assert.Error(t, ctx.Err())
+	assert.NoError(t, producer.Close())
+}
+
+func TestConsumerRejectsTransactionalWorkers(t *testing.T) {
+	cfg := memoryConfig(NewMemoryBroker())
+	cfg.Transport = TransportKafka
+	cfg.Transactional = true
+	cfg.Workers = 4
+
+	_, err := NewConsumer(cfg, nil)
+	assert.Error(t, err)
+}
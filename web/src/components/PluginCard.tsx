@@ -1,5 +1,5 @@
 import { Switch } from '@headlessui/react';
-import { ChartBarIcon, ClockIcon, ExclamationCircleIcon } from '@heroicons/react/24/outline';
+import { ArrowPathIcon, ChartBarIcon, ClockIcon, DocumentDuplicateIcon, ExclamationCircleIcon } from '@heroicons/react/24/outline';
 import React, { useState } from 'react';
 import { PluginWithStats } from '../types/plugin';
 
@@ -33,6 +33,16 @@ export const PluginCard: React.FC<PluginCardProps> = ({
         <div>
           <h3 className="text-lg font-semibold text-gray-900">{plugin.name}</h3>
           <p className="text-sm text-gray-500">{plugin.description}</p>
+          <div className="flex flex-wrap gap-1 mt-2">
+            {(plugin.eventTypes ?? ['All events']).map((eventType) => (
+              <span
+                key={eventType}
+                className="text-xs font-medium text-indigo-700 bg-indigo-50 rounded px-2 py-0.5"
+              >
+                {eventType}
+              </span>
+            ))}
+          </div>
         </div>
         <Switch
           checked={plugin.isActive}
@@ -53,7 +63,7 @@ export const PluginCard: React.FC<PluginCardProps> = ({
         </Switch>
       </div>
 
-      <div className="grid grid-cols-3 gap-4 pt-4 border-t border-gray-100">
+      <div className="grid grid-cols-5 gap-4 pt-4 border-t border-gray-100">
         <div className="flex items-center space-x-2">
           <ChartBarIcon className="h-5 w-5 text-gray-400" />
           <div>
@@ -85,6 +95,26 @@ export const PluginCard: React.FC<PluginCardProps> = ({
             <p className="text-xs text-gray-500">Errors</p>
           </div>
         </div>
+
+        <div className="flex items-center space-x-2">
+          <ArrowPathIcon className="h-5 w-5 text-gray-400" />
+          <div>
+            <p className="text-sm font-medium text-gray-900">
+              {plugin.stats.retryCount}
+            </p>
+            <p className="text-xs text-gray-500">Retries</p>
+          </div>
+        </div>
+
+        <div className="flex items-center space-x-2">
+          <DocumentDuplicateIcon className="h-5 w-5 text-gray-400" />
+          <div>
+            <p className="text-sm font-medium text-gray-900">
+              {plugin.stats.duplicateCount}
+            </p>
+            <p className="text-xs text-gray-500">Duplicates</p>
+          </div>
+        </div>
       </div>
 
       {Object.keys(plugin.config).length > 0 && (
@@ -3,12 +3,16 @@ export interface Plugin {
   description: string;
   isActive: boolean;
   config: Record<string, any>;
+  // null when the plugin receives every event
+  eventTypes: string[] | null;
 }
 
 export interface PluginStats {
   eventsProcessed: number;
   lastProcessed: string | null;
   errorCount: number;
+  retryCount: number;
+  duplicateCount: number;
 }
 
 export interface PluginWithStats extends Plugin {